@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+
+	"github.com/r2dtools/agentintegration"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+// Types of the agent responses
+type (
+	// Error is returned if the agent fails to execute a command. Errors of agents not supporting
+	// protocol version 2 have the internal_error code.
+	Error        = protocol.Error
+	Event        = protocol.Event
+	Job          = protocol.Job
+	Capabilities = protocol.Capabilities
+	ServerStats  = protocol.ServerStats
+	RotatedToken = protocol.RotatedToken
+	Health       = protocol.Health
+	// Reporter receives progress events of a command
+	Reporter   = func(event Event)
+	AuditEntry = protocol.AuditEntry
+	// AuditLogFilter selects audit log entries, Since and Until are RFC 3339 timestamps
+	AuditLogFilter = protocol.AuditLogFilter
+)
+
+const (
+	ErrorCodeInternal              = protocol.ErrorCodeInternal
+	ErrorCodeInvalidRequest        = protocol.ErrorCodeInvalidRequest
+	ErrorCodeNotFound              = protocol.ErrorCodeNotFound
+	ErrorCodeUnauthorized          = protocol.ErrorCodeUnauthorized
+	ErrorCodeForbidden             = protocol.ErrorCodeForbidden
+	ErrorCodeRateLimited           = protocol.ErrorCodeRateLimited
+	ErrorCodeRequestTooLarge       = protocol.ErrorCodeRequestTooLarge
+	ErrorCodeShuttingDown          = protocol.ErrorCodeShuttingDown
+	ErrorCodeTimeout               = protocol.ErrorCodeTimeout
+	ErrorCodeCancelled             = protocol.ErrorCodeCancelled
+	ErrorCodeAcmeValidationFailed  = protocol.ErrorCodeAcmeValidationFailed
+	ErrorCodeDeployFailed          = protocol.ErrorCodeDeployFailed
+	ErrorCodeWebServerReloadFailed = protocol.ErrorCodeWebServerReloadFailed
+	ErrorCodeRollbackFailed        = protocol.ErrorCodeRollbackFailed
+)
+
+const (
+	JobStatusQueued    = protocol.JobStatusQueued
+	JobStatusRunning   = protocol.JobStatusRunning
+	JobStatusSucceeded = protocol.JobStatusSucceeded
+	JobStatusFailed    = protocol.JobStatusFailed
+	JobStatusCancelled = protocol.JobStatusCancelled
+)
+
+// GetServerData returns information about the server the agent is running on
+func (c *Client) GetServerData(ctx context.Context) (*agentintegration.ServerData, error) {
+	var result agentintegration.ServerData
+
+	if err := c.Do(ctx, "main.refresh", nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) GetVhosts(ctx context.Context) ([]agentintegration.VirtualHost, error) {
+	var result []agentintegration.VirtualHost
+	err := c.Do(ctx, "main.getVhosts", nil, &result)
+
+	return result, err
+}
+
+// GetVhostCertificate returns the certificate served by the virtual host
+func (c *Client) GetVhostCertificate(ctx context.Context, vhostName string) (*agentintegration.Certificate, error) {
+	var result agentintegration.Certificate
+
+	if err := c.Do(ctx, "main.getVhostCertificate", map[string]interface{}{"vhostName": vhostName}, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) GetVhostConfig(ctx context.Context, data agentintegration.VirtualHostConfigRequestData) (string, error) {
+	var result agentintegration.VirtualHostConfigResponseData
+	err := c.Do(ctx, "main.getvhostconfig", data, &result)
+
+	return result.Content, err
+}
+
+// RotateToken replaces the token of the agent. The client keeps using the previous token until it is updated.
+func (c *Client) RotateToken(ctx context.Context) (*RotatedToken, error) {
+	var result RotatedToken
+
+	if err := c.Do(ctx, "main.rotateToken", nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) GetStats(ctx context.Context) (*ServerStats, error) {
+	var result ServerStats
+
+	if err := c.Do(ctx, "main.stats", nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) GetCapabilities(ctx context.Context) (*Capabilities, error) {
+	var result Capabilities
+
+	if err := c.Do(ctx, "main.capabilities", nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// IssueCertificate obtains the certificate and deploys it if requested. The reporter receives progress events if it is not nil.
+func (c *Client) IssueCertificate(ctx context.Context, data agentintegration.CertificateIssueRequestData, reporter Reporter) (*agentintegration.Certificate, error) {
+	return c.doCertificate(ctx, "certificates.issue", data, reporter)
+}
+
+// UploadCertificate stores the PEM certificate and deploys it to the domain
+func (c *Client) UploadCertificate(ctx context.Context, data agentintegration.CertificateUploadRequestData, reporter Reporter) (*agentintegration.Certificate, error) {
+	return c.doCertificate(ctx, "certificates.upload", data, reporter)
+}
+
+// AssignCertificate deploys the stored certificate to the domain
+func (c *Client) AssignCertificate(ctx context.Context, data agentintegration.CertificateAssignRequestData, reporter Reporter) (*agentintegration.Certificate, error) {
+	return c.doCertificate(ctx, "certificates.domainassign", data, reporter)
+}
+
+// GetStorageCertificates returns certificates stored by the agent by their names
+func (c *Client) GetStorageCertificates(ctx context.Context) (map[string]*agentintegration.Certificate, error) {
+	var result agentintegration.CertificatesResponseData
+	err := c.Do(ctx, "certificates.storagecertificates", nil, &result)
+
+	return result.Certificates, err
+}
+
+func (c *Client) GetStorageCertificate(ctx context.Context, certName string) (*agentintegration.Certificate, error) {
+	return c.doCertificate(ctx, "certificates.storagecertdata", certName, nil)
+}
+
+// UploadStorageCertificate stores the PEM certificate without deploying it
+func (c *Client) UploadStorageCertificate(ctx context.Context, data agentintegration.CertificateUploadRequestData) (*agentintegration.Certificate, error) {
+	return c.doCertificate(ctx, "certificates.storagecertupload", data, nil)
+}
+
+func (c *Client) RemoveStorageCertificate(ctx context.Context, certName string) error {
+	return c.Do(ctx, "certificates.storagecertremove", certName, nil)
+}
+
+// DownloadStorageCertificate returns the file name and PEM content of the stored certificate
+func (c *Client) DownloadStorageCertificate(ctx context.Context, certName string) (*agentintegration.CertificateDownloadResponseData, error) {
+	var result agentintegration.CertificateDownloadResponseData
+
+	if err := c.Do(ctx, "certificates.storagecertdownload", certName, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetCommonDirStatus reports whether the common directory for ACME challenges is enabled for the domain
+func (c *Client) GetCommonDirStatus(ctx context.Context, data agentintegration.CommonDirStatusRequestData) (bool, error) {
+	var result agentintegration.CommonDirStatusResponseData
+	err := c.Do(ctx, "certificates.commondirstatus", data, &result)
+
+	return result.Status, err
+}
+
+func (c *Client) ChangeCommonDirStatus(ctx context.Context, data agentintegration.CommonDirChangeStatusRequestData) error {
+	return c.Do(ctx, "certificates.changecommondirstatus", data, nil)
+}
+
+// GetJob returns the background job with its result or error
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	return c.doJob(ctx, "jobs.status", id)
+}
+
+// ListJobs returns jobs with the status or all jobs if the status is empty
+func (c *Client) ListJobs(ctx context.Context, status string) ([]*Job, error) {
+	var result []*Job
+	err := c.Do(ctx, "jobs.list", status, &result)
+
+	return result, err
+}
+
+// CancelJob cancels the queued job or stops the running one. A running job keeps the running status until it is stopped.
+func (c *Client) CancelJob(ctx context.Context, id string) (*Job, error) {
+	return c.doJob(ctx, "jobs.cancel", id)
+}
+
+// GetHealth runs health checks of the agent. The agent is usable if the status is ok.
+func (c *Client) GetHealth(ctx context.Context) (*Health, error) {
+	var result Health
+
+	if err := c.Do(ctx, "main.health", nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetAuditLog returns the newest audit log entries matching the filter, the newest first
+func (c *Client) GetAuditLog(ctx context.Context, filter AuditLogFilter) ([]AuditEntry, error) {
+	var result []AuditEntry
+	err := c.Do(ctx, "main.auditLog", filter, &result)
+
+	return result, err
+}
+
+func (c *Client) doCertificate(ctx context.Context, command string, data interface{}, reporter Reporter) (*agentintegration.Certificate, error) {
+	var result agentintegration.Certificate
+
+	if err := c.DoWithProgress(ctx, command, data, &result, reporter); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) doJob(ctx context.Context, command, id string) (*Job, error) {
+	var result Job
+
+	if err := c.Do(ctx, command, id, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,369 @@
+// Package client sends requests to the SSLBot agent over TCP, TLS or the local Unix socket
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+	"github.com/r2dtools/sslbot/pkg/tlsutil"
+)
+
+const (
+	headerDataLength = 4 // bytes
+	// maxResponseSize protects the client from allocating memory for a corrupted frame length
+	maxResponseSize = 256 << 20 // bytes
+
+	defaultDialTimeout = 10 * time.Second
+	defaultRetries     = 2
+	defaultRetryDelay  = 500 * time.Millisecond
+)
+
+// Client sends each request over a new connection, so it is safe for concurrent use
+type Client struct {
+	// Network is "tcp" or "unix"
+	Network string
+	Address string
+	// Token authenticates requests. Clients of the Unix socket are authenticated by their user and do not need it.
+	Token string
+	// SignRequests signs requests with the key derived from the token instead of sending the token,
+	// which is required if the agent has request_signing_required set
+	SignRequests bool
+	// KeyId is the name of the token used to sign requests, "default" for the main token if it is empty
+	KeyId string
+	// TlsConfig enables TLS. GetFingerprintTlsConfig trusts the self-signed agent certificate.
+	TlsConfig   *tls.Config
+	DialTimeout time.Duration
+	// Timeout limits each attempt of a request. Requests are limited by their context only if it is zero.
+	Timeout time.Duration
+	// Retries is the number of repeated attempts of a request that is not executed by the agent:
+	// the agent is not reachable, shutting down or rate limits the client.
+	Retries int
+	// RetryDelay is the delay before the first repeated attempt, it is doubled for each further attempt
+	RetryDelay time.Duration
+}
+
+// New returns the client of the agent listening on the TCP address, e.g. "192.168.1.10:60150"
+func New(address, token string) *Client {
+	return &Client{
+		Network:     "tcp",
+		Address:     address,
+		Token:       token,
+		DialTimeout: defaultDialTimeout,
+		Retries:     defaultRetries,
+		RetryDelay:  defaultRetryDelay,
+	}
+}
+
+// NewUnix returns the client of the agent listening on the Unix socket
+func NewUnix(path string) *Client {
+	client := New(path, "")
+	client.Network = "unix"
+
+	return client
+}
+
+// GetFingerprintTlsConfig returns the TLS configuration trusting only the certificate with the SHA-256 fingerprint
+// shown by the tls-fingerprint command. Colons in the fingerprint are optional.
+func GetFingerprintTlsConfig(fingerprint string) *tls.Config {
+	return tlsutil.GetFingerprintTlsConfig(fingerprint, "agent")
+}
+
+// Do executes the command and decodes its result into the result if it is not nil
+func (c *Client) Do(ctx context.Context, command string, data, result interface{}) error {
+	return c.do(ctx, request{Command: command, Data: data}, result, nil)
+}
+
+// DoWithProgress executes the command like Do and passes progress events of the command to the reporter
+func (c *Client) DoWithProgress(ctx context.Context, command string, data, result interface{}, reporter Reporter) error {
+	return c.do(ctx, request{Command: command, Data: data, Events: reporter != nil}, result, reporter)
+}
+
+// Submit runs the command as a background job on the agent. Use GetJob to get its result.
+func (c *Client) Submit(ctx context.Context, command string, data interface{}) (*Job, error) {
+	var job Job
+
+	if err := c.do(ctx, request{Command: command, Data: data, Async: true}, &job, nil); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (c *Client) do(ctx context.Context, request request, result interface{}, reporter Reporter) error {
+	request.Version = protocol.ProtocolVersion
+	request.Token = c.Token
+	delay := c.RetryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, request, result, reporter)
+
+		if err == nil || attempt >= c.Retries || !isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+// send makes a single attempt of the request
+func (c *Client) send(ctx context.Context, request request, result interface{}, reporter Reporter) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	conn, err := c.dial(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	// Blocked reads and writes are interrupted as soon as the context is done
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if c.SignRequests {
+		if err = c.sign(&request); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(request)
+
+	if err != nil {
+		return fmt.Errorf("could not encode request: %v", err)
+	}
+
+	if err = writeFrame(conn, data); err != nil {
+		return getContextError(ctx, err)
+	}
+
+	for {
+		data, err = readFrame(conn)
+
+		if err != nil {
+			return getContextError(ctx, err)
+		}
+
+		var response response
+
+		if err = json.Unmarshal(data, &response); err != nil {
+			return fmt.Errorf("could not decode response: %v", err)
+		}
+
+		if response.Status != "event" {
+			return response.decode(result)
+		}
+
+		var event Event
+
+		if err = json.Unmarshal(response.Data, &event); err == nil && reporter != nil {
+			reporter(event)
+		}
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.DialTimeout}
+
+	if c.TlsConfig == nil || c.Network == "unix" {
+		return dialer.DialContext(ctx, c.Network, c.Address)
+	}
+
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: c.TlsConfig}
+
+	return tlsDialer.DialContext(ctx, c.Network, c.Address)
+}
+
+// sign sets a new nonce and signature, so each attempt of a request is signed separately
+func (c *Client) sign(request *request) error {
+	rawData, err := json.Marshal(request.Data)
+
+	if err != nil {
+		return fmt.Errorf("could not encode request data: %v", err)
+	}
+
+	nonce, err := uuid.NewRandom()
+
+	if err != nil {
+		return fmt.Errorf("could not generate request nonce: %v", err)
+	}
+
+	keyId := c.KeyId
+
+	if keyId == "" {
+		keyId = protocol.DefaultKeyId
+	}
+
+	// The signature covers the data exactly as it is sent. The token itself is never sent with signed requests.
+	request.Data = json.RawMessage(rawData)
+	request.Token = ""
+	request.KeyId = keyId
+	request.Timestamp = time.Now().Unix()
+	request.Nonce = nonce.String()
+	request.Signature = protocol.SignRequest(c.Token, protocol.SignedRequest{
+		Version:   request.Version,
+		KeyId:     request.KeyId,
+		Command:   request.Command,
+		KeepAlive: request.KeepAlive,
+		Async:     request.Async,
+		Events:    request.Events,
+		Timestamp: request.Timestamp,
+		Nonce:     request.Nonce,
+		Data:      rawData,
+	})
+
+	return nil
+}
+
+// request is a request frame of the agent protocol
+type request struct {
+	Version   int
+	Id        string `json:",omitempty"`
+	Command   string
+	Token     string      `json:",omitempty"`
+	KeepAlive bool        `json:",omitempty"`
+	Async     bool        `json:",omitempty"`
+	Events    bool        `json:",omitempty"`
+	Data      interface{} `json:",omitempty"`
+	KeyId     string      `json:",omitempty"`
+	Timestamp int64       `json:",omitempty"`
+	Nonce     string      `json:",omitempty"`
+	Signature string      `json:",omitempty"`
+}
+
+// response is a response frame with the data left encoded to decode it into the result type
+type response struct {
+	Version int
+	Id      string
+	Status  string
+	Error   json.RawMessage
+	Data    json.RawMessage
+}
+
+func (r *response) decode(result interface{}) error {
+	if r.Status == "error" {
+		return r.getError()
+	}
+
+	if result == nil || len(r.Data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(r.Data, result); err != nil {
+		return fmt.Errorf("could not decode response data: %v", err)
+	}
+
+	return nil
+}
+
+// getError returns the error object of protocol version 2 or wraps the error message of older agents
+func (r *response) getError() error {
+	if len(r.Error) > 0 && r.Error[0] == '{' {
+		var responseErr Error
+
+		if err := json.Unmarshal(r.Error, &responseErr); err != nil {
+			return fmt.Errorf("could not decode response error: %v", err)
+		}
+
+		return &responseErr
+	}
+
+	var message string
+
+	if err := json.Unmarshal(r.Error, &message); err != nil || message == "" {
+		message = "agent returned an error without a message"
+	}
+
+	return &Error{Code: ErrorCodeInternal, Message: message}
+}
+
+// isRetryable reports whether the request is certainly not executed by the agent
+func isRetryable(err error) bool {
+	var opErr *net.OpError
+
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	var responseErr *Error
+
+	if !errors.As(err, &responseErr) {
+		return false
+	}
+
+	switch responseErr.Code {
+	case ErrorCodeShuttingDown:
+		return true
+	case ErrorCodeRateLimited:
+		// Retries would only prolong the lockout after authentication failures
+		details, _ := responseErr.Details.(map[string]interface{})
+		_, lockedOut := details["LockedUntil"]
+
+		return !lockedOut
+	default:
+		return false
+	}
+}
+
+func getContextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("request is interrupted: %w", ctxErr)
+	}
+
+	return err
+}
+
+func writeFrame(writer io.Writer, data []byte) error {
+	frame := make([]byte, headerDataLength+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[headerDataLength:], data)
+
+	if _, err := writer.Write(frame); err != nil {
+		return fmt.Errorf("could not write request: %v", err)
+	}
+
+	return nil
+}
+
+func readFrame(reader io.Reader) ([]byte, error) {
+	header := make([]byte, headerDataLength)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("could not read response header: %v", err)
+	}
+
+	dataLen := binary.BigEndian.Uint32(header)
+
+	if dataLen > maxResponseSize {
+		return nil, fmt.Errorf("response size %d bytes exceeds the maximum of %d bytes", dataLen, maxResponseSize)
+	}
+
+	data := make([]byte, dataLen)
+
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("could not read response data: %v", err)
+	}
+
+	return data, nil
+}
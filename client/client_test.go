@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/agentintegration"
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/modules/jobs"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
+	"github.com/r2dtools/sslbot/internal/pkg/certificate"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/r2dtools/sslbot/internal/server"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "test-token"
+
+type stubCertificatesHandler struct{}
+
+func (h *stubCertificatesHandler) Handle(ctx context.Context, request router.Request) (interface{}, error) {
+	switch request.GetAction() {
+	case "issue":
+		request.Progress.Report(progress.StageIssue, "requesting certificate")
+		request.Progress.Report(progress.StageReload, "reloading nginx")
+
+		return &agentintegration.Certificate{CN: "example.com", DNSNames: []string{"example.com"}}, nil
+	case "storagecertdata":
+		if request.Data != "example.com" {
+			return nil, router.NewError(router.ErrorCodeNotFound, "could not find certificate '%v'", request.Data)
+		}
+
+		return &agentintegration.Certificate{CN: "example.com"}, nil
+	case "storagecertremove":
+		time.Sleep(200 * time.Millisecond)
+
+		return nil, nil
+	default:
+		return nil, router.NewInvalidActionError(request)
+	}
+}
+
+func TestTypedRequests(t *testing.T) {
+	client := New(startTestServer(t, getTestServer(t), nil), testToken)
+	ctx := context.Background()
+
+	cert, err := client.GetStorageCertificate(ctx, "example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", cert.CN)
+
+	_, err = client.GetStorageCertificate(ctx, "unknown.com")
+	var responseErr *Error
+	assert.True(t, errors.As(err, &responseErr))
+	assert.Equal(t, ErrorCodeNotFound, responseErr.Code)
+	assert.Equal(t, "could not find certificate 'unknown.com'", responseErr.Message)
+
+	client.Token = "wrong"
+	_, err = client.GetStorageCertificate(ctx, "example.com")
+	assert.True(t, errors.As(err, &responseErr))
+	assert.Equal(t, ErrorCodeUnauthorized, responseErr.Code)
+}
+
+func TestProgressEvents(t *testing.T) {
+	client := New(startTestServer(t, getTestServer(t), nil), testToken)
+	var messages []string
+
+	cert, err := client.IssueCertificate(context.Background(), agentintegration.CertificateIssueRequestData{ServerName: "example.com"}, func(event Event) {
+		messages = append(messages, event.Message)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"example.com"}, cert.DNSNames)
+	assert.Equal(t, []string{"requesting certificate", "reloading nginx"}, messages)
+}
+
+func TestSignedRequests(t *testing.T) {
+	testServer := getTestServer(t)
+	testServer.Config.RequestSigningRequired = true
+	client := New(startTestServer(t, testServer, nil), testToken)
+
+	_, err := client.GetStorageCertificate(context.Background(), "example.com")
+	assert.ErrorContains(t, err, auth.ErrSignatureRequired.Error())
+
+	client.SignRequests = true
+
+	for range 2 {
+		cert, err := client.GetStorageCertificate(context.Background(), "example.com")
+		assert.Nil(t, err)
+		assert.Equal(t, "example.com", cert.CN)
+	}
+
+	// Named tokens sign requests with their own key
+	testServer.Config.Tokens = []config.ApiToken{{Name: "panel", Scopes: []string{"certificates.*"}, SigningKey: protocol.SigningPublicKey("panel-secret")}}
+	client.Token = "panel-secret"
+	client.KeyId = "panel"
+	_, err = client.GetStorageCertificate(context.Background(), "example.com")
+	assert.Nil(t, err)
+
+	client.Token = testToken
+	_, err = client.GetStorageCertificate(context.Background(), "example.com")
+	assert.ErrorContains(t, err, auth.ErrInvalidSignature.Error())
+}
+
+func TestTls(t *testing.T) {
+	certPem, keyPem, err := certificate.GenerateSelfSignedCertificate("localhost", []string{"127.0.0.1"})
+	assert.Nil(t, err)
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.Nil(t, err)
+	fingerprint, err := certificate.GetFingerprint(certPem)
+	assert.Nil(t, err)
+
+	address := startTestServer(t, getTestServer(t), &tls.Config{Certificates: []tls.Certificate{cert}})
+	client := New(address, testToken)
+	client.TlsConfig = GetFingerprintTlsConfig(fingerprint)
+
+	_, err = client.GetStorageCertificate(context.Background(), "example.com")
+	assert.Nil(t, err)
+
+	client.TlsConfig = GetFingerprintTlsConfig("00:11")
+	_, err = client.GetStorageCertificate(context.Background(), "example.com")
+	assert.ErrorContains(t, err, "does not match the fingerprint")
+}
+
+func TestTimeout(t *testing.T) {
+	client := New(startTestServer(t, getTestServer(t), nil), testToken)
+	client.Timeout = 50 * time.Millisecond
+
+	err := client.RemoveStorageCertificate(context.Background(), "example.com")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRetries(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	// The first connection is rejected as if the agent were shutting down, the next one is answered
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			response := router.Response{Version: 2, Status: "ok", Data: "done"}
+
+			if i == 0 {
+				response = router.Response{Version: 2, Status: "error", Error: "server is shutting down", ErrorCode: router.ErrorCodeShuttingDown}
+			}
+
+			data, _ := json.Marshal(response)
+			writeFrame(conn, data)
+			conn.Close()
+		}
+	}()
+
+	client := New(listener.Addr().String(), testToken)
+	client.RetryDelay = 10 * time.Millisecond
+	var result string
+	assert.Nil(t, client.Do(context.Background(), "main.echo", nil, &result))
+	assert.Equal(t, "done", result)
+
+	// Unreachable agents are retried as well
+	client = New(listener.Addr().String(), testToken)
+	listener.Close()
+	client.RetryDelay = 10 * time.Millisecond
+	start := time.Now()
+	err = client.Do(context.Background(), "main.echo", nil, nil)
+	assert.NotNil(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
+
+func TestJobs(t *testing.T) {
+	testServer := getTestServer(t)
+	testServer.Config.VarDir = t.TempDir()
+	manager, err := jobs.NewManager(testServer.Config, testServer.Logger, testServer.Router.HandleRequest)
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+	testServer.Jobs = manager
+	testServer.Router.RegisterHandler("jobs", jobs.NewHandler(manager))
+	client := New(startTestServer(t, testServer, nil), testToken)
+	ctx := context.Background()
+
+	job, err := client.Submit(ctx, "certificates.storagecertdata", "example.com")
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		job, err = client.GetJob(ctx, job.Id)
+
+		return err == nil && job.Status == JobStatusSucceeded
+	}, time.Second, 10*time.Millisecond)
+
+	jobList, err := client.ListJobs(ctx, JobStatusSucceeded)
+	assert.Nil(t, err)
+	assert.Len(t, jobList, 1)
+}
+
+func getTestServer(t *testing.T) *server.Server {
+	hash, err := auth.HashSecret(testToken)
+	assert.Nil(t, err)
+
+	r := router.Router{}
+	r.RegisterHandler("certificates", &stubCertificatesHandler{})
+
+	return &server.Server{
+		Router: r,
+		Logger: &logger.NilLogger{},
+		Config: &config.Config{TokenHash: hash, TokenSigningKey: protocol.SigningPublicKey(testToken), SignatureMaxAge: time.Minute, NonceCacheSize: 10},
+	}
+}
+
+// startTestServer serves the server on a loopback address and returns the address
+func startTestServer(t *testing.T, testServer *server.Server, tlsConfig *tls.Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go testServer.ServeListener(listener)
+	t.Cleanup(func() { testServer.Shutdown(context.Background()) })
+
+	return listener.Addr().String()
+}
@@ -0,0 +1,19 @@
+package client
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The client is imported by other modules, which can not use internal packages of the agent, so it must not depend on them
+func TestNoInternalDependencies(t *testing.T) {
+	output, err := exec.Command("go", "list", "-deps", ".").Output()
+	assert.Nil(t, err)
+
+	for _, dependency := range strings.Fields(string(output)) {
+		assert.False(t, strings.HasPrefix(dependency, "github.com/r2dtools/sslbot/internal/"), "client depends on %s", dependency)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixSocket(t *testing.T) {
+	testServer := getTestServer(t)
+	testServer.Config.UnixSocketPath = filepath.Join(t.TempDir(), "sslbot.sock")
+	testServer.Config.UnixSocketMode = 0600
+
+	go testServer.ServeUnix()
+	defer testServer.Shutdown(context.Background())
+
+	// The current user is authenticated without a token
+	client := NewUnix(testServer.Config.UnixSocketPath)
+	client.RetryDelay = 50 * time.Millisecond
+	client.Retries = 5
+
+	cert, err := client.GetStorageCertificate(context.Background(), "example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", cert.CN)
+}
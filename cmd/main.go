@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/r2dtools/sslbot/cmd/server"
 	"github.com/r2dtools/sslbot/config"
 )
@@ -10,7 +15,13 @@ var Version string
 func main() {
 	config.Version = Version
 
-	if err := server.CreateCli().Execute(); err != nil {
-		panic(err)
+	// Interrupted commands stop the ACME client and roll back webserver configuration changes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := server.CreateCli().ExecuteContext(ctx)
+	stop()
+
+	// The error is already printed by the command, e.g. a timed out shutdown of the serve command
+	if err != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/r2dtools/sslbot/client"
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var AuditLogCmd = &cobra.Command{
+	Use:   "audit-log",
+	Short: "Show the audit log of mutating commands, the newest entries first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since, err := parseAuditSince(auditSince, time.Now())
+
+		if err != nil {
+			return err
+		}
+
+		remoteClient, err := getRemoteClient(cmd)
+
+		if err != nil {
+			return err
+		}
+
+		var entries []audit.Entry
+
+		if remoteClient != nil {
+			filter := client.AuditLogFilter{
+				Command:   auditCommand,
+				TokenName: auditTokenName,
+				Vhost:     auditVhost,
+				CertName:  auditCertName,
+				Status:    auditStatus,
+				Limit:     auditLimit,
+			}
+
+			if !since.IsZero() {
+				filter.Since = since.Format(time.RFC3339)
+			}
+
+			entries, err = remoteClient.GetAuditLog(cmd.Context(), filter)
+		} else {
+			entries, err = getLocalAuditLog(audit.Filter{
+				Command:   auditCommand,
+				TokenName: auditTokenName,
+				Vhost:     auditVhost,
+				CertName:  auditCertName,
+				Status:    auditStatus,
+				Since:     since,
+				Limit:     auditLimit,
+			})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if isJson {
+			output, err := json.Marshal(entries)
+
+			if err != nil {
+				return err
+			}
+
+			return writeOutput(cmd, string(output))
+		}
+
+		var outputParts []string
+
+		for _, entry := range entries {
+			output, err := yaml.Marshal(entry)
+
+			if err != nil {
+				return err
+			}
+
+			outputParts = append(outputParts, string(output))
+		}
+
+		return writeOutput(cmd, strings.Join(outputParts, "\n"))
+	},
+}
+
+var (
+	auditCommand,
+	auditTokenName,
+	auditVhost,
+	auditCertName,
+	auditStatus,
+	auditSince string
+	auditLimit int
+)
+
+func getLocalAuditLog(filter audit.Filter) ([]audit.Entry, error) {
+	conf, err := config.GetConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return audit.NewLog(conf.GetPathInsideVarDir("audit.log")).Query(filter)
+}
+
+// parseAuditSince parses the --since flag: a duration before now, e.g. 24h, or an RFC 3339 timestamp
+func parseAuditSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+
+	since, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since value '%s': expected a duration or an RFC 3339 timestamp", value)
+	}
+
+	return since, nil
+}
+
+func init() {
+	AuditLogCmd.PersistentFlags().StringVar(&auditCommand, "command", "", "show entries of the command, e.g. certificates.issue or certificates.*")
+	AuditLogCmd.PersistentFlags().StringVar(&auditTokenName, "token-name", "", "show entries of requests authenticated with the token")
+	AuditLogCmd.PersistentFlags().StringVar(&auditVhost, "vhost", "", "show entries affecting the virtual host")
+	AuditLogCmd.PersistentFlags().StringVar(&auditCertName, "cert", "", "show entries affecting the storage certificate")
+	AuditLogCmd.PersistentFlags().StringVar(&auditStatus, "status", "", "show entries with the status: ok or error")
+	AuditLogCmd.PersistentFlags().StringVar(&auditSince, "since", "", "show entries since the time: a duration before now, e.g. 24h, or an RFC 3339 timestamp")
+	AuditLogCmd.PersistentFlags().IntVar(&auditLimit, "limit", audit.DefaultLimit, "maximum number of entries")
+	addRemoteFlags(AuditLogCmd)
+}
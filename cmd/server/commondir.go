@@ -1,9 +1,12 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"slices"
 
+	"github.com/r2dtools/agentintegration"
+	"github.com/r2dtools/sslbot/client"
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/commondir"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
@@ -16,18 +19,6 @@ var CommonDirCmd = &cobra.Command{
 	Use:   "common-dir",
 	Short: "Manage ACME common directory for a host",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config, err := config.GetConfig()
-
-		if err != nil {
-			return err
-		}
-
-		log, err := logger.NewLogger(config)
-
-		if err != nil {
-			return err
-		}
-
 		if serverName == "" {
 			return fmt.Errorf("domain is not specified")
 		}
@@ -42,35 +33,88 @@ var CommonDirCmd = &cobra.Command{
 			return fmt.Errorf("invalid webserver %s", webServerCode)
 		}
 
-		webServer, err := webserver.GetWebServer(webServerCode, map[string]string{})
+		remoteClient, err := getRemoteClient(cmd)
 
 		if err != nil {
 			return err
 		}
 
-		webServerReverter := &reverter.Reverter{
-			HostMng: webServer.GetVhostManager(),
-			Logger:  log,
+		if remoteClient != nil {
+			return manageRemoteCommonDir(cmd.Context(), remoteClient)
 		}
 
-		commonDirManager, err := commondir.GetCommonDirManager(webServer, webServerReverter, log, config.ToMap())
+		return manageLocalCommonDir(cmd.Context())
+	},
+}
 
-		if err != nil {
-			return err
-		}
+func manageLocalCommonDir(ctx context.Context) error {
+	config, err := config.GetConfig()
 
-		if enableCommonDir {
-			err = commonDirManager.EnableCommonDir(serverName)
-		} else if disableCommonDir {
-			err = commonDirManager.DisableCommonDir(serverName)
-		} else {
-			fmt.Printf("Common directory status for host %s: %t\n", serverName, commonDirManager.GetCommonDirStatus(serverName).Enabled)
+	if err != nil {
+		return err
+	}
 
-			return nil
-		}
+	log, err := logger.NewLogger(config)
 
+	if err != nil {
 		return err
-	},
+	}
+
+	webServer, err := webserver.GetWebServer(webServerCode, map[string]string{})
+
+	if err != nil {
+		return err
+	}
+
+	webServerReverter := &reverter.Reverter{
+		HostMng: webServer.GetVhostManager(),
+		Logger:  log,
+	}
+
+	commonDirManager, err := commondir.GetCommonDirManager(webServer, webServerReverter, log, config.ToMap())
+
+	if err != nil {
+		return err
+	}
+
+	if enableCommonDir {
+		err = commonDirManager.EnableCommonDir(ctx, serverName)
+	} else if disableCommonDir {
+		err = commonDirManager.DisableCommonDir(ctx, serverName)
+	} else {
+		printCommonDirStatus(commonDirManager.GetCommonDirStatus(serverName).Enabled)
+
+		return nil
+	}
+
+	return err
+}
+
+func manageRemoteCommonDir(ctx context.Context, remoteClient *client.Client) error {
+	if enableCommonDir || disableCommonDir {
+		return remoteClient.ChangeCommonDirStatus(ctx, agentintegration.CommonDirChangeStatusRequestData{
+			WebServer:  webServerCode,
+			ServerName: serverName,
+			Status:     enableCommonDir,
+		})
+	}
+
+	status, err := remoteClient.GetCommonDirStatus(ctx, agentintegration.CommonDirStatusRequestData{
+		WebServer:  webServerCode,
+		ServerName: serverName,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	printCommonDirStatus(status)
+
+	return nil
+}
+
+func printCommonDirStatus(enabled bool) {
+	fmt.Printf("Common directory status for host %s: %t\n", serverName, enabled)
 }
 
 var enableCommonDir bool
@@ -80,4 +124,5 @@ func init() {
 	CommonDirCmd.PersistentFlags().StringVarP(&serverName, "domain", "d", "", "domain to enable common directory")
 	CommonDirCmd.PersistentFlags().BoolVar(&enableCommonDir, "enable", false, "enable common directory")
 	CommonDirCmd.PersistentFlags().BoolVar(&disableCommonDir, "disable", false, "disable common directory")
+	addRemoteFlags(CommonDirCmd)
 }
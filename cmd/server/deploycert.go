@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 
+	"github.com/r2dtools/agentintegration"
+	"github.com/r2dtools/sslbot/client"
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/deploy"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
@@ -17,18 +21,6 @@ var DeployCertificateCmd = &cobra.Command{
 	Use:   "deploy-cert",
 	Short: "Deploy certificate to a domain",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config, err := config.GetConfig()
-
-		if err != nil {
-			return err
-		}
-
-		log, err := logger.NewLogger(config)
-
-		if err != nil {
-			return err
-		}
-
 		supportedWebServerCodes := webserver.GetSupportedWebServers()
 
 		if webServerCode == "" {
@@ -43,73 +35,126 @@ var DeployCertificateCmd = &cobra.Command{
 			return fmt.Errorf("invalid webserver %s", webServerCode)
 		}
 
-		webServer, err := webserver.GetWebServer(webServerCode, map[string]string{})
+		remoteClient, err := getRemoteClient(cmd)
 
 		if err != nil {
 			return err
 		}
 
-		processManager, err := webServer.GetProcessManager()
-
-		if err != nil {
-			return err
+		if remoteClient != nil {
+			return deployRemoteCertificate(cmd.Context(), remoteClient)
 		}
 
-		vhost, err := webServer.GetVhostByName(serverName)
+		return deployLocalCertificate(cmd.Context())
+	},
+}
 
-		if err != nil {
-			return err
-		}
+func deployLocalCertificate(ctx context.Context) error {
+	config, err := config.GetConfig()
 
-		webServerReverter := &reverter.Reverter{
-			HostMng: webServer.GetVhostManager(),
-			Logger:  log,
-		}
+	if err != nil {
+		return err
+	}
 
-		if vhost == nil {
-			return fmt.Errorf("could not find virtual host '%s'", serverName)
-		}
+	log, err := logger.NewLogger(config)
 
-		deployer, err := deploy.GetCertificateDeployer(webServer, webServerReverter, log)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	webServer, err := webserver.GetWebServer(webServerCode, map[string]string{})
 
-		sslConfigFilePath, originEnabledConfigFilePath, err := deployer.DeployCertificate(vhost, certPath, certKeyPath)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			if rErr := webServerReverter.Rollback(); rErr != nil {
-				log.Error(fmt.Sprintf("failed to rallback webserver configuration on cert deploy: %v", rErr))
-			}
+	processManager, err := webServer.GetProcessManager()
 
-			return err
+	if err != nil {
+		return err
+	}
+
+	vhost, err := webServer.GetVhostByName(serverName)
+
+	if err != nil {
+		return err
+	}
+
+	webServerReverter := &reverter.Reverter{
+		HostMng: webServer.GetVhostManager(),
+		Logger:  log,
+	}
+
+	if vhost == nil {
+		return fmt.Errorf("could not find virtual host '%s'", serverName)
+	}
+
+	deployer, err := deploy.GetCertificateDeployer(webServer, webServerReverter, log)
+
+	if err != nil {
+		return err
+	}
+
+	sslConfigFilePath, originEnabledConfigFilePath, err := deployer.DeployCertificate(vhost, certPath, certKeyPath)
+
+	if err != nil {
+		if rErr := webServerReverter.Rollback(); rErr != nil {
+			log.Error(fmt.Sprintf("failed to rallback webserver configuration on cert deploy: %v", rErr))
 		}
 
-		if err = webServer.GetVhostManager().Enable(sslConfigFilePath, filepath.Dir(originEnabledConfigFilePath)); err != nil {
-			if rErr := webServerReverter.Rollback(); rErr != nil {
-				log.Error(fmt.Sprintf("failed to rallback webserver configuration on host enabling: %v", rErr))
-			}
+		return err
+	}
 
-			return err
+	if err = webServer.GetVhostManager().Enable(sslConfigFilePath, filepath.Dir(originEnabledConfigFilePath)); err != nil {
+		if rErr := webServerReverter.Rollback(); rErr != nil {
+			log.Error(fmt.Sprintf("failed to rallback webserver configuration on host enabling: %v", rErr))
 		}
 
-		if err = processManager.Reload(); err != nil {
-			if rErr := webServerReverter.Rollback(); rErr != nil {
-				log.Error(fmt.Sprintf("failed to rallback webserver configuration on webserver reload: %v", rErr))
-			}
+		return err
+	}
 
-			return err
+	if err = processManager.Reload(ctx); err != nil {
+		if rErr := webServerReverter.Rollback(); rErr != nil {
+			log.Error(fmt.Sprintf("failed to rallback webserver configuration on webserver reload: %v", rErr))
 		}
 
-		if err = webServerReverter.Commit(); err != nil {
-			if rErr := webServerReverter.Rollback(); rErr != nil {
-				log.Error(fmt.Sprintf("failed to commit webserver configuration: %v", rErr))
-			}
+		return err
+	}
+
+	if err = webServerReverter.Commit(); err != nil {
+		if rErr := webServerReverter.Rollback(); rErr != nil {
+			log.Error(fmt.Sprintf("failed to commit webserver configuration: %v", rErr))
 		}
+	}
 
-		return nil
-	},
+	return nil
+}
+
+// deployRemoteCertificate uploads the certificate with its key to the remote agent, which stores and deploys it
+func deployRemoteCertificate(ctx context.Context, remoteClient *client.Client) error {
+	pemData, err := os.ReadFile(certPath)
+
+	if err != nil {
+		return fmt.Errorf("could not read certificate: %v", err)
+	}
+
+	if certKeyPath != "" && certKeyPath != certPath {
+		keyData, err := os.ReadFile(certKeyPath)
+
+		if err != nil {
+			return fmt.Errorf("could not read certificate key: %v", err)
+		}
+
+		pemData = append(append(pemData, '\n'), keyData...)
+	}
+
+	_, err = remoteClient.UploadCertificate(ctx, agentintegration.CertificateUploadRequestData{
+		ServerName:     serverName,
+		WebServer:      webServerCode,
+		PemCertificate: string(pemData),
+	}, nil)
+
+	return err
 }
 
 var certPath string
@@ -119,4 +164,5 @@ func init() {
 	DeployCertificateCmd.PersistentFlags().StringVarP(&serverName, "domain", "d", "", "domain to deploy a certificate")
 	DeployCertificateCmd.PersistentFlags().StringVarP(&certPath, "cert", "c", "", "path to a certificate file")
 	DeployCertificateCmd.PersistentFlags().StringVarP(&certKeyPath, "key", "k", "", "path to a certificate key path")
+	addRemoteFlags(DeployCertificateCmd)
 }
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/certificate"
+	"github.com/spf13/cobra"
+	"github.com/unknwon/com"
+)
+
+var GenerateClientCertificateCmd = &cobra.Command{
+	Use:   "generate-client-cert",
+	Short: "Generate client CA and client certificate for mutual TLS authentication",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := config.GetConfig()
+
+		if err != nil {
+			return err
+		}
+
+		if clientCertName == "" {
+			return fmt.Errorf("client name is not specified")
+		}
+
+		caCertPath := conf.GetPathInsideVarDir("tls", "client-ca.crt")
+		caKeyPath := conf.GetPathInsideVarDir("tls", "client-ca.key")
+
+		if !com.IsFile(caCertPath) || !com.IsFile(caKeyPath) {
+			caCertPem, caKeyPem, err := certificate.GenerateCA("SSLBot Client CA")
+
+			if err != nil {
+				return err
+			}
+
+			if err = writeKeyPair(caCertPath, caCertPem, caKeyPath, caKeyPem); err != nil {
+				return err
+			}
+
+			fmt.Printf("Client CA generated: %s\n", caCertPath)
+		}
+
+		caCertPem, err := os.ReadFile(caCertPath)
+
+		if err != nil {
+			return err
+		}
+
+		caKeyPem, err := os.ReadFile(caKeyPath)
+
+		if err != nil {
+			return err
+		}
+
+		certPem, keyPem, err := certificate.GenerateClientCertificate(clientCertName, caCertPem, caKeyPem)
+
+		if err != nil {
+			return err
+		}
+
+		certPath := filepath.Join(clientCertOutDir, clientCertName+".crt")
+		keyPath := filepath.Join(clientCertOutDir, clientCertName+".key")
+
+		if err = writeKeyPair(certPath, certPem, keyPath, keyPem); err != nil {
+			return err
+		}
+
+		fmt.Printf("Client certificate: %s\n", certPath)
+		fmt.Printf("Client key: %s\n", keyPath)
+
+		switch conf.TlsClientCaFile {
+		case "":
+			if err = conf.Save(map[string]interface{}{"tls_client_ca_file": caCertPath}); err != nil {
+				return err
+			}
+
+			fmt.Println("Mutual TLS is enabled. Please restart the SSLBot service: systemctl restart sslbot.service")
+		case caCertPath:
+		default:
+			fmt.Printf("Configured client CA bundle %s must include %s to accept the certificate\n", conf.TlsClientCaFile, caCertPath)
+		}
+
+		return nil
+	},
+}
+
+var clientCertName string
+var clientCertOutDir string
+
+func init() {
+	GenerateClientCertificateCmd.PersistentFlags().StringVarP(&clientCertName, "name", "n", "sslpanel", "client certificate common name")
+	GenerateClientCertificateCmd.PersistentFlags().StringVarP(&clientCertOutDir, "out", "o", ".", "directory to write client certificate and key to")
+}
+
+func writeKeyPair(certPath string, certPem []byte, keyPath string, keyPem []byte) error {
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		return fmt.Errorf("could not write key %s: %v", keyPath, err)
+	}
+
+	if err := os.WriteFile(certPath, certPem, 0644); err != nil {
+		return fmt.Errorf("could not write certificate %s: %v", certPath, err)
+	}
+
+	return nil
+}
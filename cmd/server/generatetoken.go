@@ -2,13 +2,10 @@ package server
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
-
-	"github.com/google/uuid"
 )
 
 var GenerateTokenCmd = &cobra.Command{
@@ -21,41 +18,14 @@ var GenerateTokenCmd = &cobra.Command{
 			return err
 		}
 
-		randomUuid, err := uuid.NewRandom()
-
-		if err != nil {
-			return err
-		}
-
-		token := randomUuid.String()
-		data, err := os.ReadFile(conf.ConfigFilePath)
-
-		if err != nil {
-			return err
-		}
-
-		confMap := make(map[string]interface{})
-		err = yaml.Unmarshal(data, confMap)
-
-		if err != nil {
-			return err
-		}
-
-		confMap["token"] = token
-		data, err = yaml.Marshal(confMap)
-
-		if err != nil {
-			return err
-		}
-
-		err = os.WriteFile(conf.ConfigFilePath, data, 0644)
+		token, err := auth.GenerateToken(conf)
 
 		if err != nil {
 			return err
 		}
 
 		fmt.Printf("Token: %s\n", token)
-		fmt.Println("Please restart the SSLBot service: systemctl restart sslbot.service")
+		fmt.Println("Store the token securely: only its hash is saved, so it is not possible to show it again")
 
 		return nil
 	},
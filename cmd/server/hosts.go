@@ -1,11 +1,13 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
 
 	"github.com/r2dtools/agentintegration"
+	"github.com/r2dtools/sslbot/client"
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
 	"github.com/r2dtools/sslbot/internal/pkg/webserver"
@@ -18,18 +20,6 @@ var HostsCmd = &cobra.Command{
 	Use:   "hosts",
 	Short: "Show virtual hosts of web servers",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		conf, err := config.GetConfig()
-
-		if err != nil {
-			return err
-		}
-
-		log, err := logger.NewLogger(conf)
-
-		if err != nil {
-			return err
-		}
-
 		supportedWebServerCodes := webserver.GetSupportedWebServers()
 		webServerCodes := supportedWebServerCodes
 
@@ -41,24 +31,22 @@ var HostsCmd = &cobra.Command{
 			webServerCodes = []string{webServerCode}
 		}
 
-		var vhosts []agentintegration.VirtualHost
-
-		for _, webServerCode := range webServerCodes {
-			webServer, err := webserver.GetWebServer(webServerCode, map[string]string{})
-
-			if err != nil {
-				log.Info(fmt.Sprintf("failed to get %s webserver: %v", webServerCode, err))
+		remoteClient, err := getRemoteClient(cmd)
 
-				continue
-			}
+		if err != nil {
+			return err
+		}
 
-			hosts, err := webServer.GetVhosts()
+		var vhosts []agentintegration.VirtualHost
 
-			if err != nil {
-				return err
-			}
+		if remoteClient != nil {
+			vhosts, err = getRemoteVhosts(cmd.Context(), remoteClient, webServerCodes)
+		} else {
+			vhosts, err = getLocalVhosts(webServerCodes)
+		}
 
-			vhosts = append(vhosts, hosts...)
+		if err != nil {
+			return err
 		}
 
 		if isJson {
@@ -86,3 +74,62 @@ var HostsCmd = &cobra.Command{
 		return writeOutput(cmd, strings.Join(outputParts, "\n"))
 	},
 }
+
+func getLocalVhosts(webServerCodes []string) ([]agentintegration.VirtualHost, error) {
+	conf, err := config.GetConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := logger.NewLogger(conf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var vhosts []agentintegration.VirtualHost
+
+	for _, webServerCode := range webServerCodes {
+		webServer, err := webserver.GetWebServer(webServerCode, map[string]string{})
+
+		if err != nil {
+			log.Info(fmt.Sprintf("failed to get %s webserver: %v", webServerCode, err))
+
+			continue
+		}
+
+		hosts, err := webServer.GetVhosts()
+
+		if err != nil {
+			return nil, err
+		}
+
+		vhosts = append(vhosts, hosts...)
+	}
+
+	return vhosts, nil
+}
+
+// getRemoteVhosts returns virtual hosts of the remote agent. The agent returns hosts of all webservers, so they are filtered here.
+func getRemoteVhosts(ctx context.Context, remoteClient *client.Client, webServerCodes []string) ([]agentintegration.VirtualHost, error) {
+	hosts, err := remoteClient.GetVhosts(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var vhosts []agentintegration.VirtualHost
+
+	for _, host := range hosts {
+		if slices.Contains(webServerCodes, host.WebServer) {
+			vhosts = append(vhosts, host)
+		}
+	}
+
+	return vhosts, nil
+}
+
+func init() {
+	addRemoteFlags(HostsCmd)
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"slices"
 
 	"github.com/r2dtools/agentintegration"
@@ -10,6 +12,7 @@ import (
 	"github.com/r2dtools/sslbot/internal/modules/certificates"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/acme"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
 	"github.com/r2dtools/sslbot/internal/pkg/webserver"
 	"github.com/spf13/cobra"
 )
@@ -18,18 +21,6 @@ var IssueCertificateCmd = &cobra.Command{
 	Use:   "issue-cert",
 	Short: "Secure domain with a certificate",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config, err := config.GetConfig()
-
-		if err != nil {
-			return err
-		}
-
-		log, err := logger.NewLogger(config)
-
-		if err != nil {
-			return err
-		}
-
 		if email == "" {
 			return fmt.Errorf("email is not specified")
 		}
@@ -48,12 +39,6 @@ var IssueCertificateCmd = &cobra.Command{
 			return fmt.Errorf("invalid webserver %s", webServerCode)
 		}
 
-		certManager, err := certificates.GetCertificateManager(config, log)
-
-		if err != nil {
-			return err
-		}
-
 		certData := agentintegration.CertificateIssueRequestData{
 			Email:         email,
 			ServerName:    serverName,
@@ -62,7 +47,27 @@ var IssueCertificateCmd = &cobra.Command{
 			Subjects:      aliases,
 			Assign:        assign,
 		}
-		cert, err := certManager.Issue(certData)
+		var reporter progress.Reporter
+
+		if follow {
+			reporter = func(event progress.Event) {
+				fmt.Fprintln(os.Stderr, event)
+			}
+		}
+
+		remoteClient, err := getRemoteClient(cmd)
+
+		if err != nil {
+			return err
+		}
+
+		var cert *agentintegration.Certificate
+
+		if remoteClient != nil {
+			cert, err = remoteClient.IssueCertificate(cmd.Context(), certData, reporter)
+		} else {
+			cert, err = issueLocalCertificate(cmd.Context(), certData, reporter)
+		}
 
 		if err != nil {
 			return err
@@ -80,9 +85,32 @@ var IssueCertificateCmd = &cobra.Command{
 	},
 }
 
+func issueLocalCertificate(ctx context.Context, certData agentintegration.CertificateIssueRequestData, reporter progress.Reporter) (*agentintegration.Certificate, error) {
+	config, err := config.GetConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := logger.NewLogger(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	certManager, err := certificates.GetCertificateManager(config, log)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return certManager.Issue(ctx, certData, nil, reporter)
+}
+
 var email string
 var assign bool
 var aliases []string
+var follow bool
 
 func init() {
 	aliases = make([]string, 0)
@@ -90,4 +118,6 @@ func init() {
 	IssueCertificateCmd.PersistentFlags().StringVarP(&email, "email", "e", "", "certificate email address")
 	IssueCertificateCmd.PersistentFlags().BoolVarP(&assign, "assign", "s", true, "assignt certificate to the domain")
 	IssueCertificateCmd.PersistentFlags().StringSliceVarP(&aliases, "alias", "a", nil, "domain aliases that need to be included in the certificate")
+	IssueCertificateCmd.PersistentFlags().BoolVarP(&follow, "follow", "f", false, "print progress of the issuance and deployment")
+	addRemoteFlags(IssueCertificateCmd)
 }
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/r2dtools/sslbot/client"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	remoteTokenEnv        = "SSLBOT_TOKEN"
+	remoteProfilesFileEnv = "SSLBOT_PROFILES_FILE"
+	unixRemotePrefix      = "unix:"
+)
+
+var remoteAddress string
+var remoteToken string
+var remoteProfileName string
+var remoteTlsFingerprint string
+var remoteTlsDisabled bool
+var remoteSignRequests bool
+var remoteKeyId string
+var remoteCertFile string
+var remoteKeyFile string
+
+// remoteProfile holds connection settings of an agent stored in the profiles file
+type remoteProfile struct {
+	Remote         string `yaml:"remote"`
+	Token          string `yaml:"token"`
+	TlsFingerprint string `yaml:"tls_fingerprint"`
+	TlsDisabled    bool   `yaml:"tls_disabled"`
+	SignRequests   bool   `yaml:"sign_requests"`
+	KeyId          string `yaml:"key_id"`
+	// CertFile and KeyFile are the client certificate and its key presented to agents requiring client certificates
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type remoteProfiles struct {
+	Profiles map[string]remoteProfile `yaml:"profiles"`
+}
+
+// addRemoteFlags allows the command to be executed on a remote agent through the agent protocol
+func addRemoteFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&remoteAddress, "remote", "", "execute the command on the agent at host:port or unix:/path/to/socket")
+	flags.StringVar(&remoteToken, "token", "", "token of the remote agent ($"+remoteTokenEnv+" by default)")
+	flags.StringVar(&remoteProfileName, "profile", "", "execute the command on the agent of the named profile")
+	flags.StringVar(&remoteTlsFingerprint, "tls-fingerprint", "", "SHA-256 fingerprint of the remote agent TLS certificate")
+	flags.BoolVar(&remoteTlsDisabled, "tls-disabled", false, "connect to the remote agent without TLS")
+	flags.BoolVar(&remoteSignRequests, "sign", false, "sign requests to the remote agent")
+	flags.StringVar(&remoteKeyId, "key-id", "", "name of the token signing requests, the main token by default")
+	flags.StringVar(&remoteCertFile, "client-cert", "", "client certificate file presented to the remote agent")
+	flags.StringVar(&remoteKeyFile, "client-key", "", "key file of the client certificate")
+}
+
+// getRemoteClient returns the client of the agent selected by the remote flags or nil if the command is executed locally
+func getRemoteClient(cmd *cobra.Command) (*client.Client, error) {
+	var profile remoteProfile
+
+	if remoteProfileName != "" {
+		var err error
+		profile, err = getRemoteProfile(remoteProfileName)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Flags take precedence over the profile
+	flags := cmd.Flags()
+
+	if remoteAddress != "" {
+		profile.Remote = remoteAddress
+	}
+
+	if remoteToken != "" {
+		profile.Token = remoteToken
+	}
+
+	if remoteTlsFingerprint != "" {
+		profile.TlsFingerprint = remoteTlsFingerprint
+	}
+
+	if flags.Changed("tls-disabled") {
+		profile.TlsDisabled = remoteTlsDisabled
+	}
+
+	if flags.Changed("sign") {
+		profile.SignRequests = remoteSignRequests
+	}
+
+	if remoteKeyId != "" {
+		profile.KeyId = remoteKeyId
+	}
+
+	if remoteCertFile != "" {
+		profile.CertFile = remoteCertFile
+	}
+
+	if remoteKeyFile != "" {
+		profile.KeyFile = remoteKeyFile
+	}
+
+	if profile.Remote == "" {
+		return nil, nil
+	}
+
+	if path, ok := strings.CutPrefix(profile.Remote, unixRemotePrefix); ok {
+		return client.NewUnix(path), nil
+	}
+
+	if profile.Token == "" {
+		profile.Token = os.Getenv(remoteTokenEnv)
+	}
+
+	if (profile.CertFile == "") != (profile.KeyFile == "") {
+		return nil, errors.New("client certificate and its key must be specified together")
+	}
+
+	// Agents with token authentication disabled identify clients by their certificates
+	if profile.Token == "" && profile.CertFile == "" {
+		return nil, fmt.Errorf("token of the remote agent %s is not specified", profile.Remote)
+	}
+
+	remoteClient := client.New(profile.Remote, profile.Token)
+	remoteClient.SignRequests = profile.SignRequests
+	remoteClient.KeyId = profile.KeyId
+
+	if profile.TlsDisabled {
+		if profile.CertFile != "" {
+			return nil, errors.New("client certificate can not be used with TLS disabled")
+		}
+
+		return remoteClient, nil
+	}
+
+	if profile.TlsFingerprint == "" {
+		return nil, fmt.Errorf("TLS fingerprint of the remote agent %s is not specified", profile.Remote)
+	}
+
+	remoteClient.TlsConfig = client.GetFingerprintTlsConfig(profile.TlsFingerprint)
+
+	if profile.CertFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(profile.CertFile, profile.KeyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+
+		remoteClient.TlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return remoteClient, nil
+}
+
+func getRemoteProfile(name string) (remoteProfile, error) {
+	path, err := getRemoteProfilesFilePath()
+
+	if err != nil {
+		return remoteProfile{}, err
+	}
+
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return remoteProfile{}, fmt.Errorf("could not read profiles file: %v", err)
+	}
+
+	var profiles remoteProfiles
+
+	if err = yaml.Unmarshal(content, &profiles); err != nil {
+		return remoteProfile{}, fmt.Errorf("could not parse profiles file %s: %v", path, err)
+	}
+
+	profile, ok := profiles.Profiles[name]
+
+	if !ok {
+		return remoteProfile{}, fmt.Errorf("profile '%s' is not found in %s", name, path)
+	}
+
+	return profile, nil
+}
+
+func getRemoteProfilesFilePath() (string, error) {
+	if path := os.Getenv(remoteProfilesFileEnv); path != "" {
+		return path, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", fmt.Errorf("could not find profiles file: %v", err)
+	}
+
+	return filepath.Join(configDir, "sslbot", "profiles.yaml"), nil
+}
@@ -25,8 +25,13 @@ func CreateCli() *cobra.Command {
 	cli.AddCommand(DeployCertificateCmd)
 	cli.AddCommand(IssueCertificateCmd)
 	cli.AddCommand(GenerateTokenCmd)
+	cli.AddCommand(RotateTokenCmd)
 	cli.AddCommand(CommonDirCmd)
 	cli.AddCommand(ShowTokenCmd)
+	cli.AddCommand(TlsFingerprintCmd)
+	cli.AddCommand(GenerateClientCertificateCmd)
+	cli.AddCommand(TokenCmd)
+	cli.AddCommand(AuditLogCmd)
 	cli.PersistentFlags().StringVarP(&webServerCode, "webserver", "w", "", "webserver (nginx|apache)")
 
 	return cli
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
+	"github.com/spf13/cobra"
+)
+
+var RotateTokenCmd = &cobra.Command{
+	Use:   "rotate-token",
+	Short: "Generate new token keeping the current one valid during the grace period",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := config.GetConfig()
+
+		if err != nil {
+			return err
+		}
+
+		if gracePeriod > 0 {
+			conf.TokenGracePeriod = gracePeriod
+		}
+
+		token, previousTokenExpiresAt, err := auth.RotateToken(conf, time.Now())
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Token: %s\n", token)
+
+		if !previousTokenExpiresAt.IsZero() {
+			fmt.Printf("Previous token is valid until %s\n", previousTokenExpiresAt.Format(time.RFC3339))
+		}
+
+		fmt.Println("Store the token securely: only its hash is saved, so it is not possible to show it again")
+
+		return nil
+	},
+}
+
+var gracePeriod time.Duration
+
+func init() {
+	RotateTokenCmd.PersistentFlags().DurationVar(&gracePeriod, "grace-period", 0, "how long the current token remains valid (token_rotation_grace_period by default)")
+}
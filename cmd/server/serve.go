@@ -1,9 +1,18 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"os/signal"
+	"syscall"
+
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/modules/certificates"
+	"github.com/r2dtools/sslbot/internal/modules/jobs"
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
 	"github.com/r2dtools/sslbot/internal/pkg/router"
 	"github.com/r2dtools/sslbot/internal/server"
 	"github.com/spf13/cobra"
@@ -25,30 +34,132 @@ var ServeCmd = &cobra.Command{
 			return err
 		}
 
+		migrated, err := auth.MigratePlaintextToken(config)
+
+		if err != nil {
+			logger.Error("failed to migrate plaintext token: %v", err)
+		} else if migrated {
+			logger.Info("plaintext token is replaced with its hash in the config file")
+		}
+
 		certificatesHandler, err := certificates.GetHandler(config, logger)
 
 		if err != nil {
 			return err
 		}
 
-		router := router.Router{}
-		router.RegisterHandler("main", &server.MainHandler{
-			Config: config,
-			Logger: logger,
-		})
-		router.RegisterHandler("certificates", certificatesHandler)
-
-		server := &server.Server{
-			Port:   config.Port,
-			Router: router,
-			Logger: logger,
-			Config: config,
+		tcpServer := &server.Server{
+			Port:     config.Port,
+			Router:   router.Router{},
+			Logger:   logger,
+			Config:   config,
+			AuditLog: audit.NewLog(config.GetPathInsideVarDir("audit.log")),
 		}
+		// The audit middleware wraps the timeout middleware to record commands that timed out.
+		tcpServer.Router.Use(
+			server.MetricsMiddleware(tcpServer.Router.HasAction),
+			router.LoggingMiddleware(logger),
+			server.AuditMiddleware(tcpServer.AuditLog, logger, tcpServer.Router.IsMutating),
+			router.TimeoutMiddleware(tcpServer.GetCommandTimeout),
+			router.RecoverMiddleware(logger),
+		)
+		tcpServer.Router.RegisterHandler("main", server.NewMainHandler(config, logger, tcpServer))
+		tcpServer.Router.RegisterHandler("certificates", certificatesHandler)
+
+		jobManager, err := jobs.NewManager(config, logger, tcpServer.Router.HandleRequest)
 
-		if err := server.Serve(); err != nil {
+		if err != nil {
 			return err
 		}
 
-		return nil
+		tcpServer.Jobs = jobManager
+		tcpServer.Router.RegisterHandler("jobs", jobs.NewHandler(jobManager))
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		serveErr := make(chan error, 5)
+
+		go func() {
+			serveErr <- tcpServer.Serve()
+		}()
+
+		if config.UnixSocketPath != "" {
+			go func() {
+				serveErr <- tcpServer.ServeUnix()
+			}()
+		}
+
+		var httpGateway *server.HttpGateway
+
+		if config.HttpPort != 0 {
+			httpGateway = &server.HttpGateway{Port: config.HttpPort, Server: tcpServer}
+
+			go func() {
+				serveErr <- httpGateway.Serve()
+			}()
+		}
+
+		var metricsServer *server.MetricsServer
+
+		if config.MetricsPort != 0 {
+			certManager, err := certificates.GetCertificateManager(config, logger)
+
+			if err != nil {
+				return err
+			}
+
+			metrics.Default.OnCollect(certManager.CollectMetrics)
+			metricsServer = &server.MetricsServer{Port: config.MetricsPort, Registry: metrics.Default, Logger: logger, Config: config}
+
+			go func() {
+				serveErr <- metricsServer.Serve()
+			}()
+		}
+
+		var controlPlane *server.ControlPlaneConnector
+
+		if config.ControlPlaneAddress != "" {
+			controlPlane = &server.ControlPlaneConnector{Server: tcpServer}
+
+			go func() {
+				serveErr <- controlPlane.Serve()
+			}()
+		}
+
+		select {
+		case err = <-serveErr:
+		case <-ctx.Done():
+			logger.Info("stop signal received, waiting up to %s for in-flight requests", config.ShutdownTimeout)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+
+		if httpGateway != nil {
+			if httpErr := httpGateway.Shutdown(shutdownCtx); httpErr != nil {
+				logger.Error("failed to shut down HTTP gateway: %v", httpErr)
+			}
+		}
+
+		if metricsServer != nil {
+			if metricsErr := metricsServer.Shutdown(shutdownCtx); metricsErr != nil {
+				logger.Error("failed to shut down metrics listener: %v", metricsErr)
+			}
+		}
+
+		err = errors.Join(err, tcpServer.Shutdown(shutdownCtx))
+
+		if controlPlane != nil {
+			if controlPlaneErr := controlPlane.Shutdown(shutdownCtx); controlPlaneErr != nil {
+				logger.Error("failed to disconnect from the control plane: %v", controlPlaneErr)
+			}
+		}
+
+		if jobsErr := jobManager.Shutdown(shutdownCtx); jobsErr != nil {
+			logger.Error("failed to wait for running jobs: %v", jobsErr)
+		}
+
+		return err
 	},
 }
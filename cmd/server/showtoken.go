@@ -9,7 +9,7 @@ import (
 
 var ShowTokenCmd = &cobra.Command{
 	Use:   "show-token",
-	Short: "Show SSLBot current token",
+	Short: "Show SSLBot current token status",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		conf, err := config.GetConfig()
 
@@ -18,7 +18,13 @@ var ShowTokenCmd = &cobra.Command{
 		}
 
 		if conf.Token != "" {
-			fmt.Printf("Token: %s\n", conf.Token)
+			fmt.Println("Token is stored in plaintext and will be replaced with its hash on the SSLBot service start")
+
+			return nil
+		}
+
+		if conf.TokenHash != "" {
+			fmt.Println("Token is generated. Only its hash is stored, so it can not be shown. Run generate-token to issue a new one")
 
 			return nil
 		}
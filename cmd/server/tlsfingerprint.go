@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/certificate"
+	"github.com/r2dtools/sslbot/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var TlsFingerprintCmd = &cobra.Command{
+	Use:   "tls-fingerprint",
+	Short: "Show SHA-256 fingerprint of the SSLBot TLS certificate",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := config.GetConfig()
+
+		if err != nil {
+			return err
+		}
+
+		if conf.TlsDisabled {
+			fmt.Println("TLS is disabled")
+
+			return nil
+		}
+
+		certPath, _, err := server.EnsureServerCertificate(conf)
+
+		if err != nil {
+			return err
+		}
+
+		fingerprint, err := certificate.GetFingerprintFromFile(certPath)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Certificate: %s\n", certPath)
+		fmt.Printf("SHA-256 fingerprint: %s\n", fingerprint)
+
+		return nil
+	},
+}
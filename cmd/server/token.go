@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+var TokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage named API tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+var TokenAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add named API token",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := config.GetConfig()
+
+		if err != nil {
+			return err
+		}
+
+		if err = config.ValidateTokenName(tokenName); err != nil {
+			return err
+		}
+
+		if len(tokenScopes) == 0 {
+			return fmt.Errorf("token scopes are not specified")
+		}
+
+		if findApiToken(conf.Tokens, tokenName) != -1 {
+			return fmt.Errorf("token '%s' already exists", tokenName)
+		}
+
+		secret, err := auth.GenerateSecret()
+
+		if err != nil {
+			return err
+		}
+
+		hash, err := auth.HashSecret(secret)
+
+		if err != nil {
+			return err
+		}
+
+		token := config.ApiToken{
+			Name:       tokenName,
+			Hash:       hash,
+			Scopes:     tokenScopes,
+			SigningKey: protocol.SigningPublicKey(secret),
+		}
+
+		if tokenTtl > 0 {
+			token.ExpiresAt = time.Now().Add(tokenTtl).UTC().Truncate(time.Second)
+		}
+
+		if err = saveApiTokens(conf, append(conf.Tokens, token)); err != nil {
+			return err
+		}
+
+		fmt.Printf("Token: %s\n", secret)
+		fmt.Println("Store the token securely: it is not possible to show it again")
+
+		return nil
+	},
+}
+
+var TokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List named API tokens",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := config.GetConfig()
+
+		if err != nil {
+			return err
+		}
+
+		if isJson {
+			type tokenOutput struct {
+				Name      string
+				Scopes    []string
+				ExpiresAt *time.Time `json:",omitempty"`
+			}
+
+			var tokens []tokenOutput
+
+			for _, token := range conf.Tokens {
+				output := tokenOutput{Name: token.Name, Scopes: token.Scopes}
+
+				if !token.ExpiresAt.IsZero() {
+					output.ExpiresAt = &token.ExpiresAt
+				}
+
+				tokens = append(tokens, output)
+			}
+
+			output, err := json.Marshal(tokens)
+
+			if err != nil {
+				return err
+			}
+
+			return writeOutput(cmd, string(output))
+		}
+
+		var outputParts []string
+
+		for _, token := range conf.Tokens {
+			expiresAt := "never"
+
+			if !token.ExpiresAt.IsZero() {
+				expiresAt = token.ExpiresAt.Format(time.RFC3339)
+			}
+
+			outputParts = append(outputParts, fmt.Sprintf("%s\tscopes: %s\texpires: %s", token.Name, strings.Join(token.Scopes, ","), expiresAt))
+		}
+
+		if len(outputParts) == 0 {
+			return writeOutput(cmd, "No tokens\n")
+		}
+
+		return writeOutput(cmd, strings.Join(outputParts, "\n")+"\n")
+	},
+}
+
+var TokenRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke named API token",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := config.GetConfig()
+
+		if err != nil {
+			return err
+		}
+
+		index := findApiToken(conf.Tokens, tokenName)
+
+		if index == -1 {
+			return fmt.Errorf("token '%s' not found", tokenName)
+		}
+
+		if err = saveApiTokens(conf, slices.Delete(conf.Tokens, index, index+1)); err != nil {
+			return err
+		}
+
+		fmt.Printf("Token '%s' revoked\n", tokenName)
+
+		return nil
+	},
+}
+
+var tokenName string
+var tokenScopes []string
+var tokenTtl time.Duration
+
+func init() {
+	TokenAddCmd.PersistentFlags().StringVarP(&tokenName, "name", "n", "", "token name")
+	TokenAddCmd.PersistentFlags().StringSliceVarP(&tokenScopes, "scope", "s", nil, "allowed commands: *, <module>.* or <module>.<action>")
+	TokenAddCmd.PersistentFlags().DurationVar(&tokenTtl, "ttl", 0, "token lifetime, e.g. 720h (never expires by default)")
+	TokenRevokeCmd.PersistentFlags().StringVarP(&tokenName, "name", "n", "", "token name")
+
+	TokenCmd.AddCommand(TokenAddCmd)
+	TokenCmd.AddCommand(TokenListCmd)
+	TokenCmd.AddCommand(TokenRevokeCmd)
+}
+
+func findApiToken(tokens []config.ApiToken, name string) int {
+	return slices.IndexFunc(tokens, func(token config.ApiToken) bool {
+		return token.Name == name
+	})
+}
+
+func saveApiTokens(conf *config.Config, tokens []config.ApiToken) error {
+	return conf.Save(map[string]interface{}{"tokens": tokens})
+}
@@ -1,37 +1,160 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
+	"github.com/r2dtools/sslbot/pkg/protocol"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	defaultPort           = 60150
-	defaultCaServer       = "https://acme-v02.api.letsencrypt.org/directory"
-	defaultVarDir         = "/usr/local/r2dtools/sslbot/var"
-	defaultCertBotDataDir = "/etc/letsencrypt/live"
+	defaultPort              = 60150
+	defaultCaServer          = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultVarDir            = "/usr/local/r2dtools/sslbot/var"
+	defaultCertBotDataDir    = "/etc/letsencrypt/live"
+	defaultTokenGracePeriod  = 24 * time.Hour
+	defaultSignatureMaxAge   = 5 * time.Minute
+	defaultNonceCacheSize    = 10000
+	defaultMaxRequestSize    = 10 << 20 // bytes
+	defaultReadTimeout       = time.Minute
+	defaultWriteTimeout      = time.Minute
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultMaxBackoff        = time.Minute
+	defaultMaxConnections    = 100
+	defaultMaxInFlight       = 10
+	defaultRateLimit         = 10 // requests per second
+	defaultRateLimitBurst    = 20
+	defaultAuthFailureLimit  = 5
+	defaultAuthLockout       = 15 * time.Minute
+	defaultUnixSocketMode    = "0660"
+	defaultJobWorkers        = 2
+	defaultJobQueueSize      = 100
+	defaultJobRetention      = 7 * 24 * time.Hour
 )
 
+// DefaultTokenName is the name of the identity authenticated with the main token, it can not be used for named tokens
+const DefaultTokenName = protocol.DefaultKeyId
+
 var isDevMode = true
 var Version string
 
+// ApiToken is a named token with a hashed secret that grants access to the listed commands only
+type ApiToken struct {
+	Name string `mapstructure:"name" yaml:"name"`
+	Hash string `mapstructure:"hash" yaml:"hash"`
+	// Scopes contains allowed commands: "*", "<module>.*" or "<module>.<action>"
+	Scopes    []string  `mapstructure:"scopes" yaml:"scopes"`
+	ExpiresAt time.Time `mapstructure:"expires_at" yaml:"expires_at,omitempty"`
+	// SigningKey is the public key verifying requests signed with the key derived from the token
+	SigningKey string `mapstructure:"signing_key" yaml:"signing_key,omitempty"`
+}
+
+// CommandTimeout limits the execution time of the commands matching the pattern "*", "<module>.*" or "<module>.<action>"
+type CommandTimeout struct {
+	Command string        `mapstructure:"command" yaml:"command"`
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout"`
+}
+
+// TokenState contains the tokens accepted by the agent
+type TokenState struct {
+	Token                   string
+	TokenHash               string
+	TokenSigningKey         string
+	PreviousTokenHash       string
+	PreviousTokenSigningKey string
+	PreviousTokenExpiresAt  time.Time
+	Tokens                  []ApiToken
+}
+
 type Config struct {
-	LogFile        string
-	Port           int
-	Token          string
-	IsDevMode      bool
-	Version        string
-	LegoBin        string
-	CaServer       string
-	ConfigFilePath string
-	VarDir         string
-	CertBotEnabled bool
-	CertBotBin     string
-	CertBotWokrDir string
-	rootPath       string
+	LogFile string
+	Port    int
+	// HttpPort is a port of the HTTP gateway. The gateway is disabled if it is zero.
+	HttpPort int
+	// ControlPlaneAddress is the host:port of the control plane the agent connects to. The connection is disabled if it is empty.
+	ControlPlaneAddress string
+	// ControlPlaneToken authenticates the agent to the control plane
+	ControlPlaneToken string
+	// ControlPlaneTlsFingerprint is the SHA-256 fingerprint of the control plane certificate. The certificate is verified
+	// by system CAs if it is empty.
+	ControlPlaneTlsFingerprint    string
+	ControlPlaneTlsDisabled       bool
+	ControlPlaneHeartbeatInterval time.Duration
+	// ControlPlaneMaxBackoff limits the delay between reconnection attempts
+	ControlPlaneMaxBackoff time.Duration
+	// MetricsPort is a port of the Prometheus metrics listener. The listener is disabled if it is zero.
+	MetricsPort int
+	// Token is a legacy plaintext token. It is replaced with TokenHash on the agent start.
+	// Token fields are changed by token rotation and config reloads while requests are authenticated, so the running
+	// agent accesses them with GetTokenState and UpdateTokenState only.
+	Token     string
+	TokenHash string
+	// TokenSigningKey is the public key verifying requests signed with the key derived from the token
+	TokenSigningKey string
+	// PreviousTokenHash is a hash of the rotated token that is still accepted until PreviousTokenExpiresAt
+	PreviousTokenHash       string
+	PreviousTokenSigningKey string
+	PreviousTokenExpiresAt  time.Time
+	TokenGracePeriod        time.Duration
+	IsDevMode               bool
+	Version                 string
+	LegoBin                 string
+	CaServer                string
+	ConfigFilePath          string
+	VarDir                  string
+	CertBotEnabled          bool
+	CertBotBin              string
+	CertBotWokrDir          string
+	TlsDisabled             bool
+	TlsCertFile             string
+	TlsKeyFile              string
+	// TlsClientCaFile is a CA bundle used to verify client certificates. Client certificates are required if it is set.
+	TlsClientCaFile   string
+	TokenAuthDisabled bool
+	Tokens            []ApiToken
+	// RequestSigningRequired rejects requests without a valid HMAC signature, timestamp and nonce
+	RequestSigningRequired bool
+	SignatureMaxAge        time.Duration
+	NonceCacheSize         int
+	MaxRequestSize         int
+	ReadTimeout            time.Duration
+	WriteTimeout           time.Duration
+	ShutdownTimeout        time.Duration
+	MaxConnections         int
+	// MaxInFlightRequests limits the requests handled concurrently for a persistent connection
+	MaxInFlightRequests int
+	// RateLimit is the number of requests per second allowed for a client IP
+	RateLimit      float64
+	RateLimitBurst int
+	// AuthFailureLimit is the number of authentication failures after which a client IP is locked out for AuthLockoutDuration
+	AuthFailureLimit    int
+	AuthLockoutDuration time.Duration
+	// UnixSocketPath is a path of the local administration socket. The socket is disabled if it is empty.
+	UnixSocketPath string
+	// UnixSocketMode is an octal permission mode of the socket file
+	UnixSocketMode os.FileMode
+	// Clients of the socket are authenticated by their peer credentials: root, the agent user and the listed uids and gids are allowed
+	UnixSocketAllowedUids []int
+	UnixSocketAllowedGids []int
+	// JobWorkers is the number of background jobs run concurrently
+	JobWorkers   int
+	JobQueueSize int
+	// JobRetention is the time finished jobs are kept for
+	JobRetention time.Duration
+	// CommandTimeout limits the execution time of commands without a timeout in CommandTimeouts. Commands are not limited if it is zero.
+	CommandTimeout  time.Duration
+	CommandTimeouts []CommandTimeout
+	rootPath        string
+	tokenMu         sync.RWMutex
 }
 
 func GetConfig() (*Config, error) {
@@ -79,6 +202,25 @@ func GetConfig() (*Config, error) {
 	viper.SetDefault("ca_server", defaultCaServer)
 	viper.SetDefault("var_dir", defaultVarDir)
 	viper.SetDefault("cert_bot_work_dir", defaultCertBotDataDir)
+	viper.SetDefault("token_rotation_grace_period", defaultTokenGracePeriod)
+	viper.SetDefault("request_signature_max_age", defaultSignatureMaxAge)
+	viper.SetDefault("nonce_cache_size", defaultNonceCacheSize)
+	viper.SetDefault("max_request_size", defaultMaxRequestSize)
+	viper.SetDefault("read_timeout", defaultReadTimeout)
+	viper.SetDefault("write_timeout", defaultWriteTimeout)
+	viper.SetDefault("shutdown_timeout", defaultShutdownTimeout)
+	viper.SetDefault("max_connections", defaultMaxConnections)
+	viper.SetDefault("max_in_flight_requests", defaultMaxInFlight)
+	viper.SetDefault("rate_limit", defaultRateLimit)
+	viper.SetDefault("rate_limit_burst", defaultRateLimitBurst)
+	viper.SetDefault("auth_failure_limit", defaultAuthFailureLimit)
+	viper.SetDefault("auth_lockout_duration", defaultAuthLockout)
+	viper.SetDefault("unix_socket_mode", defaultUnixSocketMode)
+	viper.SetDefault("job_workers", defaultJobWorkers)
+	viper.SetDefault("job_queue_size", defaultJobQueueSize)
+	viper.SetDefault("job_retention", defaultJobRetention)
+	viper.SetDefault("control_plane_heartbeat_interval", defaultHeartbeatInterval)
+	viper.SetDefault("control_plane_max_backoff", defaultMaxBackoff)
 
 	if err := viper.ReadConfig(configFile); err != nil {
 		panic(err)
@@ -100,10 +242,14 @@ func GetConfig() (*Config, error) {
 		IsDevMode:      isDevMode,
 		Version:        Version,
 	}
-	setDynamicParams(config)
+
+	if err = setDynamicParams(config); err != nil {
+		return nil, err
+	}
 
 	viper.WatchConfig()
 	viper.OnConfigChange(func(e fsnotify.Event) {
+		// Invalid named tokens are skipped and malformed tokens keep the current ones, so the reloaded config is applied anyway
 		setDynamicParams(config)
 	})
 
@@ -117,6 +263,119 @@ func (c *Config) GetPathInsideVarDir(path ...string) string {
 	return filepath.Join(parts...)
 }
 
+// GetTokenState returns a consistent snapshot of the token fields
+func (c *Config) GetTokenState() TokenState {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+
+	return TokenState{
+		Token:                   c.Token,
+		TokenHash:               c.TokenHash,
+		TokenSigningKey:         c.TokenSigningKey,
+		PreviousTokenHash:       c.PreviousTokenHash,
+		PreviousTokenSigningKey: c.PreviousTokenSigningKey,
+		PreviousTokenExpiresAt:  c.PreviousTokenExpiresAt,
+		Tokens:                  c.Tokens,
+	}
+}
+
+// UpdateTokenState changes the token fields at once, so concurrent readers never see a partially rotated token
+func (c *Config) UpdateTokenState(update func(state *TokenState)) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	state := TokenState{
+		Token:                   c.Token,
+		TokenHash:               c.TokenHash,
+		TokenSigningKey:         c.TokenSigningKey,
+		PreviousTokenHash:       c.PreviousTokenHash,
+		PreviousTokenSigningKey: c.PreviousTokenSigningKey,
+		PreviousTokenExpiresAt:  c.PreviousTokenExpiresAt,
+		Tokens:                  c.Tokens,
+	}
+	update(&state)
+
+	c.Token = state.Token
+	c.TokenHash = state.TokenHash
+	c.TokenSigningKey = state.TokenSigningKey
+	c.PreviousTokenHash = state.PreviousTokenHash
+	c.PreviousTokenSigningKey = state.PreviousTokenSigningKey
+	c.PreviousTokenExpiresAt = state.PreviousTokenExpiresAt
+	c.Tokens = state.Tokens
+}
+
+// Save writes params to the config file keeping other settings untouched
+func (c *Config) Save(params map[string]interface{}) error {
+	data, err := os.ReadFile(c.ConfigFilePath)
+
+	if err != nil {
+		return err
+	}
+
+	confMap := make(map[string]interface{})
+
+	if err = yaml.Unmarshal(data, confMap); err != nil {
+		return err
+	}
+
+	for key, value := range params {
+		if value == nil {
+			delete(confMap, key)
+		} else {
+			confMap[key] = value
+		}
+	}
+
+	data, err = yaml.Marshal(confMap)
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(c.ConfigFilePath, data)
+}
+
+// writeFileAtomically replaces the file with a renamed temporary file, so the config watcher never reads a partially written file
+func writeFileAtomically(path string, data []byte) error {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err = file.Chmod(info.Mode().Perm()); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
 func (c *Config) ToMap() map[string]string {
 	settings := viper.AllSettings()
 	options := make(map[string]string)
@@ -130,12 +389,136 @@ func (c *Config) ToMap() map[string]string {
 	return options
 }
 
-func setDynamicParams(c *Config) {
+// ValidateTokenName checks that the name can be used for a named token
+func ValidateTokenName(name string) error {
+	if name == "" {
+		return fmt.Errorf("token name is not specified")
+	}
+
+	// The main token identity would become ambiguous in scopes and the audit log
+	if name == DefaultTokenName {
+		return fmt.Errorf("token name '%s' is reserved for the main token", name)
+	}
+
+	return nil
+}
+
+func setDynamicParams(c *Config) error {
 	c.Port = viper.GetInt("port")
-	c.Token = viper.GetString("token")
+	c.HttpPort = viper.GetInt("http_port")
+	c.MetricsPort = viper.GetInt("metrics_port")
+	c.ControlPlaneAddress = viper.GetString("control_plane_address")
+	c.ControlPlaneToken = viper.GetString("control_plane_token")
+	c.ControlPlaneTlsFingerprint = viper.GetString("control_plane_tls_fingerprint")
+	c.ControlPlaneTlsDisabled = viper.GetBool("control_plane_tls_disabled")
+	c.ControlPlaneHeartbeatInterval = viper.GetDuration("control_plane_heartbeat_interval")
+	c.ControlPlaneMaxBackoff = viper.GetDuration("control_plane_max_backoff")
+	tokens, tokensErr := getApiTokens()
+	c.UpdateTokenState(func(state *TokenState) {
+		state.Token = viper.GetString("token")
+		state.TokenHash = viper.GetString("token_hash")
+		state.TokenSigningKey = viper.GetString("token_signing_key")
+		state.PreviousTokenHash = viper.GetString("previous_token_hash")
+		state.PreviousTokenSigningKey = viper.GetString("previous_token_signing_key")
+		state.PreviousTokenExpiresAt = viper.GetTime("previous_token_expires_at")
+		// Tokens that can not be decoded do not replace the current ones, e.g. on a reload of a malformed config
+		if tokensErr == nil || tokens != nil {
+			state.Tokens = tokens
+		}
+	})
+	c.TokenGracePeriod = viper.GetDuration("token_rotation_grace_period")
 	c.CaServer = viper.GetString("ca_server")
 	c.VarDir = viper.GetString("var_dir")
 	c.CertBotEnabled = viper.GetBool("cert_bot_enabled")
 	c.CertBotBin = viper.GetString("cert_bot_bin")
 	c.CertBotWokrDir = viper.GetString("cert_bot_work_dir")
+	c.TlsDisabled = viper.GetBool("tls_disabled")
+	c.TlsCertFile = viper.GetString("tls_cert_file")
+	c.TlsKeyFile = viper.GetString("tls_key_file")
+	c.TlsClientCaFile = viper.GetString("tls_client_ca_file")
+	c.TokenAuthDisabled = viper.GetBool("token_auth_disabled")
+	c.RequestSigningRequired = viper.GetBool("request_signing_required")
+	c.SignatureMaxAge = viper.GetDuration("request_signature_max_age")
+	c.NonceCacheSize = viper.GetInt("nonce_cache_size")
+	c.MaxRequestSize = viper.GetInt("max_request_size")
+	c.ReadTimeout = viper.GetDuration("read_timeout")
+	c.WriteTimeout = viper.GetDuration("write_timeout")
+	c.ShutdownTimeout = viper.GetDuration("shutdown_timeout")
+	c.MaxConnections = viper.GetInt("max_connections")
+	c.MaxInFlightRequests = viper.GetInt("max_in_flight_requests")
+	c.RateLimit = viper.GetFloat64("rate_limit")
+	c.RateLimitBurst = viper.GetInt("rate_limit_burst")
+	c.AuthFailureLimit = viper.GetInt("auth_failure_limit")
+	c.AuthLockoutDuration = viper.GetDuration("auth_lockout_duration")
+	c.UnixSocketPath = viper.GetString("unix_socket_path")
+	c.UnixSocketMode = getFileMode(viper.GetString("unix_socket_mode"), defaultUnixSocketMode)
+	c.UnixSocketAllowedUids = viper.GetIntSlice("unix_socket_allowed_uids")
+	c.UnixSocketAllowedGids = viper.GetIntSlice("unix_socket_allowed_gids")
+	c.JobWorkers = viper.GetInt("job_workers")
+	c.JobQueueSize = viper.GetInt("job_queue_size")
+	c.JobRetention = viper.GetDuration("job_retention")
+	c.CommandTimeout = viper.GetDuration("command_timeout")
+	c.CommandTimeouts = getCommandTimeouts()
+
+	return tokensErr
+}
+
+func getFileMode(value, defaultValue string) os.FileMode {
+	mode, err := strconv.ParseUint(value, 8, 32)
+
+	if err != nil {
+		mode, _ = strconv.ParseUint(defaultValue, 8, 32)
+	}
+
+	return os.FileMode(mode)
+}
+
+// getApiTokens returns named tokens of the config. Tokens with invalid names are skipped and reported by the error,
+// no tokens are returned if the tokens section can not be decoded.
+func getApiTokens() ([]ApiToken, error) {
+	var tokens []ApiToken
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339),
+		Result:     &tokens,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = decoder.Decode(viper.Get("tokens")); err != nil {
+		return nil, fmt.Errorf("could not decode tokens: %v", err)
+	}
+
+	validTokens := make([]ApiToken, 0, len(tokens))
+
+	for _, token := range tokens {
+		if nameErr := ValidateTokenName(token.Name); nameErr != nil {
+			err = nameErr
+
+			continue
+		}
+
+		validTokens = append(validTokens, token)
+	}
+
+	return validTokens, err
+}
+
+func getCommandTimeouts() []CommandTimeout {
+	var timeouts []CommandTimeout
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     &timeouts,
+	})
+
+	if err != nil {
+		return nil
+	}
+
+	if err = decoder.Decode(viper.Get("command_timeouts")); err != nil {
+		return nil
+	}
+
+	return timeouts
 }
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetApiTokens(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("tokens", []map[string]interface{}{
+		{"name": "panel", "hash": "hash"},
+		{"name": "default", "hash": "hash"},
+	})
+	tokens, err := getApiTokens()
+	assert.NotNil(t, err)
+	assert.Len(t, tokens, 1)
+	assert.Equal(t, "panel", tokens[0].Name)
+
+	viper.Set("tokens", "malformed")
+	tokens, err = getApiTokens()
+	assert.ErrorContains(t, err, "could not decode tokens")
+	assert.Nil(t, tokens)
+}
@@ -1,19 +1,24 @@
 package certbot
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os/exec"
+	"strings"
 
 	"github.com/r2dtools/agentintegration"
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/acme"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/r2dtools/sslbot/internal/pkg/utils"
 )
 
 type CertBot struct {
 	bin string
 }
 
-func (b CertBot) Issue(docRoot string, certData agentintegration.CertificateIssueRequestData) error {
+func (b CertBot) Issue(ctx context.Context, docRoot string, certData agentintegration.CertificateIssueRequestData, output io.Writer) error {
 	var challengeType acme.ChallengeType
 	serverName := certData.ServerName
 	params := []string{"certonly", "-m " + certData.Email, "-n"}
@@ -22,7 +27,7 @@ func (b CertBot) Issue(docRoot string, certData agentintegration.CertificateIssu
 	case acme.HttpChallengeTypeCode:
 		challengeType = HTTPChallengeType{WebRoot: docRoot}
 	default:
-		return fmt.Errorf("unsupported challenge type: %s", certData.ChallengeType)
+		return router.NewInvalidDataError("unsupported challenge type: %s", certData.ChallengeType)
 	}
 
 	params = append(params, challengeType.GetParams()...)
@@ -35,26 +40,63 @@ func (b CertBot) Issue(docRoot string, certData agentintegration.CertificateIssu
 	}
 
 	params = append(params, "--agree-tos")
-	cmdName := b.bin
-
-	if cmdName == "" {
-		cmdName = "certbot"
-	}
-
-	cmd := exec.Command(cmdName, params...)
-	output, err := cmd.CombinedOutput()
+	cmd := exec.CommandContext(ctx, b.getBin(), params...)
+	cmdOutput, err := utils.RunWithOutput(cmd, output)
 
 	if err != nil {
-		if len(output) == 0 {
+		if len(cmdOutput) == 0 {
 			return err
 		}
 
-		return fmt.Errorf("%s\n%s", output, err.Error())
+		return fmt.Errorf("%s\n%s", cmdOutput, err.Error())
 	}
 
 	return nil
 }
 
+func (b CertBot) GetInfo(ctx context.Context) (*acme.ClientInfo, error) {
+	version, err := b.getVersion(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &acme.ClientInfo{
+		Name:           "certbot",
+		Version:        version,
+		ChallengeTypes: []string{acme.HttpChallengeTypeCode},
+	}, nil
+}
+
+func (b CertBot) GetVersion(ctx context.Context) (string, error) {
+	version, err := b.getVersion(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	return "certbot " + version, nil
+}
+
+func (b CertBot) getVersion(ctx context.Context) (string, error) {
+	output, err := exec.CommandContext(ctx, b.getBin(), "--version").CombinedOutput()
+
+	if err != nil {
+		return "", fmt.Errorf("could not detect certbot version: %v", err)
+	}
+
+	// certbot 2.9.0
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(output)), "certbot")), nil
+}
+
+func (b CertBot) getBin() string {
+	if b.bin == "" {
+		return "certbot"
+	}
+
+	return b.bin
+}
+
 func CreateCertBot(config *config.Config) CertBot {
 	return CertBot{bin: config.CertBotBin}
 }
@@ -1,14 +1,24 @@
 package client
 
 import (
+	"context"
+	"io"
+
 	"github.com/r2dtools/agentintegration"
 	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/modules/certificates/acme"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/acme/client/certbot"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/acme/client/lego"
 )
 
 type AcmeClient interface {
-	Issue(docRoot string, certData agentintegration.CertificateIssueRequestData) error
+	// Issue obtains the certificate. The output of the ACME client is copied to the output writer if it is not nil.
+	// The ACME client process is killed when the context is done.
+	Issue(ctx context.Context, docRoot string, certData agentintegration.CertificateIssueRequestData, output io.Writer) error
+	// GetInfo returns the client version, supported challenge types and DNS providers
+	GetInfo(ctx context.Context) (*acme.ClientInfo, error)
+	// GetVersion returns the client name with its version, e.g. "lego 4.17.4". It runs the client once, so it is cheaper than GetInfo.
+	GetVersion(ctx context.Context) (string, error)
 }
 
 func CreateAcmeClient(config *config.Config) (AcmeClient, error) {
@@ -10,6 +10,7 @@ import (
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/pkg/certificate"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
 	"github.com/unknwon/com"
 )
 
@@ -117,7 +118,7 @@ func (s CertStorage) GetCertificatePath(certName string) (certPath string, err e
 	_, ok := certNameMap[certName]
 
 	if !ok {
-		return "", fmt.Errorf("could not find certificate '%s'", certName)
+		return "", router.NewError(router.ErrorCodeNotFound, "could not find certificate '%s'", certName)
 	}
 
 	certPath = s.getFilePathByNameWithExt(certName, certExtension)
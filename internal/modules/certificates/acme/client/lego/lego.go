@@ -1,16 +1,21 @@
 package lego
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/r2dtools/agentintegration"
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/acme"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/r2dtools/sslbot/internal/pkg/utils"
 	"github.com/unknwon/com"
 )
 
@@ -25,7 +30,7 @@ type Lego struct {
 	dataDir  string
 }
 
-func (l Lego) Issue(docRoot string, certData agentintegration.CertificateIssueRequestData) error {
+func (l Lego) Issue(ctx context.Context, docRoot string, certData agentintegration.CertificateIssueRequestData, output io.Writer) error {
 	var challengeType acme.ChallengeType
 	serverName := certData.ServerName
 
@@ -40,12 +45,12 @@ func (l Lego) Issue(docRoot string, certData agentintegration.CertificateIssueRe
 		provider := certData.GetAdditionalParam("provider")
 
 		if provider == "" {
-			return errors.New("dns provider is not specified")
+			return router.NewInvalidDataError("dns provider is not specified")
 		}
 
 		challengeType = &DNSChallengeType{provider}
 	default:
-		return fmt.Errorf("unsupported challenge type: %s", certData.ChallengeType)
+		return router.NewInvalidDataError("unsupported challenge type: %s", certData.ChallengeType)
 	}
 
 	params := []string{"--email=" + certData.Email, "--domains=" + serverName}
@@ -58,27 +63,99 @@ func (l Lego) Issue(docRoot string, certData agentintegration.CertificateIssueRe
 
 	params = append(params, challengeType.GetParams()...)
 
-	return l.execCmd("run", params)
+	return l.execCmd(ctx, "run", params, output)
 }
 
-func (l Lego) execCmd(command string, params []string) error {
+func (l Lego) GetInfo(ctx context.Context) (*acme.ClientInfo, error) {
+	info := &acme.ClientInfo{
+		Name:           "lego",
+		ChallengeTypes: []string{acme.HttpChallengeTypeCode, acme.DnsChallengeTypeCode},
+	}
+	version, err := l.getVersion(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info.Version = version
+	output, err := exec.CommandContext(ctx, l.bin, "dnshelp").CombinedOutput()
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get lego DNS providers: %v", err)
+	}
+
+	info.DnsProviders = parseDnsProviders(string(output))
+
+	return info, nil
+}
+
+func (l Lego) GetVersion(ctx context.Context) (string, error) {
+	version, err := l.getVersion(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	return "lego " + version, nil
+}
+
+func (l Lego) getVersion(ctx context.Context) (string, error) {
+	output, err := exec.CommandContext(ctx, l.bin, "--version").CombinedOutput()
+
+	if err != nil {
+		return "", fmt.Errorf("could not detect lego version: %v", err)
+	}
+
+	return parseVersion(string(output)), nil
+}
+
+func (l Lego) execCmd(ctx context.Context, command string, params []string, output io.Writer) error {
 	aParams := []string{"--server=" + l.caServer, "--accept-tos", "--path=" + l.dataDir, "--pem"}
 	params = append(params, aParams...)
 	params = append(params, command)
-	cmd := exec.Command(l.bin, params...)
-	output, err := cmd.CombinedOutput()
+	cmd := exec.CommandContext(ctx, l.bin, params...)
+	cmdOutput, err := utils.RunWithOutput(cmd, output)
 
 	if err != nil {
-		if len(output) == 0 {
+		if len(cmdOutput) == 0 {
 			return err
 		}
 
-		return errors.New(getOutputError(string(output)))
+		return errors.New(getOutputError(string(cmdOutput)))
 	}
 
 	return nil
 }
 
+// parseVersion parses "lego version 4.17.4 linux/amd64" output
+func parseVersion(output string) string {
+	fields := strings.Fields(output)
+
+	for i, field := range fields {
+		if field == "version" && i+1 < len(fields) {
+			return strings.TrimPrefix(fields[i+1], "v")
+		}
+	}
+
+	return strings.TrimSpace(output)
+}
+
+// parseDnsProviders parses the provider list following "Supported DNS providers:" in the dnshelp output
+func parseDnsProviders(output string) []string {
+	_, list, ok := strings.Cut(output, "Supported DNS providers:")
+
+	if !ok {
+		return nil
+	}
+
+	// The list ends with the first empty line
+	list, _, _ = strings.Cut(strings.TrimLeft(list, "\r\n"), "\n\n")
+
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
 func getOutputError(output string) string {
 	errIndex := strings.Index(output, "error: ")
 
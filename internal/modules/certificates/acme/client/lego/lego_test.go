@@ -34,3 +34,25 @@ func TestGetOutputError(t *testing.T) {
 		assert.Equal(t, item.output, output)
 	}
 }
+
+func TestParseDnsProviders(t *testing.T) {
+	output := `Credentials for DNS providers must be passed through environment variables.
+
+To display the documentation for a specific DNS provider, run:
+
+  $ lego dnshelp -c code
+
+Supported DNS providers:
+  acme-dns, alidns, allinkl, arvancloud,
+  cloudflare, route53
+
+More information: https://go-acme.github.io/lego/dns
+`
+	assert.Equal(t, []string{"acme-dns", "alidns", "allinkl", "arvancloud", "cloudflare", "route53"}, parseDnsProviders(output))
+	assert.Nil(t, parseDnsProviders("unknown command"))
+}
+
+func TestParseVersion(t *testing.T) {
+	assert.Equal(t, "4.17.4", parseVersion("lego version v4.17.4 linux/amd64\n"))
+	assert.Equal(t, "4.9.1", parseVersion("lego version 4.9.1 linux/amd64"))
+}
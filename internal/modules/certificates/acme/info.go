@@ -0,0 +1,6 @@
+package acme
+
+import "github.com/r2dtools/sslbot/pkg/protocol"
+
+// ClientInfo describes the ACME client used to issue certificates
+type ClientInfo = protocol.AcmeClientInfo
@@ -1,6 +1,7 @@
 package commondir
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
@@ -14,8 +15,10 @@ type CommonDir struct {
 }
 
 type CommonDirManager interface {
-	EnableCommonDir(serverName string) error
-	DisableCommonDir(serverName string) error
+	// EnableCommonDir and DisableCommonDir roll the configuration back if the webserver is not reloaded,
+	// e.g. the context is cancelled before the reload
+	EnableCommonDir(ctx context.Context, serverName string) error
+	DisableCommonDir(ctx context.Context, serverName string) error
 	GetCommonDirStatus(serverName string) CommonDir
 }
 
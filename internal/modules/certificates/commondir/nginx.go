@@ -1,6 +1,7 @@
 package commondir
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -23,7 +24,7 @@ type NginxCommonDirManager struct {
 	commonDir string
 }
 
-func (c *NginxCommonDirManager) EnableCommonDir(serverName string) error {
+func (c *NginxCommonDirManager) EnableCommonDir(ctx context.Context, serverName string) error {
 	wConfig := c.webServer.Config
 	serverBlock := c.findServerBlock(serverName)
 
@@ -75,7 +76,7 @@ func (c *NginxCommonDirManager) EnableCommonDir(serverName string) error {
 		return err
 	}
 
-	if err := processManager.Reload(); err != nil {
+	if err := processManager.Reload(ctx); err != nil {
 		if rErr := c.reverter.Rollback(); rErr != nil {
 			c.logger.Error(fmt.Sprintf("failed to rollback webserver configuration on webserver reload: %v", rErr))
 		}
@@ -88,7 +89,7 @@ func (c *NginxCommonDirManager) EnableCommonDir(serverName string) error {
 	return nil
 }
 
-func (c *NginxCommonDirManager) DisableCommonDir(serverName string) error {
+func (c *NginxCommonDirManager) DisableCommonDir(ctx context.Context, serverName string) error {
 	wConfig := c.webServer.Config
 	serverBlock := c.findServerBlock(serverName)
 
@@ -132,7 +133,7 @@ func (c *NginxCommonDirManager) DisableCommonDir(serverName string) error {
 		return err
 	}
 
-	if err := processManager.Reload(); err != nil {
+	if err := processManager.Reload(ctx); err != nil {
 		if rErr := c.reverter.Rollback(); rErr != nil {
 			c.logger.Error(fmt.Sprintf("failed to rollback webserver configuration on webserver reload: %v", rErr))
 		}
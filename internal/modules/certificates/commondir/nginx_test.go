@@ -1,6 +1,7 @@
 package commondir
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -22,7 +23,7 @@ func TestNginxCommonDir(t *testing.T) {
 	assert.False(t, commonDir.Enabled)
 	assert.Empty(t, commonDir.Root)
 
-	err := manager.EnableCommonDir(host)
+	err := manager.EnableCommonDir(context.Background(), host)
 	assert.Nil(t, err)
 	commonDir = manager.GetCommonDirStatus(host)
 	assert.True(t, commonDir.Enabled)
@@ -40,7 +41,7 @@ func TestNginxCommonDir(t *testing.T) {
 	})
 	assert.True(t, acmeBlockExists)
 
-	err = manager.DisableCommonDir(host)
+	err = manager.DisableCommonDir(context.Background(), host)
 	assert.Nil(t, err)
 	commonDir = manager.GetCommonDirStatus(host)
 	assert.False(t, commonDir.Enabled)
@@ -1,15 +1,14 @@
 package certificates
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"path/filepath"
 
-	"github.com/mitchellh/mapstructure"
 	"github.com/r2dtools/agentintegration"
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/acme/client"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/commondir"
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
 	"github.com/r2dtools/sslbot/internal/pkg/router"
 	"github.com/r2dtools/sslbot/internal/pkg/webserver"
@@ -22,65 +21,84 @@ type Handler struct {
 	config             *config.Config
 }
 
-func (h *Handler) Handle(request router.Request) (interface{}, error) {
-	var response any
-	var err error
-
-	switch action := request.GetAction(); action {
-	case "issue":
-		response, err = h.issueCertificateToDomain(request.Data)
-	case "upload":
-		response, err = h.uploadCertificateToDomain(request.Data)
-	case "storagecertificates":
-		response, err = h.storageCertificates()
-	case "storagecertdata":
-		response, err = h.storageCertData(request.Data)
-	case "storagecertupload":
-		response, err = h.uploadCertToStorage(request.Data)
-	case "storagecertremove":
-		err = h.removeCertFromStorage(request.Data)
-	case "storagecertdownload":
-		response, err = h.downloadCertFromStorage(request.Data)
-	case "domainassign":
-		response, err = h.assignCertificateToDomain(request.Data)
-	case "commondirstatus":
-		response, err = h.commonDirStatus(request.Data)
-	case "changecommondirstatus":
-		err = h.changeCommonDirStatus(request.Data)
-	default:
-		response, err = nil, fmt.Errorf("invalid action '%s' for module '%s'", action, request.GetModule())
-	}
+// IssueRequestData mirrors agentintegration.CertificateIssueRequestData with validation rules
+type IssueRequestData struct {
+	Email            string   `validate:"required,email"`
+	ServerName       string   `validate:"required,domain"`
+	WebServer        string   `validate:"required"`
+	ChallengeType    string   `validate:"required,oneof=http dns"`
+	Subjects         []string `validate:"domain"`
+	AdditionalParams map[string]string
+	Assign           bool
+}
 
-	return response, err
+// UploadRequestData mirrors agentintegration.CertificateUploadRequestData with validation rules
+type UploadRequestData struct {
+	ServerName     string `validate:"domain"`
+	WebServer      string
+	CertName       string `validate:"filename"`
+	PemCertificate string `validate:"required"`
 }
 
-func (h *Handler) issueCertificateToDomain(data interface{}) (*agentintegration.Certificate, error) {
-	var certData agentintegration.CertificateIssueRequestData
-	err := mapstructure.Decode(data, &certData)
+// AssignRequestData mirrors agentintegration.CertificateAssignRequestData with validation rules
+type AssignRequestData struct {
+	ServerName string `validate:"required,domain"`
+	WebServer  string `validate:"required"`
+	CertName   string `validate:"required,filename"`
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("invalid certificate request data: %v", err)
-	}
+// CertNameRequestData identifies a storage certificate. The name may also be sent as plain string data.
+type CertNameRequestData struct {
+	CertName string `validate:"required,filename"`
+}
 
-	return h.certificateManager.Issue(certData)
+func (d *CertNameRequestData) DecodeString(value string) {
+	d.CertName = value
 }
 
-func (h *Handler) uploadCertificateToDomain(data interface{}) (*agentintegration.Certificate, error) {
-	var requestData agentintegration.CertificateUploadRequestData
-	err := mapstructure.Decode(data, &requestData)
+type CommonDirStatusRequestData struct {
+	WebServer  string `validate:"required"`
+	ServerName string `validate:"required,domain"`
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("invalid certificate request data: %v", err)
+type CommonDirChangeStatusRequestData struct {
+	WebServer  string `validate:"required"`
+	ServerName string `validate:"required,domain"`
+	Status     bool
+}
+
+func (h *Handler) getActions() []router.Action {
+	return []router.Action{
+		router.NewAction("issue", "Issue a certificate for the domain and optionally assign it", h.issueCertificateToDomain).Mutates(),
+		router.NewAction("upload", "Upload a PEM certificate and assign it to the domain", h.uploadCertificateToDomain).Mutates(),
+		router.NewActionWithoutData("storagecertificates", "List certificates in the storage", h.storageCertificates),
+		router.NewAction("storagecertdata", "Get a certificate from the storage", h.storageCertData),
+		router.NewAction("storagecertupload", "Upload a PEM certificate to the storage", h.uploadCertToStorage).Mutates(),
+		router.NewAction("storagecertremove", "Remove a certificate from the storage", h.removeCertFromStorage).Mutates(),
+		router.NewAction("storagecertdownload", "Download a certificate from the storage", h.downloadCertFromStorage),
+		router.NewAction("domainassign", "Assign a certificate from the storage to the domain", h.assignCertificateToDomain).Mutates(),
+		router.NewAction("commondirstatus", "Get the status of the common directory for ACME challenges", h.commonDirStatus),
+		router.NewAction("changecommondirstatus", "Enable or disable the common directory for ACME challenges", h.changeCommonDirStatus).Mutates(),
 	}
+}
+
+func (h *Handler) issueCertificateToDomain(ctx context.Context, request router.Request, data IssueRequestData) (*agentintegration.Certificate, error) {
+	audit.GetRecord(ctx).SetVhost(data.ServerName)
 
-	if requestData.ServerName == "" {
-		return nil, errors.New("domain name is missed")
+	return h.certificateManager.Issue(ctx, agentintegration.CertificateIssueRequestData(data), request.Output, request.Progress)
+}
+
+func (h *Handler) uploadCertificateToDomain(ctx context.Context, request router.Request, data UploadRequestData) (*agentintegration.Certificate, error) {
+	if data.ServerName == "" {
+		return nil, router.NewInvalidDataError("domain name is missed")
 	}
 
-	return h.certificateManager.Upload(requestData.ServerName, requestData.WebServer, requestData.PemCertificate)
+	audit.GetRecord(ctx).SetVhost(data.ServerName)
+
+	return h.certificateManager.Upload(ctx, data.ServerName, data.WebServer, data.PemCertificate, request.Progress)
 }
 
-func (h *Handler) storageCertificates() (*agentintegration.CertificatesResponseData, error) {
+func (h *Handler) storageCertificates(ctx context.Context, request router.Request) (*agentintegration.CertificatesResponseData, error) {
 	certsMap, err := h.certificateManager.GetStorageCertificates()
 
 	if err != nil {
@@ -94,27 +112,17 @@ func (h *Handler) storageCertificates() (*agentintegration.CertificatesResponseD
 	return &response, nil
 }
 
-func (h *Handler) storageCertData(data interface{}) (*agentintegration.Certificate, error) {
-	certName, ok := data.(string)
-	if !ok {
-		return nil, errors.New("invalid certificate name data is provided")
-	}
-
-	return h.certificateManager.GetStorageCertData(certName)
+func (h *Handler) storageCertData(ctx context.Context, request router.Request, data CertNameRequestData) (*agentintegration.Certificate, error) {
+	return h.certificateManager.GetStorageCertData(data.CertName)
 }
 
-func (h *Handler) uploadCertToStorage(data interface{}) (*agentintegration.Certificate, error) {
-	var requestData agentintegration.CertificateUploadRequestData
-	err := mapstructure.Decode(data, &requestData)
-
-	if err != nil {
-		return nil, fmt.Errorf("invalid certificate request data: %v", err)
-	}
-
+func (h *Handler) uploadCertToStorage(ctx context.Context, request router.Request, requestData UploadRequestData) (*agentintegration.Certificate, error) {
 	if requestData.CertName == "" {
-		return nil, errors.New("certificate name is missed")
+		return nil, router.NewInvalidDataError("certificate name is missed")
 	}
 
+	audit.GetRecord(ctx).SetCertName(requestData.CertName)
+
 	storage, err := client.CreateCertStorage(h.config, h.logger)
 
 	if err != nil {
@@ -130,34 +138,26 @@ func (h *Handler) uploadCertToStorage(data interface{}) (*agentintegration.Certi
 	return storage.GetCertificate(requestData.CertName)
 }
 
-func (h *Handler) removeCertFromStorage(data interface{}) error {
-	certName, ok := data.(string)
-	if !ok {
-		return errors.New("invalid certificate name data is provided")
-	}
+func (h *Handler) removeCertFromStorage(ctx context.Context, request router.Request, data CertNameRequestData) (interface{}, error) {
+	audit.GetRecord(ctx).SetCertName(data.CertName)
 
 	storage, err := client.CreateCertStorage(h.config, h.logger)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return storage.RemoveCertificate(certName)
+	return nil, storage.RemoveCertificate(data.CertName)
 }
 
-func (h *Handler) downloadCertFromStorage(data interface{}) (*agentintegration.CertificateDownloadResponseData, error) {
-	certName, ok := data.(string)
-	if !ok {
-		return nil, errors.New("invalid certificate name data is provided")
-	}
-
+func (h *Handler) downloadCertFromStorage(ctx context.Context, request router.Request, data CertNameRequestData) (*agentintegration.CertificateDownloadResponseData, error) {
 	storage, err := client.CreateCertStorage(h.config, h.logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	certPath, certContent, err := storage.GetCertificateAsString(certName)
+	certPath, certContent, err := storage.GetCertificateAsString(data.CertName)
 
 	if err != nil {
 		return nil, err
@@ -170,25 +170,15 @@ func (h *Handler) downloadCertFromStorage(data interface{}) (*agentintegration.C
 	return &certDownloadResponse, nil
 }
 
-func (h *Handler) assignCertificateToDomain(data interface{}) (*agentintegration.Certificate, error) {
-	var certData agentintegration.CertificateAssignRequestData
-	err := mapstructure.Decode(data, &certData)
+func (h *Handler) assignCertificateToDomain(ctx context.Context, request router.Request, data AssignRequestData) (*agentintegration.Certificate, error) {
+	record := audit.GetRecord(ctx)
+	record.SetVhost(data.ServerName)
+	record.SetCertName(data.CertName)
 
-	if err != nil {
-		return nil, fmt.Errorf("invalid certificate request data: %v", err)
-	}
-
-	return h.certificateManager.Assign(certData)
+	return h.certificateManager.Assign(ctx, agentintegration.CertificateAssignRequestData(data), request.Progress)
 }
 
-func (h *Handler) commonDirStatus(data interface{}) (*agentintegration.CommonDirStatusResponseData, error) {
-	var requestData agentintegration.CommonDirChangeStatusRequestData
-	err := mapstructure.Decode(data, &requestData)
-
-	if err != nil {
-		return nil, fmt.Errorf("invalid common dir status request data: %v", err)
-	}
-
+func (h *Handler) commonDirStatus(ctx context.Context, request router.Request, requestData CommonDirStatusRequestData) (*agentintegration.CommonDirStatusResponseData, error) {
 	options := h.config.ToMap()
 	wServer, err := webserver.GetWebServer(requestData.WebServer, options)
 
@@ -200,6 +190,7 @@ func (h *Handler) commonDirStatus(data interface{}) (*agentintegration.CommonDir
 		HostMng: wServer.GetVhostManager(),
 		Logger:  h.logger,
 	}
+	defer webServerReverter.Release()
 
 	commonDirManager, err := commondir.GetCommonDirManager(wServer, webServerReverter, h.logger, options)
 
@@ -212,51 +203,50 @@ func (h *Handler) commonDirStatus(data interface{}) (*agentintegration.CommonDir
 	return &agentintegration.CommonDirStatusResponseData{Status: status.Enabled}, nil
 }
 
-func (h *Handler) changeCommonDirStatus(data interface{}) error {
-	var requestData agentintegration.CommonDirChangeStatusRequestData
-	err := mapstructure.Decode(data, &requestData)
-
-	if err != nil {
-		return fmt.Errorf("invalid common dir status request data: %v", err)
-	}
+func (h *Handler) changeCommonDirStatus(ctx context.Context, request router.Request, requestData CommonDirChangeStatusRequestData) (interface{}, error) {
+	audit.GetRecord(ctx).SetVhost(requestData.ServerName)
 
 	options := h.config.ToMap()
 	wServer, err := webserver.GetWebServer(requestData.WebServer, options)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	webServerReverter := &reverter.Reverter{
 		HostMng: wServer.GetVhostManager(),
 		Logger:  h.logger,
+		Audit:   audit.GetRecord(ctx),
 	}
+	defer webServerReverter.Release()
 
 	commonDirManager, err := commondir.GetCommonDirManager(wServer, webServerReverter, h.logger, options)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if requestData.Status {
-		err = commonDirManager.EnableCommonDir(requestData.ServerName)
+		err = commonDirManager.EnableCommonDir(ctx, requestData.ServerName)
 	} else {
-		err = commonDirManager.DisableCommonDir(requestData.ServerName)
+		err = commonDirManager.DisableCommonDir(ctx, requestData.ServerName)
 	}
 
-	return err
+	return nil, err
 }
 
-func GetHandler(config *config.Config, logger logger.Logger) (router.HandlerInterface, error) {
+func GetHandler(config *config.Config, logger logger.Logger) (*router.Module, error) {
 	certManager, err := GetCertificateManager(config, logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Handler{
+	handler := &Handler{
 		logger:             logger,
 		certificateManager: certManager,
 		config:             config,
-	}, nil
+	}
+
+	return router.NewModule(handler.getActions()...), nil
 }
@@ -1,20 +1,32 @@
 package certificates
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/r2dtools/agentintegration"
 	"github.com/r2dtools/sslbot/config"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/acme/client"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/commondir"
 	"github.com/r2dtools/sslbot/internal/modules/certificates/deploy"
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
 	"github.com/r2dtools/sslbot/internal/pkg/certificate"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
 	"github.com/r2dtools/sslbot/internal/pkg/webserver"
 	"github.com/r2dtools/sslbot/internal/pkg/webserver/reverter"
 )
 
+var acmeLogTimeRegex = regexp.MustCompile(`^[0-9]{4}/[0-9]{2}/[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2} `)
+
 type CertificateManager struct {
 	CertStorage client.CertStorage
 	acmeClient  client.AcmeClient
@@ -22,7 +34,9 @@ type CertificateManager struct {
 	config      *config.Config
 }
 
-func (c *CertificateManager) Issue(certData agentintegration.CertificateIssueRequestData) (*agentintegration.Certificate, error) {
+// Issue obtains the certificate for the domain and deploys it if requested. The ACME client output is copied to the output writer if it is not nil.
+// The ACME client is stopped when the context is done.
+func (c *CertificateManager) Issue(ctx context.Context, certData agentintegration.CertificateIssueRequestData, output io.Writer, reporter progress.Reporter) (*agentintegration.Certificate, error) {
 	serverName := certData.ServerName
 
 	options := c.config.ToMap()
@@ -33,9 +47,12 @@ func (c *CertificateManager) Issue(certData agentintegration.CertificateIssueReq
 	}
 
 	webServerReverter := &reverter.Reverter{
-		HostMng: wServer.GetVhostManager(),
-		Logger:  c.logger,
+		HostMng:  wServer.GetVhostManager(),
+		Logger:   c.logger,
+		Progress: reporter,
+		Audit:    audit.GetRecord(ctx),
 	}
+	defer webServerReverter.Release()
 	commonDirManager, err := commondir.GetCommonDirManager(wServer, webServerReverter, c.logger, options)
 
 	if err != nil {
@@ -49,7 +66,7 @@ func (c *CertificateManager) Issue(certData agentintegration.CertificateIssueReq
 	}
 
 	if vhost == nil {
-		return nil, fmt.Errorf("host %s not found", serverName)
+		return nil, router.NewError(router.ErrorCodeNotFound, "host %s not found", serverName)
 	}
 
 	docRoot := vhost.DocRoot
@@ -59,14 +76,31 @@ func (c *CertificateManager) Issue(certData agentintegration.CertificateIssueReq
 		docRoot = commonDir.Root
 	}
 
-	err = c.acmeClient.Issue(docRoot, certData)
+	reporter.Report(progress.StageIssue, "requesting certificate for %s with %s challenge", serverName, certData.ChallengeType)
+	err = c.acmeClient.Issue(ctx, docRoot, certData, getAcmeOutputWriter(output, reporter))
 
 	if err != nil {
 		c.logger.Debug("%v", err)
+		reporter.Report(progress.StageIssue, "certificate request failed")
+
+		if ctx.Err() != nil {
+			metrics.AcmeIssuances.WithLabelValues(certData.ChallengeType, metrics.ResultCancelled).Inc()
+			err = router.NewContextError(ctx)
+		} else {
+			metrics.AcmeIssuances.WithLabelValues(certData.ChallengeType, metrics.ResultFailure).Inc()
+
+			if router.GetError(err).Code == router.ErrorCodeInternal {
+				err = router.WrapError(router.ErrorCodeAcmeValidationFailed, err)
+			}
+		}
 
 		return nil, err
 	}
 
+	metrics.AcmeIssuances.WithLabelValues(certData.ChallengeType, metrics.ResultSuccess).Inc()
+
+	reporter.Report(progress.StageIssue, "certificate for %s is issued", serverName)
+
 	if certData.Assign {
 		certPath, err := c.CertStorage.GetCertificatePath(serverName)
 
@@ -74,13 +108,13 @@ func (c *CertificateManager) Issue(certData agentintegration.CertificateIssueReq
 			return nil, err
 		}
 
-		return c.deployCertificate(wServer, serverName, certPath, certPath)
+		return c.deployCertificate(ctx, wServer, serverName, certPath, certPath, reporter)
 	}
 
 	return c.CertStorage.GetCertificate(serverName)
 }
 
-func (c *CertificateManager) Assign(certData agentintegration.CertificateAssignRequestData) (*agentintegration.Certificate, error) {
+func (c *CertificateManager) Assign(ctx context.Context, certData agentintegration.CertificateAssignRequestData, reporter progress.Reporter) (*agentintegration.Certificate, error) {
 	certPath, err := c.CertStorage.GetCertificatePath(certData.CertName)
 	if err != nil {
 		return nil, fmt.Errorf("could not assign certificate to the domain '%s': %v", certData.ServerName, err)
@@ -92,10 +126,10 @@ func (c *CertificateManager) Assign(certData agentintegration.CertificateAssignR
 		return nil, err
 	}
 
-	return c.deployCertificate(wServer, certData.ServerName, certPath, certPath)
+	return c.deployCertificate(ctx, wServer, certData.ServerName, certPath, certPath, reporter)
 }
 
-func (c *CertificateManager) Upload(certName, webServer, pemData string) (*agentintegration.Certificate, error) {
+func (c *CertificateManager) Upload(ctx context.Context, certName, webServer, pemData string, reporter progress.Reporter) (*agentintegration.Certificate, error) {
 	var certPath string
 	var err error
 	if certPath, err = c.CertStorage.AddPemCertificate(certName, pemData); err != nil {
@@ -108,13 +142,44 @@ func (c *CertificateManager) Upload(certName, webServer, pemData string) (*agent
 		return nil, err
 	}
 
-	return c.deployCertificate(wServer, certName, certPath, certPath)
+	return c.deployCertificate(ctx, wServer, certName, certPath, certPath, reporter)
 }
 
 func (c *CertificateManager) GetStorageCertificates() (map[string]*agentintegration.Certificate, error) {
 	return c.CertStorage.GetCertificates()
 }
 
+// CollectMetrics updates the number of storage certificates and days until each of them expires
+func (c *CertificateManager) CollectMetrics() {
+	certificates, err := c.CertStorage.GetCertificates()
+
+	if err != nil {
+		c.logger.Error("could not collect certificate metrics: %v", err)
+
+		return
+	}
+
+	now := time.Now()
+	metrics.CertificateExpiryDays.Reset()
+	metrics.StorageCertificates.Set(float64(len(certificates)))
+
+	for name, cert := range certificates {
+		if cert == nil {
+			continue
+		}
+
+		validTo, err := time.Parse(time.RFC822Z, cert.ValidTo)
+
+		if err != nil {
+			c.logger.Error("could not parse expiry date of certificate '%s': %v", name, err)
+
+			continue
+		}
+
+		metrics.CertificateExpiryDays.WithLabelValues(name).Set(math.Floor(validTo.Sub(now).Hours() / 24))
+	}
+}
+
 func (c *CertificateManager) GetStorageCertData(certName string) (*agentintegration.Certificate, error) {
 	return c.CertStorage.GetCertificate(certName)
 }
@@ -123,7 +188,9 @@ func (c *CertificateManager) RemoveCertificate(certName string) error {
 	return c.CertStorage.RemoveCertificate(certName)
 }
 
-func (c *CertificateManager) deployCertificate(wServer webserver.WebServer, serverName, certPath, keyPath string) (*agentintegration.Certificate, error) {
+// deployCertificate writes the webserver configuration for the certificate and reloads the webserver. The configuration is
+// rolled back if a step fails or the context is done before the webserver is reloaded.
+func (c *CertificateManager) deployCertificate(ctx context.Context, wServer webserver.WebServer, serverName, certPath, keyPath string, reporter progress.Reporter) (*agentintegration.Certificate, error) {
 	processManager, err := wServer.GetProcessManager()
 
 	if err != nil {
@@ -136,12 +203,15 @@ func (c *CertificateManager) deployCertificate(wServer webserver.WebServer, serv
 	}
 
 	webServerReverter := &reverter.Reverter{
-		HostMng: wServer.GetVhostManager(),
-		Logger:  c.logger,
+		HostMng:  wServer.GetVhostManager(),
+		Logger:   c.logger,
+		Progress: reporter,
+		Audit:    audit.GetRecord(ctx),
 	}
+	defer webServerReverter.Release()
 
 	if vhost == nil {
-		return nil, fmt.Errorf("could not find virtual host '%s'", serverName)
+		return nil, router.NewError(router.ErrorCodeNotFound, "could not find virtual host '%s'", serverName)
 	}
 
 	deployer, err := deploy.GetCertificateDeployer(wServer, webServerReverter, c.logger)
@@ -149,30 +219,31 @@ func (c *CertificateManager) deployCertificate(wServer webserver.WebServer, serv
 		return nil, err
 	}
 
+	if ctx.Err() != nil {
+		return nil, router.NewContextError(ctx)
+	}
+
+	reporter.Report(progress.StageDeploy, "deploying certificate to %s", serverName)
 	sslConfigFilePath, originEnabledConfigFilePath, err := deployer.DeployCertificate(vhost, certPath, keyPath)
 
 	if err != nil {
-		if rErr := webServerReverter.Rollback(); rErr != nil {
-			c.logger.Error(fmt.Sprintf("failed to rallback webserver configuration on cert deploy: %v", rErr))
-		}
-
-		return nil, err
+		return nil, c.rollback(webServerReverter, router.WrapError(router.ErrorCodeDeployFailed, err), "cert deploy")
 	}
 
+	reporter.Report(progress.StageDeploy, "webserver configuration is written to %s", sslConfigFilePath)
+
 	if err = wServer.GetVhostManager().Enable(sslConfigFilePath, filepath.Dir(originEnabledConfigFilePath)); err != nil {
-		if rErr := webServerReverter.Rollback(); rErr != nil {
-			c.logger.Error(fmt.Sprintf("failed to rallback webserver configuration on host enabling: %v", rErr))
-		}
+		return nil, c.rollback(webServerReverter, router.WrapError(router.ErrorCodeDeployFailed, err), "host enabling")
+	}
 
-		return nil, err
+	if ctx.Err() != nil {
+		return nil, c.rollback(webServerReverter, router.NewContextError(ctx), "request cancellation")
 	}
 
-	if err = processManager.Reload(); err != nil {
-		if rErr := webServerReverter.Rollback(); rErr != nil {
-			c.logger.Error(fmt.Sprintf("failed to rallback webserver configuration on webserver reload: %v", rErr))
-		}
+	reporter.Report(progress.StageReload, "reloading %s", wServer.GetCode())
 
-		return nil, err
+	if err = processManager.Reload(ctx); err != nil {
+		return nil, c.rollback(webServerReverter, router.WrapError(router.ErrorCodeWebServerReloadFailed, err), "webserver reload")
 	}
 
 	if err = webServerReverter.Commit(); err != nil {
@@ -181,9 +252,51 @@ func (c *CertificateManager) deployCertificate(wServer webserver.WebServer, serv
 		}
 	}
 
+	reporter.Report(progress.StageReload, "%s is reloaded", wServer.GetCode())
+
 	return certificate.GetCertificateFromFile(certPath)
 }
 
+// rollback reverts the webserver configuration after the failed deployment step. If the rollback fails,
+// the configuration may be inconsistent, so the rollback_failed error is returned instead of the step error.
+func (c *CertificateManager) rollback(webServerReverter *reverter.Reverter, err error, step string) error {
+	rErr := webServerReverter.Rollback()
+
+	if rErr == nil {
+		metrics.Rollbacks.WithLabelValues(metrics.ResultSuccess).Inc()
+
+		return err
+	}
+
+	metrics.Rollbacks.WithLabelValues(metrics.ResultFailure).Inc()
+
+	c.logger.Error(fmt.Sprintf("failed to rallback webserver configuration on %s: %v", step, rErr))
+
+	return router.NewError(router.ErrorCodeRollbackFailed, "%v: %w", err, rErr).WithDetails(map[string]interface{}{"Cause": router.GetError(err)})
+}
+
+// getAcmeOutputWriter copies the ACME client output to the output writer and reports its lines as progress events
+func getAcmeOutputWriter(output io.Writer, reporter progress.Reporter) io.Writer {
+	if reporter == nil {
+		return output
+	}
+
+	lineWriter := progress.NewLineWriter(func(line string) {
+		// Skip log time: xxxx/xx/xx xx:xx:xx
+		line = strings.TrimSpace(acmeLogTimeRegex.ReplaceAllString(line, ""))
+
+		if line != "" {
+			reporter.Report(progress.StageAcme, "%s", line)
+		}
+	})
+
+	if output == nil {
+		return lineWriter
+	}
+
+	return io.MultiWriter(output, lineWriter)
+}
+
 func GetCertificateManager(config *config.Config, logger logger.Logger) (*CertificateManager, error) {
 	storage, err := client.CreateCertStorage(config, logger)
 
@@ -0,0 +1,86 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+)
+
+type Handler struct {
+	Manager *Manager
+}
+
+// NewHandler creates the module with job actions
+func NewHandler(manager *Manager) *router.Module {
+	h := &Handler{Manager: manager}
+
+	return router.NewModule(
+		router.NewAction("status", "Get the status and the result of a job", h.status),
+		router.NewAction("list", "List jobs optionally filtered by status", h.list),
+		router.NewAction("cancel", "Cancel a queued or running job", h.cancel).Mutates(),
+	)
+}
+
+// JobRequestData identifies a job. The job id may also be sent as plain string data.
+type JobRequestData struct {
+	Id string `validate:"required"`
+}
+
+func (d *JobRequestData) DecodeString(value string) {
+	d.Id = value
+}
+
+// ListRequestData filters listed jobs. The status may also be sent as plain string data.
+type ListRequestData struct {
+	Status string `validate:"oneof=queued running succeeded failed cancelled"`
+}
+
+func (d *ListRequestData) DecodeString(value string) {
+	d.Status = value
+}
+
+func (h *Handler) status(ctx context.Context, request router.Request, data JobRequestData) (*Job, error) {
+	return h.getOwnJob(request, data.Id)
+}
+
+func (h *Handler) list(ctx context.Context, request router.Request, data ListRequestData) ([]*Job, error) {
+	jobs := []*Job{}
+
+	for _, job := range h.Manager.List(data.Status) {
+		if isJobOwner(request, job) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+func (h *Handler) cancel(ctx context.Context, request router.Request, data JobRequestData) (*Job, error) {
+	if _, err := h.getOwnJob(request, data.Id); err != nil {
+		return nil, err
+	}
+
+	return h.Manager.Cancel(data.Id)
+}
+
+// getOwnJob returns the job if the client is allowed to access it. Jobs of other tokens are reported as not found.
+func (h *Handler) getOwnJob(request router.Request, id string) (*Job, error) {
+	job, err := h.Manager.Get(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isJobOwner(request, job) {
+		return nil, ErrJobNotFound
+	}
+
+	return job, nil
+}
+
+// isJobOwner reports whether the job is submitted with the token of the request. Clients allowed to execute all commands
+// can access all jobs.
+func isJobOwner(request router.Request, job *Job) bool {
+	return auth.AllowsAll(request.Scopes) || job.TokenName == request.TokenName
+}
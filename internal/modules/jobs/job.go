@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"sync"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+const (
+	StatusQueued    = protocol.JobStatusQueued
+	StatusRunning   = protocol.JobStatusRunning
+	StatusSucceeded = protocol.JobStatusSucceeded
+	StatusFailed    = protocol.JobStatusFailed
+	StatusCancelled = protocol.JobStatusCancelled
+)
+
+// maxOutputSize limits the captured output of a job: only its tail is kept
+const maxOutputSize = 1 << 20 // bytes
+
+type Job = protocol.Job
+
+// jobOutput captures the output of external programs run by a job
+type jobOutput struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.data = append(o.data, p...)
+
+	if len(o.data) > maxOutputSize {
+		o.data = o.data[len(o.data)-maxOutputSize:]
+	}
+
+	return len(p), nil
+}
+
+func (o *jobOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return string(o.data)
+}
@@ -0,0 +1,422 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+)
+
+var ErrJobNotFound = router.NewError(router.ErrorCodeNotFound, "job not found")
+
+// rollbackGracePeriod is the time jobs get to stop and roll back their changes after the shutdown timed out
+var rollbackGracePeriod = 10 * time.Second
+
+var (
+	errJobCancelled = router.NewError(router.ErrorCodeCancelled, "job is cancelled")
+	errJobStopped   = router.NewError(router.ErrorCodeShuttingDown, "agent was stopped before the job finished")
+)
+
+type task struct {
+	job     *Job
+	request router.Request
+	output  *jobOutput
+}
+
+// Manager runs requests as background jobs in a pool of workers. Job state is persisted in a directory,
+// so finished jobs can be queried after the agent restart.
+type Manager struct {
+	dir       string
+	retention time.Duration
+	handle    router.HandleFunc
+	logger    logger.Logger
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	outputs   map[string]*jobOutput
+	// cancels contains functions cancelling contexts of the running jobs
+	cancels   map[string]context.CancelCauseFunc
+	queue     chan *task
+	closed    bool
+	workersWg sync.WaitGroup
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+}
+
+// NewManager loads persisted jobs and starts workers. Jobs that were queued or running when the agent stopped are marked as failed:
+// their request data is not persisted as it may contain private keys, so they can not be resumed.
+func NewManager(config *config.Config, logger logger.Logger, handle router.HandleFunc) (*Manager, error) {
+	m := &Manager{
+		dir:       config.GetPathInsideVarDir("jobs"),
+		retention: config.JobRetention,
+		handle:    handle,
+		logger:    logger,
+		jobs:      make(map[string]*Job),
+		outputs:   make(map[string]*jobOutput),
+		cancels:   make(map[string]context.CancelCauseFunc),
+		queue:     make(chan *task, max(config.JobQueueSize, 1)),
+		now:       time.Now,
+	}
+
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create jobs directory: %v", err)
+	}
+
+	if err := m.load(m.now()); err != nil {
+		return nil, err
+	}
+
+	for range max(config.JobWorkers, 1) {
+		m.workersWg.Add(1)
+		go m.work()
+	}
+
+	return m, nil
+}
+
+// Submit queues the request and returns the created job
+func (m *Manager) Submit(request router.Request) (*Job, error) {
+	jobId, err := uuid.NewRandom()
+
+	if err != nil {
+		return nil, fmt.Errorf("could not generate job id: %v", err)
+	}
+
+	job := &Job{
+		Id:        jobId.String(),
+		Command:   request.GetCommand(),
+		TokenName: request.TokenName,
+		Status:    StatusQueued,
+		CreatedAt: m.now(),
+	}
+	output := &jobOutput{}
+	request.Async = false
+	request.Output = output
+	// The response is already sent, so progress events are recorded in the job output
+	request.Events = false
+	request.Progress = func(event progress.Event) {
+		fmt.Fprintln(output, event)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, errors.New("agent is shutting down")
+	}
+
+	select {
+	case m.queue <- &task{job: job, request: request, output: output}:
+	default:
+		return nil, fmt.Errorf("job queue is full: %d job(s) are waiting", cap(m.queue))
+	}
+
+	m.prune(job.CreatedAt)
+	m.jobs[job.Id] = job
+	m.outputs[job.Id] = output
+	m.save(job)
+	m.logger.Info("job %s is queued: %s", job.Id, job.Command)
+
+	return m.snapshot(job), nil
+}
+
+// Get returns the job with the output captured so far
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return m.snapshot(job), nil
+}
+
+// List returns jobs without their results and output, the newest first. Jobs are filtered by status if it is not empty.
+func (m *Manager) List(status string) []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := []*Job{}
+
+	for _, job := range m.jobs {
+		if status != "" && job.Status != status {
+			continue
+		}
+
+		listedJob := *job
+		listedJob.Result = nil
+		listedJob.Output = ""
+		jobs = append(jobs, &listedJob)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	return jobs
+}
+
+// Cancel cancels the queued job. The context of the running job is cancelled, so the job is stopped and its changes
+// are rolled back. The job gets the cancelled status when it is finished.
+func (m *Manager) Cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	switch job.Status {
+	case StatusQueued:
+		job.Status = StatusCancelled
+		job.FinishedAt = m.now()
+		m.save(job)
+		m.logger.Info("job %s is cancelled", job.Id)
+	case StatusRunning:
+		m.cancels[job.Id](errJobCancelled)
+		m.logger.Info("cancellation of running job %s is requested", job.Id)
+	}
+
+	return m.snapshot(job), nil
+}
+
+// Shutdown stops accepting jobs and waits for the running ones until the context is done.
+// Queued jobs are not started. Jobs that are still running when the context is done are cancelled
+// and get rollbackGracePeriod to roll back their changes.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		m.workersWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+
+		for _, cancel := range m.cancels {
+			cancel(errJobStopped)
+		}
+
+		m.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-time.After(rollbackGracePeriod):
+			m.logger.Error("jobs are still running after %s of the rollback: %s", rollbackGracePeriod, strings.Join(m.getRunningJobs(), ", "))
+		}
+
+		return fmt.Errorf("jobs are still running: %v", ctx.Err())
+	}
+}
+
+// getRunningJobs returns identifiers and commands of the running jobs
+func (m *Manager) getRunningJobs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var jobs []string
+
+	for id := range m.cancels {
+		jobs = append(jobs, fmt.Sprintf("%s (%s)", id, m.jobs[id].Command))
+	}
+
+	sort.Strings(jobs)
+
+	return jobs
+}
+
+func (m *Manager) work() {
+	defer m.workersWg.Done()
+
+	for task := range m.queue {
+		ctx, cancel := context.WithCancelCause(context.Background())
+
+		if !m.start(task.job, cancel) {
+			cancel(nil)
+
+			continue
+		}
+
+		result, err := m.handle(ctx, task.request)
+		m.finish(task.job, result, err, context.Cause(ctx))
+		cancel(nil)
+	}
+}
+
+func (m *Manager) start(job *Job, cancel context.CancelCauseFunc) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job.Status != StatusQueued || m.closed {
+		return false
+	}
+
+	job.Status = StatusRunning
+	job.StartedAt = m.now()
+	m.cancels[job.Id] = cancel
+	m.save(job)
+	m.logger.Info("job %s is started: %s", job.Id, job.Command)
+
+	return true
+}
+
+// finish records the job result. cause is the reason of the job context cancellation, it is nil if the job was not cancelled.
+func (m *Manager) finish(job *Job, result interface{}, err error, cause error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.FinishedAt = m.now()
+	job.Output = m.outputs[job.Id].String()
+	delete(m.outputs, job.Id)
+	delete(m.cancels, job.Id)
+
+	// The error of a cancelled job is replaced with the reason of the cancellation unless its changes could not be rolled back
+	if cause != nil && err != nil && router.GetError(err).Code != router.ErrorCodeRollbackFailed {
+		err = cause
+	}
+
+	if err == errJobCancelled {
+		job.Status = StatusCancelled
+		job.Error = err.Error()
+		job.ErrorCode = router.ErrorCodeCancelled
+		m.logger.Info("job %s is cancelled", job.Id)
+	} else if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		job.ErrorCode = router.GetError(err).Code
+		m.logger.Error("job %s failed: %v", job.Id, err)
+	} else {
+		job.Status = StatusSucceeded
+		job.Result = result
+		m.logger.Info("job %s succeeded", job.Id)
+	}
+
+	m.save(job)
+	m.prune(job.FinishedAt)
+}
+
+// prune removes finished jobs kept longer than the retention. The caller must hold the lock.
+func (m *Manager) prune(now time.Time) {
+	if m.retention <= 0 {
+		return
+	}
+
+	for id, job := range m.jobs {
+		if !job.IsFinished() || now.Sub(job.FinishedAt) <= m.retention {
+			continue
+		}
+
+		delete(m.jobs, id)
+
+		if err := os.Remove(m.getJobPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.logger.Error("could not remove expired job %s: %v", id, err)
+		}
+	}
+}
+
+// snapshot returns a copy of the job with the output of the running job
+func (m *Manager) snapshot(job *Job) *Job {
+	jobCopy := *job
+
+	if output, ok := m.outputs[job.Id]; ok {
+		jobCopy.Output = output.String()
+	}
+
+	return &jobCopy
+}
+
+// save persists the job. Secrets of the result, e.g. a rotated token, are redacted: they are returned only
+// while the agent is running.
+func (m *Manager) save(job *Job) {
+	savedJob := *job
+	savedJob.Result = router.RedactData(job.Result)
+	data, err := json.Marshal(savedJob)
+
+	if err == nil {
+		err = os.WriteFile(m.getJobPath(job.Id), data, 0600)
+	}
+
+	if err != nil {
+		m.logger.Error("could not save job %s: %v", job.Id, err)
+	}
+}
+
+func (m *Manager) load(now time.Time) error {
+	entries, err := os.ReadDir(m.dir)
+
+	if err != nil {
+		return fmt.Errorf("could not read jobs directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(m.dir, entry.Name())
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return fmt.Errorf("could not read job: %v", err)
+		}
+
+		var job Job
+
+		if err = json.Unmarshal(data, &job); err != nil {
+			m.logger.Error("could not decode job %s: %v", path, err)
+
+			continue
+		}
+
+		if !job.IsFinished() {
+			job.Status = StatusFailed
+			job.Error = "agent was stopped before the job finished"
+			job.ErrorCode = router.ErrorCodeInternal
+			job.FinishedAt = now
+			m.save(&job)
+		}
+
+		if m.retention > 0 && now.Sub(job.FinishedAt) > m.retention {
+			os.Remove(path)
+
+			continue
+		}
+
+		m.jobs[job.Id] = &job
+	}
+
+	return nil
+}
+
+func (m *Manager) getJobPath(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
@@ -0,0 +1,319 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmitJob(t *testing.T) {
+	conf := getTestConfig(t)
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		fmt.Fprint(request.Output, "lego output")
+
+		return request.Data, nil
+	})
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+
+	job, err := manager.Submit(router.Request{Command: "certificates.issue", Data: "example.com", TokenName: "default", Async: true})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusQueued, job.Status)
+	assert.Equal(t, "certificates.issue", job.Command)
+
+	job = waitForJob(t, manager, job.Id)
+	assert.Equal(t, StatusSucceeded, job.Status)
+	assert.Equal(t, "example.com", job.Result)
+	assert.Equal(t, "lego output", job.Output)
+
+	jobs := manager.List(StatusSucceeded)
+	assert.Len(t, jobs, 1)
+	assert.Empty(t, jobs[0].Output)
+	assert.Empty(t, manager.List(StatusFailed))
+
+	_, err = manager.Get("unknown")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	conf := getTestConfig(t)
+	conf.JobWorkers = 1
+	release := make(chan struct{})
+	handled := make(chan string, 2)
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		handled <- request.Command
+		<-release
+
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+
+	runningJob, err := manager.Submit(router.Request{Command: "test.first"})
+	assert.Nil(t, err)
+	assert.Equal(t, "test.first", <-handled)
+
+	queuedJob, err := manager.Submit(router.Request{Command: "test.second"})
+	assert.Nil(t, err)
+
+	cancelledJob, err := manager.Cancel(queuedJob.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusCancelled, cancelledJob.Status)
+
+	close(release)
+	assert.Equal(t, StatusSucceeded, waitForJob(t, manager, runningJob.Id).Status)
+	assert.Nil(t, manager.Shutdown(context.Background()))
+	assert.Len(t, handled, 0)
+}
+
+func TestExpiredJobsArePruned(t *testing.T) {
+	conf := getTestConfig(t)
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+
+	var mu sync.Mutex
+	now := time.Now()
+	manager.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return now
+	}
+
+	expired, err := manager.Submit(router.Request{Command: "certificates.issue"})
+	assert.Nil(t, err)
+	waitForJob(t, manager, expired.Id)
+
+	mu.Lock()
+	now = now.Add(conf.JobRetention + time.Minute)
+	mu.Unlock()
+
+	// The running agent removes jobs finished longer than the retention ago with their files
+	job, err := manager.Submit(router.Request{Command: "certificates.issue"})
+	assert.Nil(t, err)
+	waitForJob(t, manager, job.Id)
+
+	_, err = manager.Get(expired.Id)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+	assert.NoFileExists(t, manager.getJobPath(expired.Id))
+	assert.FileExists(t, manager.getJobPath(job.Id))
+}
+
+func TestUnfinishedJobsFailOnRestart(t *testing.T) {
+	conf := getTestConfig(t)
+	dir := conf.GetPathInsideVarDir("jobs")
+	assert.Nil(t, os.MkdirAll(dir, 0700))
+
+	now := time.Now()
+	jobs := []Job{
+		{Id: "running", Status: StatusRunning, CreatedAt: now},
+		{Id: "succeeded", Status: StatusSucceeded, CreatedAt: now, FinishedAt: now},
+		{Id: "expired", Status: StatusSucceeded, CreatedAt: now, FinishedAt: now.Add(-2 * conf.JobRetention)},
+	}
+
+	for _, job := range jobs {
+		data, err := json.Marshal(job)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, job.Id+".json"), data, 0600))
+	}
+
+	manager, err := NewManager(conf, &logger.NilLogger{}, nil)
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+
+	job, err := manager.Get("running")
+	assert.Nil(t, err)
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.NotEmpty(t, job.Error)
+
+	job, err = manager.Get("succeeded")
+	assert.Nil(t, err)
+	assert.Equal(t, StatusSucceeded, job.Status)
+
+	_, err = manager.Get("expired")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+	assert.NoFileExists(t, filepath.Join(dir, "expired.json"))
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	conf := getTestConfig(t)
+	started := make(chan struct{})
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+
+		return nil, router.NewContextError(ctx)
+	})
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+
+	job, err := manager.Submit(router.Request{Command: "certificates.issue"})
+	assert.Nil(t, err)
+	<-started
+
+	job, err = manager.Cancel(job.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusRunning, job.Status)
+
+	job = waitForJob(t, manager, job.Id)
+	assert.Equal(t, StatusCancelled, job.Status)
+	assert.Equal(t, router.ErrorCodeCancelled, job.ErrorCode)
+}
+
+func TestShutdownCancelsRunningJobs(t *testing.T) {
+	conf := getTestConfig(t)
+	started := make(chan struct{})
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+
+		return nil, router.NewContextError(ctx)
+	})
+	assert.Nil(t, err)
+
+	job, err := manager.Submit(router.Request{Command: "certificates.issue"})
+	assert.Nil(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, manager.Shutdown(ctx))
+
+	job = waitForJob(t, manager, job.Id)
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.Equal(t, router.ErrorCodeShuttingDown, job.ErrorCode)
+}
+
+func TestShutdownWaitsForRollbackOfCancelledJobs(t *testing.T) {
+	conf := getTestConfig(t)
+	started := make(chan struct{})
+	rolledBack := false
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		// Rolling back the changes takes some time after the cancellation
+		time.Sleep(50 * time.Millisecond)
+		rolledBack = true
+
+		return nil, router.NewContextError(ctx)
+	})
+	assert.Nil(t, err)
+
+	_, err = manager.Submit(router.Request{Command: "certificates.issue"})
+	assert.Nil(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, manager.Shutdown(ctx))
+	assert.True(t, rolledBack)
+}
+
+type secretResult struct {
+	Secret string
+}
+
+func (r *secretResult) Redact() interface{} {
+	return &secretResult{Secret: "[redacted]"}
+}
+
+func TestJobResultSecretsAreNotPersisted(t *testing.T) {
+	conf := getTestConfig(t)
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		return &secretResult{Secret: "new-token"}, nil
+	})
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+
+	job, err := manager.Submit(router.Request{Command: "main.rotateToken"})
+	assert.Nil(t, err)
+	job = waitForJob(t, manager, job.Id)
+	assert.Equal(t, &secretResult{Secret: "new-token"}, job.Result)
+
+	data, err := os.ReadFile(filepath.Join(conf.GetPathInsideVarDir("jobs"), job.Id+".json"))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "new-token")
+}
+
+func TestJobsOfOtherTokensAreHidden(t *testing.T) {
+	conf := getTestConfig(t)
+	manager, err := NewManager(conf, &logger.NilLogger{}, func(ctx context.Context, request router.Request) (interface{}, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+
+	job, err := manager.Submit(router.Request{Command: "certificates.issue", TokenName: "panel"})
+	assert.Nil(t, err)
+	waitForJob(t, manager, job.Id)
+
+	module := NewHandler(manager)
+	owner := router.Request{TokenName: "panel", Scopes: []string{"jobs.*", "certificates.*"}}
+	other := router.Request{TokenName: "monitoring", Scopes: []string{"jobs.*"}}
+	admin := router.Request{TokenName: "default", Scopes: []string{"*"}}
+
+	for _, item := range []struct {
+		request router.Request
+		found   bool
+	}{{owner, true}, {other, false}, {admin, true}} {
+		for _, action := range []string{"status", "cancel"} {
+			request := item.request
+			request.Command = "jobs." + action
+			request.Data = job.Id
+			_, err = module.Handle(context.Background(), request)
+
+			if item.found {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrJobNotFound)
+			}
+		}
+
+		request := item.request
+		request.Command = "jobs.list"
+		request.Data = map[string]interface{}{}
+		jobs, err := module.Handle(context.Background(), request)
+		assert.Nil(t, err)
+
+		if item.found {
+			assert.Len(t, jobs, 1)
+		} else {
+			assert.Empty(t, jobs)
+		}
+	}
+}
+
+func getTestConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		VarDir:       t.TempDir(),
+		JobWorkers:   2,
+		JobQueueSize: 10,
+		JobRetention: time.Hour,
+	}
+}
+
+func waitForJob(t *testing.T, manager *Manager, id string) *Job {
+	var job *Job
+
+	assert.Eventually(t, func() bool {
+		var err error
+		job, err = manager.Get(id)
+
+		return err == nil && job.IsFinished()
+	}, time.Second, 10*time.Millisecond)
+
+	return job
+}
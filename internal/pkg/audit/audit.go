@@ -0,0 +1,267 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+const (
+	StatusOk    = "ok"
+	StatusError = "error"
+
+	// DefaultLimit is the number of entries returned by a query without a limit
+	DefaultLimit = 100
+
+	redactedValue = "[redacted]"
+)
+
+// sensitiveParamRegex matches names of request parameters whose values are not written to the audit log, e.g. PemCertificate
+var sensitiveParamRegex = regexp.MustCompile(`(?i)pem|key|token|secret|password|credential`)
+
+// Entry is a line of the audit log
+type Entry = protocol.AuditEntry
+
+// Filter selects audit log entries, empty fields match any value
+type Filter struct {
+	// Command is a command or a "<module>.*" pattern
+	Command   string
+	TokenName string
+	Vhost     string
+	CertName  string
+	Status    string
+	Since     time.Time
+	Until     time.Time
+	// Limit is the maximum number of the newest matching entries, DefaultLimit is used if it is not positive
+	Limit int
+}
+
+func (f Filter) matches(entry Entry) bool {
+	switch {
+	case f.Command != "" && !matchCommand(f.Command, entry.Command):
+		return false
+	case f.TokenName != "" && f.TokenName != entry.TokenName:
+		return false
+	case f.Vhost != "" && f.Vhost != entry.Vhost:
+		return false
+	case f.CertName != "" && f.CertName != entry.CertName:
+		return false
+	case f.Status != "" && f.Status != entry.Status:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && entry.Time.After(f.Until):
+		return false
+	default:
+		return true
+	}
+}
+
+// Log is an append-only audit log stored as JSON lines
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewLog(path string) *Log {
+	return &Log{path: path}
+}
+
+// Append writes the entry to the end of the log. The file is opened for each entry, so it can be rotated externally.
+func (l *Log) Append(entry Entry) error {
+	data, err := json.Marshal(entry)
+
+	if err != nil {
+		return fmt.Errorf("could not encode audit log entry: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %v", err)
+	}
+
+	defer file.Close()
+
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write audit log: %v", err)
+	}
+
+	return nil
+}
+
+// Query returns the newest entries matching the filter, the newest first. Malformed lines are skipped.
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	limit := filter.Limit
+
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	file, err := os.Open(l.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %v", err)
+	}
+
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var entry Entry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || !filter.matches(entry) {
+			continue
+		}
+
+		entries = append(entries, entry)
+
+		// Only the newest entries are kept
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read audit log: %v", err)
+	}
+
+	slices.Reverse(entries)
+
+	if entries == nil {
+		entries = []Entry{}
+	}
+
+	return entries, nil
+}
+
+// SanitizeParams returns a copy of the request data with values of sensitive parameters redacted
+func SanitizeParams(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+
+		for key, item := range value {
+			if sensitiveParamRegex.MatchString(key) {
+				result[key] = redactedValue
+			} else {
+				result[key] = SanitizeParams(item)
+			}
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, 0, len(value))
+
+		for _, item := range value {
+			result = append(result, SanitizeParams(item))
+		}
+
+		return result
+	default:
+		return data
+	}
+}
+
+func matchCommand(pattern, command string) bool {
+	if module, ok := strings.CutSuffix(pattern, ".*"); ok {
+		return strings.HasPrefix(command, module+".")
+	}
+
+	return pattern == "*" || pattern == command
+}
+
+type recordKey struct{}
+
+// WithRecord returns the context carrying the record of the audited command
+func WithRecord(ctx context.Context, record *Record) context.Context {
+	return context.WithValue(ctx, recordKey{}, record)
+}
+
+// GetRecord returns the record of the audited command, it is nil if the command is not audited
+func GetRecord(ctx context.Context) *Record {
+	record, _ := ctx.Value(recordKey{}).(*Record)
+
+	return record
+}
+
+// Record collects the objects affected by a command while it runs. A nil record ignores all changes,
+// so commands do not check whether they are audited.
+type Record struct {
+	mu       sync.Mutex
+	vhost    string
+	certName string
+	files    []string
+}
+
+func (r *Record) SetVhost(vhost string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.vhost = vhost
+}
+
+func (r *Record) SetCertName(certName string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.certName = certName
+}
+
+// AddFiles records changed files, a file is recorded once
+func (r *Record) AddFiles(files ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, file := range files {
+		if !slices.Contains(r.files, file) {
+			r.files = append(r.files, file)
+		}
+	}
+}
+
+// Fill copies the collected objects to the entry
+func (r *Record) Fill(entry *Entry) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.Vhost = r.vhost
+	entry.CertName = r.certName
+	entry.Files = slices.Clone(r.files)
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery(t *testing.T) {
+	log := NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	entries, err := log.Query(Filter{})
+	assert.Nil(t, err)
+	assert.Equal(t, []Entry{}, entries)
+
+	for i, entry := range []Entry{
+		{Command: "certificates.issue", TokenName: "panel", Vhost: "example.com", CertName: "example.com", Status: StatusOk},
+		{Command: "certificates.domainassign", TokenName: "panel", Vhost: "example.org", CertName: "shared", Status: StatusError},
+		{Command: "main.rotateToken", TokenName: "admin", Status: StatusOk},
+		{Command: "certificates.storagecertremove", TokenName: "panel", CertName: "shared", Status: StatusOk},
+	} {
+		entry.Time = now.Add(time.Duration(i) * time.Hour)
+		assert.Nil(t, log.Append(entry))
+	}
+
+	// Malformed lines, e.g. a line truncated by a crash, are skipped
+	file, err := os.OpenFile(log.path, os.O_WRONLY|os.O_APPEND, 0600)
+	assert.Nil(t, err)
+	_, err = file.WriteString("{\"Command\":\n")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	testCases := []struct {
+		name     string
+		filter   Filter
+		commands []string
+	}{
+		{"all", Filter{}, []string{"certificates.storagecertremove", "main.rotateToken", "certificates.domainassign", "certificates.issue"}},
+		{"limit", Filter{Limit: 2}, []string{"certificates.storagecertremove", "main.rotateToken"}},
+		{"command", Filter{Command: "main.rotateToken"}, []string{"main.rotateToken"}},
+		{"module", Filter{Command: "certificates.*"}, []string{"certificates.storagecertremove", "certificates.domainassign", "certificates.issue"}},
+		{"token", Filter{TokenName: "admin"}, []string{"main.rotateToken"}},
+		{"vhost", Filter{Vhost: "example.org"}, []string{"certificates.domainassign"}},
+		{"certificate", Filter{CertName: "shared"}, []string{"certificates.storagecertremove", "certificates.domainassign"}},
+		{"status", Filter{Status: StatusError}, []string{"certificates.domainassign"}},
+		{"time", Filter{Since: now.Add(time.Hour), Until: now.Add(2 * time.Hour)}, []string{"main.rotateToken", "certificates.domainassign"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			entries, err := log.Query(testCase.filter)
+			assert.Nil(t, err)
+
+			var commands []string
+
+			for _, entry := range entries {
+				commands = append(commands, entry.Command)
+			}
+
+			assert.Equal(t, testCase.commands, commands)
+		})
+	}
+}
+
+func TestSanitizeParams(t *testing.T) {
+	params := SanitizeParams(map[string]interface{}{
+		"ServerName":       "example.com",
+		"PemCertificate":   "-----BEGIN CERTIFICATE-----",
+		"AdditionalParams": map[string]interface{}{"CF_API_TOKEN": "secret", "CF_ZONE": "example"},
+		"Subjects":         []interface{}{"www.example.com"},
+	})
+	assert.Equal(t, map[string]interface{}{
+		"ServerName":       "example.com",
+		"PemCertificate":   redactedValue,
+		"AdditionalParams": map[string]interface{}{"CF_API_TOKEN": redactedValue, "CF_ZONE": "example"},
+		"Subjects":         []interface{}{"www.example.com"},
+	}, params)
+	assert.Equal(t, "example.com", SanitizeParams("example.com"))
+}
+
+func TestRecord(t *testing.T) {
+	var nilRecord *Record
+	nilRecord.SetVhost("example.com")
+	nilRecord.AddFiles("/etc/nginx/sites-enabled/example.com")
+
+	record := &Record{}
+	record.SetVhost("example.com")
+	record.SetCertName("example.com")
+	record.AddFiles("/etc/nginx/sites-available/example.com", "/etc/nginx/sites-enabled/example.com")
+	record.AddFiles("/etc/nginx/sites-available/example.com")
+
+	var entry Entry
+	record.Fill(&entry)
+	assert.Equal(t, Entry{
+		Vhost:    "example.com",
+		CertName: "example.com",
+		Files:    []string{"/etc/nginx/sites-available/example.com", "/etc/nginx/sites-enabled/example.com"},
+	}, entry)
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+var (
+	ErrSignatureRequired = errors.New("request signature is required")
+	ErrInvalidSignature  = errors.New("invalid request signature")
+	ErrStaleTimestamp    = errors.New("request timestamp is out of the allowed window")
+	ErrReusedNonce       = errors.New("request nonce is already used")
+	// ErrNonceCacheFull is returned while the cache is full of nonces that are not expired yet: evicting them would allow replays
+	ErrNonceCacheFull = errors.New("too many signed requests: try again later")
+)
+
+// SignedRequest contains the request fields covered by the signature
+type SignedRequest = protocol.SignedRequest
+
+// SignatureVerifier checks request signatures and rejects stale or replayed requests
+type SignatureVerifier struct {
+	MaxAge time.Duration
+	nonces *NonceCache
+}
+
+func NewSignatureVerifier(maxAge time.Duration, nonceCacheSize int) *SignatureVerifier {
+	// A nonce must be remembered as long as its timestamp is acceptable: up to MaxAge in the past or in the future
+	return &SignatureVerifier{MaxAge: maxAge, nonces: NewNonceCache(nonceCacheSize, 2*maxAge)}
+}
+
+// Verify checks the signature of the request with the hex encoded public keys of its key id. The nonce is remembered
+// only if the signature is valid.
+func (v *SignatureVerifier) Verify(publicKeys []string, request SignedRequest, signature string, now time.Time) error {
+	if signature == "" || request.Nonce == "" || request.Timestamp == 0 {
+		return ErrSignatureRequired
+	}
+
+	if !verifySignature(publicKeys, request.Message(), signature) {
+		return ErrInvalidSignature
+	}
+
+	requestTime := time.Unix(request.Timestamp, 0)
+
+	if requestTime.Before(now.Add(-v.MaxAge)) || requestTime.After(now.Add(v.MaxAge)) {
+		return ErrStaleTimestamp
+	}
+
+	return v.nonces.Add(request.Nonce, now)
+}
+
+func verifySignature(publicKeys []string, message []byte, signature string) bool {
+	signatureBytes, err := hex.DecodeString(signature)
+
+	if err != nil {
+		return false
+	}
+
+	for _, publicKey := range publicKeys {
+		keyBytes, err := hex.DecodeString(publicKey)
+
+		if err != nil || len(keyBytes) != ed25519.PublicKeySize {
+			continue
+		}
+
+		if ed25519.Verify(ed25519.PublicKey(keyBytes), message, signatureBytes) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NonceCache is a bounded in-memory set of recently used nonces. Only expired nonces are evicted,
+// new nonces are rejected while the cache is full.
+type NonceCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]time.Time
+	order   []nonceEntry
+}
+
+type nonceEntry struct {
+	nonce   string
+	addedAt time.Time
+}
+
+func NewNonceCache(size int, ttl time.Duration) *NonceCache {
+	return &NonceCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]time.Time, size),
+	}
+}
+
+// Add remembers the nonce. It returns ErrReusedNonce if the nonce is already known and ErrNonceCacheFull
+// if the nonce can not be remembered.
+func (c *NonceCache) Add(nonce string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if addedAt, ok := c.entries[nonce]; ok && now.Sub(addedAt) < c.ttl {
+		return ErrReusedNonce
+	}
+
+	for len(c.order) > 0 {
+		oldest := c.order[0]
+
+		if now.Sub(oldest.addedAt) < c.ttl {
+			break
+		}
+
+		// The nonce may be re-added later, so only its own entry is removed
+		if c.entries[oldest.nonce].Equal(oldest.addedAt) {
+			delete(c.entries, oldest.nonce)
+		}
+
+		c.order = c.order[1:]
+	}
+
+	if len(c.entries) >= c.size {
+		return ErrNonceCacheFull
+	}
+
+	c.entries[nonce] = now
+	c.order = append(c.order, nonceEntry{nonce: nonce, addedAt: now})
+
+	return nil
+}
+
+// Len returns the number of remembered nonces
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
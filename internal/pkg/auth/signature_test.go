@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	now := time.Now()
+	verifier := NewSignatureVerifier(5*time.Minute, 100)
+	publicKeys := []string{protocol.SigningPublicKey("secret")}
+	request := SignedRequest{
+		Version:   2,
+		KeyId:     DefaultIdentity,
+		Command:   "main.getVhostCertificate",
+		Timestamp: now.Unix(),
+		Nonce:     "nonce1",
+		Data:      []byte(`{"vhostName":"example.com"}`),
+	}
+	signature := protocol.SignRequest("secret", request)
+
+	assert.Nil(t, verifier.Verify(publicKeys, request, signature, now))
+	assert.ErrorIs(t, verifier.Verify(publicKeys, request, signature, now), ErrReusedNonce)
+	assert.ErrorIs(t, verifier.Verify([]string{protocol.SigningPublicKey("other")}, request, signature, now), ErrInvalidSignature)
+
+	// All fields of the request are covered by the signature
+	request.Nonce = "nonce2"
+	signature = protocol.SignRequest("secret", request)
+
+	for _, tamper := range []func(request *SignedRequest){
+		func(request *SignedRequest) { request.Version = 1 },
+		func(request *SignedRequest) { request.KeyId = "panel" },
+		func(request *SignedRequest) { request.Command = "certificates.storagecertremove" },
+		func(request *SignedRequest) { request.KeepAlive = true },
+		func(request *SignedRequest) { request.Async = true },
+		func(request *SignedRequest) { request.Events = true },
+		func(request *SignedRequest) { request.Data = []byte(`{"vhostName":"example.org"}`) },
+	} {
+		tampered := request
+		tamper(&tampered)
+		assert.ErrorIs(t, verifier.Verify(publicKeys, tampered, signature, now), ErrInvalidSignature)
+	}
+
+	request.Nonce = "nonce3"
+	request.Timestamp = now.Add(-10 * time.Minute).Unix()
+	signature = protocol.SignRequest("secret", request)
+	assert.ErrorIs(t, verifier.Verify(publicKeys, request, signature, now), ErrStaleTimestamp)
+
+	request.Nonce = ""
+	assert.ErrorIs(t, verifier.Verify(publicKeys, request, "", now), ErrSignatureRequired)
+}
+
+func TestStoredPublicKeyCanNotSign(t *testing.T) {
+	now := time.Now()
+	verifier := NewSignatureVerifier(5*time.Minute, 100)
+	publicKey := protocol.SigningPublicKey("secret")
+	request := SignedRequest{
+		Version:   2,
+		KeyId:     DefaultIdentity,
+		Command:   "main.rotateToken",
+		Timestamp: now.Unix(),
+		Nonce:     "nonce",
+	}
+
+	// Unlike an HMAC key, the key stored by the agent does not sign valid requests when used as the secret
+	signature := protocol.SignRequest(publicKey, request)
+	assert.ErrorIs(t, verifier.Verify([]string{publicKey}, request, signature, now), ErrInvalidSignature)
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Now()
+	cache := NewNonceCache(3, time.Minute)
+
+	for i := range 3 {
+		assert.Nil(t, cache.Add(strconv.Itoa(i), now))
+	}
+
+	// Nonces that are not expired yet are never evicted
+	assert.ErrorIs(t, cache.Add("3", now), ErrNonceCacheFull)
+	assert.ErrorIs(t, cache.Add("0", now), ErrReusedNonce)
+	assert.Equal(t, 3, cache.Len())
+
+	assert.Nil(t, cache.Add("0", now.Add(2*time.Minute)))
+	assert.Equal(t, 1, cache.Len())
+}
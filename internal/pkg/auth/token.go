@@ -0,0 +1,352 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+const (
+	hashAlgorithm   = "sha256"
+	saltLength      = 16
+	AllScope        = "*"
+	DefaultIdentity = config.DefaultTokenName
+)
+
+var ErrInvalidToken = errors.New("invalid request token is specified")
+
+// tokenChangeMu serializes changes of the main token, so concurrent rotations do not overwrite each other in the config file
+var tokenChangeMu sync.Mutex
+
+// ExpiredTokenError is returned for an expired named token. Its message is the same as for unknown tokens, so clients
+// can not find out token names: the name is meant for server logs only.
+type ExpiredTokenError struct {
+	Name string
+}
+
+func (e *ExpiredTokenError) Error() string {
+	return ErrInvalidToken.Error()
+}
+
+func (e *ExpiredTokenError) Is(target error) bool {
+	return target == ErrInvalidToken
+}
+
+// Identity is an authenticated client
+type Identity struct {
+	Name   string
+	Scopes []string
+}
+
+// Allows checks if the identity is allowed to execute the command in "<module>.<action>" form
+func (i *Identity) Allows(command string) bool {
+	for _, scope := range i.Scopes {
+		if MatchScope(scope, command) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchScope checks if the scope "*", "<module>.*" or "<module>.<action>" covers the command
+func MatchScope(scope, command string) bool {
+	if scope == AllScope || scope == command {
+		return true
+	}
+
+	module, found := strings.CutSuffix(scope, ".*")
+
+	return found && strings.HasPrefix(command, module+".")
+}
+
+// HashSecret returns salted hash of the token secret in "sha256$<salt>$<hash>" form
+func HashSecret(secret string) (string, error) {
+	salt := make([]byte, saltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate salt: %v", err)
+	}
+
+	return formatHash(salt, secret), nil
+}
+
+// VerifySecret checks the secret against the hash produced by HashSecret in constant time
+func VerifySecret(secret, hash string) bool {
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 3 || parts[0] != hashAlgorithm {
+		return false
+	}
+
+	salt, err := hex.DecodeString(parts[1])
+
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(formatHash(salt, secret)), []byte(hash)) == 1
+}
+
+// Authenticate finds the token matching the secret and returns its identity
+func Authenticate(conf *config.Config, secret string, now time.Time) (*Identity, error) {
+	if secret == "" {
+		return nil, ErrInvalidToken
+	}
+
+	state := conf.GetTokenState()
+
+	if state.TokenHash != "" && VerifySecret(secret, state.TokenHash) {
+		return &Identity{Name: DefaultIdentity, Scopes: []string{AllScope}}, nil
+	}
+
+	if state.PreviousTokenHash != "" && now.Before(state.PreviousTokenExpiresAt) && VerifySecret(secret, state.PreviousTokenHash) {
+		return &Identity{Name: DefaultIdentity, Scopes: []string{AllScope}}, nil
+	}
+
+	// Plaintext token is accepted only until it is migrated
+	if state.Token != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(state.Token)) == 1 {
+		return &Identity{Name: DefaultIdentity, Scopes: []string{AllScope}}, nil
+	}
+
+	for _, token := range state.Tokens {
+		if !VerifySecret(secret, token.Hash) {
+			continue
+		}
+
+		if !token.ExpiresAt.IsZero() && now.After(token.ExpiresAt) {
+			return nil, &ExpiredTokenError{Name: token.Name}
+		}
+
+		return &Identity{Name: token.Name, Scopes: token.Scopes}, nil
+	}
+
+	return nil, ErrInvalidToken
+}
+
+// FindSigningKeys returns the identity of the key id and the public keys verifying its signed requests
+func FindSigningKeys(conf *config.Config, keyId string, now time.Time) (*Identity, []string, error) {
+	state := conf.GetTokenState()
+
+	if keyId == DefaultIdentity {
+		var keys []string
+
+		if state.TokenSigningKey != "" {
+			keys = append(keys, state.TokenSigningKey)
+		} else if state.Token != "" {
+			keys = append(keys, protocol.SigningPublicKey(state.Token))
+		}
+
+		if state.PreviousTokenSigningKey != "" && now.Before(state.PreviousTokenExpiresAt) {
+			keys = append(keys, state.PreviousTokenSigningKey)
+		}
+
+		if len(keys) == 0 {
+			return nil, nil, fmt.Errorf("main token has no signing key: regenerate the token to sign requests")
+		}
+
+		return &Identity{Name: DefaultIdentity, Scopes: []string{AllScope}}, keys, nil
+	}
+
+	for _, token := range state.Tokens {
+		if token.Name != keyId {
+			continue
+		}
+
+		if !token.ExpiresAt.IsZero() && now.After(token.ExpiresAt) {
+			return nil, nil, fmt.Errorf("token '%s' is expired", token.Name)
+		}
+
+		if token.SigningKey == "" {
+			return nil, nil, fmt.Errorf("token '%s' has no signing key: regenerate the token to sign requests", token.Name)
+		}
+
+		return &Identity{Name: token.Name, Scopes: token.Scopes}, []string{token.SigningKey}, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown key id '%s'", keyId)
+}
+
+// AllowsAll reports whether the scopes allow all commands, e.g. to change the main token
+func AllowsAll(scopes []string) bool {
+	return slices.Contains(scopes, AllScope)
+}
+
+// GenerateSecret returns a new random token secret
+func GenerateSecret() (string, error) {
+	randomUuid, err := uuid.NewRandom()
+
+	if err != nil {
+		return "", err
+	}
+
+	return randomUuid.String(), nil
+}
+
+// GenerateToken replaces the main token with a new one. The current token stops working immediately.
+func GenerateToken(conf *config.Config) (string, error) {
+	tokenChangeMu.Lock()
+	defer tokenChangeMu.Unlock()
+
+	return generateToken(conf)
+}
+
+func generateToken(conf *config.Config) (string, error) {
+	secret, hash, err := generateSecretWithHash()
+
+	if err != nil {
+		return "", err
+	}
+
+	signingKey := protocol.SigningPublicKey(secret)
+	params := map[string]interface{}{
+		"token":                      nil,
+		"token_hash":                 hash,
+		"token_signing_key":          signingKey,
+		"previous_token_hash":        nil,
+		"previous_token_signing_key": nil,
+		"previous_token_expires_at":  nil,
+	}
+
+	if err = conf.Save(params); err != nil {
+		return "", err
+	}
+
+	conf.UpdateTokenState(func(state *config.TokenState) {
+		state.Token = ""
+		state.TokenHash = hash
+		state.TokenSigningKey = signingKey
+		state.PreviousTokenHash = ""
+		state.PreviousTokenSigningKey = ""
+		state.PreviousTokenExpiresAt = time.Time{}
+	})
+
+	return secret, nil
+}
+
+// RotateToken replaces the main token with a new one. The current token remains valid during the grace period.
+func RotateToken(conf *config.Config, now time.Time) (string, time.Time, error) {
+	tokenChangeMu.Lock()
+	defer tokenChangeMu.Unlock()
+
+	state := conf.GetTokenState()
+	previousHash := state.TokenHash
+	previousSigningKey := state.TokenSigningKey
+
+	if state.Token != "" {
+		hash, err := HashSecret(state.Token)
+
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		previousHash = hash
+		previousSigningKey = protocol.SigningPublicKey(state.Token)
+	}
+
+	if previousHash == "" {
+		secret, err := generateToken(conf)
+
+		return secret, time.Time{}, err
+	}
+
+	secret, hash, err := generateSecretWithHash()
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingKey := protocol.SigningPublicKey(secret)
+	expiresAt := now.Add(conf.TokenGracePeriod).UTC().Truncate(time.Second)
+	params := map[string]interface{}{
+		"token":                      nil,
+		"token_hash":                 hash,
+		"token_signing_key":          signingKey,
+		"previous_token_hash":        previousHash,
+		"previous_token_signing_key": previousSigningKey,
+		"previous_token_expires_at":  expiresAt,
+	}
+
+	if err = conf.Save(params); err != nil {
+		return "", time.Time{}, err
+	}
+
+	conf.UpdateTokenState(func(state *config.TokenState) {
+		state.Token = ""
+		state.TokenHash = hash
+		state.TokenSigningKey = signingKey
+		state.PreviousTokenHash = previousHash
+		state.PreviousTokenSigningKey = previousSigningKey
+		state.PreviousTokenExpiresAt = expiresAt
+	})
+
+	return secret, expiresAt, nil
+}
+
+// MigratePlaintextToken replaces the plaintext token in the config file with its hash
+func MigratePlaintextToken(conf *config.Config) (bool, error) {
+	tokenChangeMu.Lock()
+	defer tokenChangeMu.Unlock()
+
+	token := conf.GetTokenState().Token
+
+	if token == "" {
+		return false, nil
+	}
+
+	hash, err := HashSecret(token)
+
+	if err != nil {
+		return false, err
+	}
+
+	signingKey := protocol.SigningPublicKey(token)
+	params := map[string]interface{}{"token": nil, "token_hash": hash, "token_signing_key": signingKey}
+
+	if err = conf.Save(params); err != nil {
+		return false, fmt.Errorf("could not save token hash: %v", err)
+	}
+
+	conf.UpdateTokenState(func(state *config.TokenState) {
+		state.Token = ""
+		state.TokenHash = hash
+		state.TokenSigningKey = signingKey
+	})
+
+	return true, nil
+}
+
+func generateSecretWithHash() (string, string, error) {
+	secret, err := GenerateSecret()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := HashSecret(secret)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, hash, nil
+}
+
+func formatHash(salt []byte, secret string) string {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(secret))
+
+	return strings.Join([]string{hashAlgorithm, hex.EncodeToString(salt), hex.EncodeToString(hash.Sum(nil))}, "$")
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchScope(t *testing.T) {
+	type testData struct {
+		scope, command string
+		match          bool
+	}
+	items := []testData{
+		{"*", "certificates.issue", true},
+		{"main.getVhosts", "main.getVhosts", true},
+		{"main.getVhosts", "main.getVhostCertificate", false},
+		{"certificates.*", "certificates.storagecertificates", true},
+		{"certificates.*", "main.getVhosts", false},
+		{"cert.*", "certificates.issue", false},
+	}
+
+	for _, item := range items {
+		assert.Equalf(t, item.match, MatchScope(item.scope, item.command), "scope %s, command %s", item.scope, item.command)
+	}
+}
+
+func TestVerifySecret(t *testing.T) {
+	hash, err := HashSecret("secret")
+	assert.Nil(t, err)
+	assert.True(t, VerifySecret("secret", hash))
+	assert.False(t, VerifySecret("secret2", hash))
+	assert.False(t, VerifySecret("secret", "secret"))
+
+	otherHash, err := HashSecret("secret")
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Now()
+	monitoringHash, _ := HashSecret("monitoring")
+	expiredHash, _ := HashSecret("expired")
+	conf := &config.Config{
+		Token: "main",
+		Tokens: []config.ApiToken{
+			{Name: "monitoring", Hash: monitoringHash, Scopes: []string{"main.getVhosts"}},
+			{Name: "expired", Hash: expiredHash, Scopes: []string{"*"}, ExpiresAt: now.Add(-time.Hour)},
+		},
+	}
+
+	identity, err := Authenticate(conf, "main", now)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultIdentity, identity.Name)
+	assert.True(t, identity.Allows("certificates.issue"))
+
+	identity, err = Authenticate(conf, "monitoring", now)
+	assert.Nil(t, err)
+	assert.Equal(t, "monitoring", identity.Name)
+	assert.True(t, identity.Allows("main.getVhosts"))
+	assert.False(t, identity.Allows("certificates.issue"))
+
+	// The client gets the same error as for an unknown token, so token names are not disclosed
+	_, err = Authenticate(conf, "expired", now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, ErrInvalidToken.Error(), err.Error())
+
+	var expiredErr *ExpiredTokenError
+	assert.ErrorAs(t, err, &expiredErr)
+	assert.Equal(t, "expired", expiredErr.Name)
+
+	_, err = Authenticate(conf, "unknown", now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = Authenticate(conf, "", now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestMigratePlaintextToken(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFilePath, []byte("port: 60150\ntoken: secret\n"), 0644)
+	assert.Nil(t, err)
+
+	conf := &config.Config{Token: "secret", ConfigFilePath: configFilePath}
+	migrated, err := MigratePlaintextToken(conf)
+	assert.Nil(t, err)
+	assert.True(t, migrated)
+	assert.Empty(t, conf.Token)
+	assert.True(t, VerifySecret("secret", conf.TokenHash))
+
+	content, err := os.ReadFile(configFilePath)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), "token: secret")
+	assert.Contains(t, string(content), "token_hash: "+conf.TokenHash)
+	assert.Contains(t, string(content), "port: 60150")
+
+	identity, err := Authenticate(conf, "secret", time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultIdentity, identity.Name)
+
+	migrated, err = MigratePlaintextToken(conf)
+	assert.Nil(t, err)
+	assert.False(t, migrated)
+}
+
+func TestRotateToken(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFilePath, []byte("port: 60150\n"), 0644)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	conf := &config.Config{ConfigFilePath: configFilePath, TokenGracePeriod: time.Hour}
+	oldToken, err := GenerateToken(conf)
+	assert.Nil(t, err)
+
+	newToken, expiresAt, err := RotateToken(conf, now)
+	assert.Nil(t, err)
+	assert.NotEqual(t, oldToken, newToken)
+	assert.WithinDuration(t, now.Add(time.Hour), expiresAt, time.Second)
+
+	_, err = Authenticate(conf, newToken, now)
+	assert.Nil(t, err)
+
+	_, err = Authenticate(conf, oldToken, now.Add(time.Minute))
+	assert.Nil(t, err)
+
+	_, err = Authenticate(conf, oldToken, now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = GenerateToken(conf)
+	assert.Nil(t, err)
+
+	_, err = Authenticate(conf, newToken, now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRotateTokenWhileAuthenticating(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFilePath, []byte("port: 60150\n"), 0644)
+	assert.Nil(t, err)
+
+	conf := &config.Config{ConfigFilePath: configFilePath, TokenGracePeriod: time.Hour}
+	token, err := GenerateToken(conf)
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for range 5 {
+			_, _, err := RotateToken(conf, time.Now())
+			assert.Nil(t, err)
+		}
+	}()
+
+	// Requests are authenticated while the token is rotated, the race detector reports unguarded access
+	for i := 0; i < 5; i++ {
+		Authenticate(conf, token, time.Now())
+	}
+
+	<-done
+
+	info, err := os.Stat(configFilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(configFilePath))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1, "temporary config files are removed")
+}
@@ -1,6 +1,7 @@
 package certificate
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -14,16 +15,20 @@ import (
 	"github.com/unknwon/com"
 )
 
-// GetX509CertificateFromRequest retrieves certificate from http request to domain
-func GetX509CertificateFromRequest(domain string) ([]*x509.Certificate, error) {
-	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Minute}, "tcp", domain+":443", &tls.Config{InsecureSkipVerify: true})
+// GetX509CertificateFromRequest retrieves certificate from http request to domain. The TLS handshake is aborted when the context is done.
+func GetX509CertificateFromRequest(ctx context.Context, domain string) ([]*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: time.Minute},
+		Config:    &tls.Config{InsecureSkipVerify: true},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", domain+":443")
 
 	if err != nil {
 		return nil, err
 	}
 
 	defer conn.Close()
-	return conn.ConnectionState().PeerCertificates, nil
+	return conn.(*tls.Conn).ConnectionState().PeerCertificates, nil
 }
 
 func ConvertX509CertificateToIntCert(certificate *x509.Certificate, roots []*x509.Certificate) *agentintegration.Certificate {
@@ -59,8 +64,8 @@ func ConvertX509CertificateToIntCert(certificate *x509.Certificate, roots []*x50
 	return &cert
 }
 
-func GetCertificateForDomainFromRequest(domain string) (*agentintegration.Certificate, error) {
-	certs, err := GetX509CertificateFromRequest(domain)
+func GetCertificateForDomainFromRequest(ctx context.Context, domain string) (*agentintegration.Certificate, error) {
+	certs, err := GetX509CertificateFromRequest(ctx, domain)
 	if err != nil {
 		return nil, err
 	}
@@ -1,8 +1,11 @@
 package certificate
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"testing"
 
+	"github.com/r2dtools/sslbot/pkg/tlsutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -11,3 +14,44 @@ func TestGetCertificateFromFile(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []string{"example.com", "www.example.com"}, cert.DNSNames)
 }
+
+func TestGenerateSelfSignedCertificate(t *testing.T) {
+	certPem, keyPem, err := GenerateSelfSignedCertificate("example.com", []string{"example.com", "127.0.0.1"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, keyPem)
+
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.Nil(t, err)
+
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", x509Cert.Subject.CommonName)
+	assert.Equal(t, []string{"example.com"}, x509Cert.DNSNames)
+	assert.Len(t, x509Cert.IPAddresses, 1)
+
+	fingerprint, err := GetFingerprint(certPem)
+	assert.Nil(t, err)
+	assert.Equal(t, tlsutil.FormatFingerprint(cert.Certificate[0]), fingerprint)
+	assert.Len(t, fingerprint, 95)
+}
+
+func TestGenerateClientCertificate(t *testing.T) {
+	caCertPem, caKeyPem, err := GenerateCA("Test CA")
+	assert.Nil(t, err)
+
+	certPem, keyPem, err := GenerateClientCertificate("panel", caCertPem, caKeyPem)
+	assert.Nil(t, err)
+
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.Nil(t, err)
+
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err)
+
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(caCertPem))
+
+	_, err = x509Cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.Nil(t, err)
+	assert.Equal(t, "panel", x509Cert.Subject.CommonName)
+}
@@ -0,0 +1,189 @@
+package certificate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/r2dtools/sslbot/pkg/tlsutil"
+)
+
+const (
+	selfSignedValidity = 10 * 365 * 24 * time.Hour
+	clientCertValidity = 2 * 365 * 24 * time.Hour
+	organization       = "R2DTools SSLBot"
+)
+
+// GenerateSelfSignedCertificate creates a self-signed certificate and its private key in PEM format
+func GenerateSelfSignedCertificate(commonName string, hosts []string) (certPem []byte, keyPem []byte, err error) {
+	template, err := getCertificateTemplate(commonName, selfSignedValidity)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	return createCertificate(template, nil, nil)
+}
+
+// GenerateCA creates a self-signed CA certificate and its private key in PEM format
+func GenerateCA(commonName string) (certPem []byte, keyPem []byte, err error) {
+	template, err := getCertificateTemplate(commonName, selfSignedValidity)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	return createCertificate(template, nil, nil)
+}
+
+// GenerateClientCertificate creates a client certificate signed by the given CA
+func GenerateClientCertificate(commonName string, caCertPem, caKeyPem []byte) (certPem []byte, keyPem []byte, err error) {
+	caCert, caKey, err := parseKeyPair(caCertPem, caKeyPem)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template, err := getCertificateTemplate(commonName, clientCertValidity)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	return createCertificate(template, caCert, caKey)
+}
+
+// GetFingerprint returns SHA-256 fingerprint of the first certificate in PEM data
+func GetFingerprint(certPem []byte) (string, error) {
+	for {
+		block, rest := pem.Decode(certPem)
+
+		if block == nil {
+			break
+		}
+
+		if block.Type == "CERTIFICATE" {
+			return tlsutil.FormatFingerprint(block.Bytes), nil
+		}
+
+		certPem = rest
+	}
+
+	return "", errors.New("could not find certificate in PEM data")
+}
+
+// GetFingerprintFromFile returns SHA-256 fingerprint of the certificate stored in the file
+func GetFingerprintFromFile(path string) (string, error) {
+	certPem, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", fmt.Errorf("could not read certificate content: %v", err)
+	}
+
+	return GetFingerprint(certPem)
+}
+
+func getCertificateTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not generate serial number: %v", err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+
+	return &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{organization}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		BasicConstraintsValid: true,
+	}, nil
+}
+
+// createCertificate signs the template with the parent certificate or self-signs it if the parent is nil
+func createCertificate(template, parent *x509.Certificate, parentKey crypto.Signer) (certPem []byte, keyPem []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not generate private key: %v", err)
+	}
+
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not encode private key: %v", err)
+	}
+
+	certPem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return certPem, keyPem, nil
+}
+
+func parseKeyPair(certPem, keyPem []byte) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(certPem)
+
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, nil, errors.New("could not parse CA certificate")
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse CA certificate: %v", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPem)
+
+	if keyBlock == nil {
+		return nil, nil, errors.New("could not parse CA key")
+	}
+
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse CA key: %v", err)
+	}
+
+	return cert, key, nil
+}
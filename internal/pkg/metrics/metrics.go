@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Results of ACME issuances and rollbacks
+const (
+	ResultSuccess   = "success"
+	ResultFailure   = "failure"
+	ResultCancelled = "cancelled"
+)
+
+// Default is the registry served by the metrics listener
+var Default = NewRegistry()
+
+var factory = promauto.With(Default)
+
+var (
+	// Requests counts commands handled by the router. The code is "ok" or the error code.
+	Requests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "sslbot_requests_total",
+		Help: "Commands handled by the agent by command and result code.",
+	}, []string{"command", "code"})
+	// RequestDuration observes the execution time of commands
+	RequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sslbot_request_duration_seconds",
+		Help:    "Execution time of commands in seconds.",
+		Buckets: DefaultBuckets,
+	}, []string{"command"})
+	// AuthFailures counts requests rejected because of an invalid token or signature and TLS handshakes rejected because of the client certificate
+	AuthFailures = factory.NewCounter(prometheus.CounterOpts{
+		Name: "sslbot_auth_failures_total",
+		Help: "Requests rejected because of failed authentication.",
+	})
+	// AcmeIssuances counts certificate orders of the ACME client
+	AcmeIssuances = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "sslbot_acme_issuances_total",
+		Help: "Certificate orders of the ACME client by challenge type and result.",
+	}, []string{"challenge_type", "result"})
+	// Rollbacks counts rollbacks of the webserver configuration after failed certificate deployments
+	Rollbacks = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "sslbot_deploy_rollbacks_total",
+		Help: "Webserver configuration rollbacks after failed certificate deployments by result.",
+	}, []string{"result"})
+	// ReloadFailures counts failed webserver reloads
+	ReloadFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "sslbot_webserver_reload_failures_total",
+		Help: "Failed webserver reloads by webserver.",
+	}, []string{"webserver"})
+	// StorageCertificates is the number of certificates in the storage, it is updated on scrape
+	StorageCertificates = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "sslbot_storage_certificates",
+		Help: "Certificates in the storage.",
+	})
+	// CertificateExpiryDays is the number of days until the storage certificate expires, it is updated on scrape
+	CertificateExpiryDays = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sslbot_certificate_expiry_days",
+		Help: "Days until the storage certificate expires, negative for expired certificates.",
+	}, []string{"name"})
+)
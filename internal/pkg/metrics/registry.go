@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultBuckets are upper bounds of request duration histograms in seconds. ACME orders may take minutes.
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300}
+
+// Registry is a Prometheus registry that runs collect hooks before metrics are gathered
+type Registry struct {
+	*prometheus.Registry
+	mu    sync.Mutex
+	hooks []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{Registry: prometheus.NewRegistry()}
+}
+
+// OnCollect adds the hook called before metrics are gathered, e.g. to update gauges computed on scrape
+func (r *Registry) OnCollect(hook func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// Gather runs collect hooks and gathers all registered metrics
+func (r *Registry) Gather() ([]*dto.MetricFamily, error) {
+	r.mu.Lock()
+	hooks := slices.Clone(r.hooks)
+	r.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	return r.Registry.Gather()
+}
+
+// Handler serves metrics of the registry in the format negotiated with the scraper
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	registry := NewRegistry()
+	requests := promauto.With(registry).NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests."}, []string{"command"})
+	expiry := promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{Name: "test_expiry_days", Help: "Expiry."}, []string{"name"})
+	collected := 0
+	registry.OnCollect(func() {
+		collected++
+		expiry.Reset()
+		expiry.WithLabelValues("example.com").Set(30)
+	})
+
+	requests.WithLabelValues("main.refresh").Add(3)
+	expiry.WithLabelValues("removed.com").Set(10)
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 1, collected)
+	assert.Contains(t, recorder.Body.String(), `test_requests_total{command="main.refresh"} 3`+"\n")
+	// Gauges are updated by the hook before they are gathered
+	assert.Contains(t, recorder.Body.String(), `test_expiry_days{name="example.com"} 30`+"\n")
+	assert.NotContains(t, recorder.Body.String(), "removed.com")
+}
@@ -0,0 +1,68 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+const (
+	StageIssue    = protocol.StageIssue
+	StageAcme     = protocol.StageAcme
+	StageDeploy   = protocol.StageDeploy
+	StageReload   = protocol.StageReload
+	StageRollback = protocol.StageRollback
+)
+
+// Event describes a step of a long-running operation
+type Event = protocol.Event
+
+// Reporter receives progress events. Events reported to a nil reporter are discarded.
+type Reporter func(event Event)
+
+func (r Reporter) Report(stage, message string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+
+	r(Event{Stage: stage, Message: message, Time: time.Now()})
+}
+
+// LineWriter calls the function for each complete line written to it
+type LineWriter struct {
+	mu     sync.Mutex
+	buffer []byte
+	handle func(line string)
+}
+
+func NewLineWriter(handle func(line string)) *LineWriter {
+	return &LineWriter{handle: handle}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buffer = append(w.buffer, p...)
+
+	for {
+		index := bytes.IndexByte(w.buffer, '\n')
+
+		if index == -1 {
+			break
+		}
+
+		line := string(bytes.TrimRight(w.buffer[:index], "\r"))
+		w.buffer = w.buffer[index+1:]
+		w.handle(line)
+	}
+
+	return len(p), nil
+}
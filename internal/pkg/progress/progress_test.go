@@ -0,0 +1,32 @@
+package progress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	writer := NewLineWriter(func(line string) {
+		lines = append(lines, line)
+	})
+
+	writer.Write([]byte("first"))
+	writer.Write([]byte(" line\r\nsecond line\nthird"))
+	assert.Equal(t, []string{"first line", "second line"}, lines)
+}
+
+func TestNilReporter(t *testing.T) {
+	var reporter Reporter
+	reporter.Report(StageIssue, "discarded")
+
+	var events []Event
+	reporter = func(event Event) {
+		events = append(events, event)
+	}
+	reporter.Report(StageDeploy, "deploying %s", "example.com")
+	assert.Len(t, events, 1)
+	assert.Equal(t, StageDeploy, events[0].Stage)
+	assert.Equal(t, "deploying example.com", events[0].Message)
+}
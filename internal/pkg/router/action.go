@@ -0,0 +1,210 @@
+package router
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// StringDecoder is implemented by request data types that can also be sent as a bare string, e.g. a certificate name
+type StringDecoder interface {
+	DecodeString(value string)
+}
+
+// Action is an action of a module. Its request data is decoded into a typed struct and validated before the action is executed.
+type Action struct {
+	Name        string
+	Description string
+	// Mutating is set for actions changing certificates, webserver configuration or tokens, they are recorded in the audit log
+	Mutating bool
+	// dataType is the type of the request data, it is nil if the action does not accept data
+	dataType reflect.Type
+	handle   HandleFunc
+}
+
+// ActionInfo describes an action in the action catalogue
+type ActionInfo struct {
+	Name        string
+	Description string `json:",omitempty"`
+	// Fields of the request data, the action does not accept data if they are empty
+	Fields []FieldInfo `json:",omitempty"`
+	// StringData is set if the request data may be sent as a bare string instead of an object
+	StringData bool `json:",omitempty"`
+	Mutating   bool `json:",omitempty"`
+}
+
+type FieldInfo struct {
+	Name  string
+	Type  string
+	Rules []string `json:",omitempty"`
+}
+
+// NewAction creates the action decoding request data into T. Unknown fields are rejected and fields are validated by their validate tags.
+func NewAction[T, R any](name, description string, handle func(ctx context.Context, request Request, data T) (R, error)) Action {
+	return Action{
+		Name:        name,
+		Description: description,
+		dataType:    reflect.TypeFor[T](),
+		handle: func(ctx context.Context, request Request) (interface{}, error) {
+			data, err := decodeData[T](request.Data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return handle(ctx, request, data)
+		},
+	}
+}
+
+// NewActionWithoutData creates the action that does not accept request data. Request data is ignored if it is sent.
+func NewActionWithoutData[R any](name, description string, handle func(ctx context.Context, request Request) (R, error)) Action {
+	return Action{
+		Name:        name,
+		Description: description,
+		handle: func(ctx context.Context, request Request) (interface{}, error) {
+			return handle(ctx, request)
+		},
+	}
+}
+
+// Mutates returns the copy of the action marked as mutating
+func (a Action) Mutates() Action {
+	a.Mutating = true
+
+	return a
+}
+
+// Describe returns the catalogue entry of the action
+func (a Action) Describe() ActionInfo {
+	info := ActionInfo{Name: a.Name, Description: a.Description, Mutating: a.Mutating}
+
+	if a.dataType == nil {
+		return info
+	}
+
+	info.StringData = reflect.PointerTo(a.dataType).Implements(reflect.TypeFor[StringDecoder]())
+
+	for i := 0; i < a.dataType.NumField(); i++ {
+		field := a.dataType.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		info.Fields = append(info.Fields, FieldInfo{
+			Name:  field.Name,
+			Type:  getFieldTypeName(field.Type),
+			Rules: getFieldRules(field),
+		})
+	}
+
+	return info
+}
+
+// MutationProvider is implemented by handlers that can tell whether their actions are mutating
+type MutationProvider interface {
+	IsMutating(action string) bool
+}
+
+// ActionDescriber is implemented by handlers that can describe their actions
+type ActionDescriber interface {
+	DescribeActions() []ActionInfo
+}
+
+// Module is a handler dispatching requests to the registered actions
+type Module struct {
+	actions []Action
+	byName  map[string]Action
+}
+
+func NewModule(actions ...Action) *Module {
+	module := &Module{byName: make(map[string]Action, len(actions))}
+
+	for _, action := range actions {
+		module.actions = append(module.actions, action)
+		module.byName[action.Name] = action
+	}
+
+	return module
+}
+
+func (m *Module) Handle(ctx context.Context, request Request) (interface{}, error) {
+	action, ok := m.byName[request.GetAction()]
+
+	if !ok {
+		return nil, NewInvalidActionError(request)
+	}
+
+	return action.handle(ctx, request)
+}
+
+// GetActions returns names of the actions in the registration order
+func (m *Module) GetActions() []string {
+	names := make([]string, 0, len(m.actions))
+
+	for _, action := range m.actions {
+		names = append(names, action.Name)
+	}
+
+	return names
+}
+
+func (m *Module) IsMutating(action string) bool {
+	return m.byName[action].Mutating
+}
+
+func (m *Module) DescribeActions() []ActionInfo {
+	infos := make([]ActionInfo, 0, len(m.actions))
+
+	for _, action := range m.actions {
+		infos = append(infos, action.Describe())
+	}
+
+	return infos
+}
+
+func decodeData[T any](data interface{}) (T, error) {
+	var result T
+
+	if value, ok := data.(string); ok {
+		if decoder, ok := any(&result).(StringDecoder); ok {
+			decoder.DecodeString(value)
+
+			return result, validateData(result)
+		}
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused: true,
+		Result:      &result,
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	if err = decoder.Decode(data); err != nil {
+		return result, NewInvalidDataError("invalid request data: %v", err)
+	}
+
+	return result, validateData(result)
+}
+
+func getFieldTypeName(fieldType reflect.Type) string {
+	switch fieldType.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
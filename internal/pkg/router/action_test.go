@@ -0,0 +1,160 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testIssueRequestData struct {
+	Email      string   `validate:"required,email"`
+	ServerName string   `validate:"required,domain"`
+	Challenge  string   `validate:"oneof=http dns"`
+	Subjects   []string `validate:"domain"`
+	Assign     bool
+}
+
+type testNameRequestData struct {
+	Name string `validate:"required,filename"`
+}
+
+func (d *testNameRequestData) DecodeString(value string) {
+	d.Name = value
+}
+
+func getTestModule() *Module {
+	return NewModule(
+		NewAction("issue", "Issue a certificate", func(ctx context.Context, request Request, data testIssueRequestData) (testIssueRequestData, error) {
+			return data, nil
+		}),
+		NewAction("remove", "Remove a certificate", func(ctx context.Context, request Request, data testNameRequestData) (string, error) {
+			return data.Name, nil
+		}),
+		NewActionWithoutData("list", "", func(ctx context.Context, request Request) ([]string, error) {
+			return []string{"example.com"}, nil
+		}),
+	)
+}
+
+func TestModuleDecodesData(t *testing.T) {
+	module := getTestModule()
+
+	response, err := module.Handle(context.Background(), Request{Command: "test.issue", Data: map[string]interface{}{
+		"email":      "admin@example.com",
+		"ServerName": "example.com",
+		"Subjects":   []interface{}{"www.example.com", "*.example.com"},
+		"Assign":     true,
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, testIssueRequestData{
+		Email:      "admin@example.com",
+		ServerName: "example.com",
+		Subjects:   []string{"www.example.com", "*.example.com"},
+		Assign:     true,
+	}, response)
+
+	response, err = module.Handle(context.Background(), Request{Command: "test.remove", Data: "example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", response)
+
+	response, err = module.Handle(context.Background(), Request{Command: "test.remove", Data: map[string]interface{}{"Name": "example.org"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "example.org", response)
+
+	// Data of actions without data is ignored
+	response, err = module.Handle(context.Background(), Request{Command: "test.list", Data: "ignored"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"example.com"}, response)
+
+	_, err = module.Handle(context.Background(), Request{Command: "test.unknown"})
+	assert.Equal(t, ErrorCodeNotFound, GetError(err).Code)
+	assert.Equal(t, []string{"issue", "remove", "list"}, module.GetActions())
+}
+
+func TestModuleRejectsInvalidData(t *testing.T) {
+	module := getTestModule()
+	validData := func() map[string]interface{} {
+		return map[string]interface{}{"Email": "admin@example.com", "ServerName": "example.com"}
+	}
+	testCases := []struct {
+		name    string
+		command string
+		data    interface{}
+		field   string
+		rule    string
+	}{
+		{"unknown field", "test.issue", map[string]interface{}{"Email": "admin@example.com", "ServerName": "example.com", "Port": 443}, "", ""},
+		{"invalid type", "test.issue", map[string]interface{}{"Email": "admin@example.com", "ServerName": []int{1}}, "", ""},
+		{"string data", "test.issue", "example.com", "", ""},
+		{"missing email", "test.issue", map[string]interface{}{"ServerName": "example.com"}, "Email", RuleRequired},
+		{"invalid email", "test.issue", map[string]interface{}{"Email": "Admin <admin@example.com>", "ServerName": "example.com"}, "Email", RuleEmail},
+		{"invalid domain", "test.issue", map[string]interface{}{"Email": "admin@example.com", "ServerName": "-example.com"}, "ServerName", RuleDomain},
+		{"invalid subject", "test.issue", func() map[string]interface{} {
+			data := validData()
+			data["Subjects"] = []string{"www.example.com", "example..com"}
+
+			return data
+		}(), "Subjects", RuleDomain},
+		{"invalid choice", "test.issue", func() map[string]interface{} {
+			data := validData()
+			data["Challenge"] = "tls"
+
+			return data
+		}(), "Challenge", "oneof=http dns"},
+		{"missing name", "test.remove", "", "Name", RuleRequired},
+		{"path traversal", "test.remove", "../../etc/passwd", "Name", RuleFileName},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := module.Handle(context.Background(), Request{Command: testCase.command, Data: testCase.data})
+			routerErr := GetError(err)
+			assert.Equal(t, ErrorCodeInvalidRequest, routerErr.Code)
+
+			if testCase.field != "" {
+				assert.Equal(t, map[string]interface{}{"Field": testCase.field, "Rule": testCase.rule}, routerErr.Details)
+			}
+		})
+	}
+}
+
+func TestIsDomain(t *testing.T) {
+	for _, domain := range []string{"example.com", "*.example.com", "xn--80ak6aa92e.com", "_acme-challenge.example.com", "localhost"} {
+		assert.True(t, isDomain(domain), domain)
+	}
+
+	for _, domain := range []string{"example.com.", "exa mple.com", "example-.com", "*.*.example.com", "www.*.example.com", strings.Repeat("a", 64) + ".com"} {
+		assert.False(t, isDomain(domain), domain)
+	}
+}
+
+func TestDescribeActions(t *testing.T) {
+	r := Router{}
+	r.RegisterHandler("test", getTestModule())
+	r.RegisterHandler("legacy", &testHandler{})
+
+	infos := r.DescribeActions("test")
+	assert.Equal(t, []ActionInfo{
+		{
+			Name:        "issue",
+			Description: "Issue a certificate",
+			Fields: []FieldInfo{
+				{Name: "Email", Type: "string", Rules: []string{"required", "email"}},
+				{Name: "ServerName", Type: "string", Rules: []string{"required", "domain"}},
+				{Name: "Challenge", Type: "string", Rules: []string{"oneof=http dns"}},
+				{Name: "Subjects", Type: "array", Rules: []string{"domain"}},
+				{Name: "Assign", Type: "boolean"},
+			},
+		},
+		{
+			Name:        "remove",
+			Description: "Remove a certificate",
+			Fields:      []FieldInfo{{Name: "Name", Type: "string", Rules: []string{"required", "filename"}}},
+			StringData:  true,
+		},
+		{Name: "list"},
+	}, infos)
+	assert.Nil(t, r.DescribeActions("legacy"))
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+// Error codes sent in responses of protocol version 2
+const (
+	ErrorCodeInternal              = protocol.ErrorCodeInternal
+	ErrorCodeInvalidRequest        = protocol.ErrorCodeInvalidRequest
+	ErrorCodeNotFound              = protocol.ErrorCodeNotFound
+	ErrorCodeUnauthorized          = protocol.ErrorCodeUnauthorized
+	ErrorCodeForbidden             = protocol.ErrorCodeForbidden
+	ErrorCodeRateLimited           = protocol.ErrorCodeRateLimited
+	ErrorCodeRequestTooLarge       = protocol.ErrorCodeRequestTooLarge
+	ErrorCodeShuttingDown          = protocol.ErrorCodeShuttingDown
+	ErrorCodeTimeout               = protocol.ErrorCodeTimeout
+	ErrorCodeCancelled             = protocol.ErrorCodeCancelled
+	ErrorCodeAcmeValidationFailed  = protocol.ErrorCodeAcmeValidationFailed
+	ErrorCodeDeployFailed          = protocol.ErrorCodeDeployFailed
+	ErrorCodeWebServerReloadFailed = protocol.ErrorCodeWebServerReloadFailed
+	ErrorCodeRollbackFailed        = protocol.ErrorCodeRollbackFailed
+)
+
+// Error is an error with a machine-readable code and optional details
+type Error struct {
+	Code    string
+	Message string
+	Details interface{} `json:",omitempty"`
+	err     error
+}
+
+// NewError creates an error with the code. The format supports the %w verb to wrap an error.
+func NewError(code, format string, args ...interface{}) *Error {
+	return WrapError(code, fmt.Errorf(format, args...))
+}
+
+// WrapError assigns the code to the error keeping the error message
+func WrapError(code string, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), err: err}
+}
+
+// NewContextError converts the error of the done request context: an exceeded deadline is reported as the timeout error
+// and a cancellation, e.g. the client disconnected, as the cancelled error
+func NewContextError(ctx context.Context) *Error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return NewError(ErrorCodeTimeout, "request is not finished in time: %w", ctx.Err())
+	}
+
+	return NewError(ErrorCodeCancelled, "request is cancelled: %w", ctx.Err())
+}
+
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+// GetError converts the error to Error. The code of the outermost Error in the chain is used,
+// errors without a code get the internal_error code.
+func GetError(err error) *Error {
+	var coded interface{ ErrorCode() string }
+
+	if !errors.As(err, &coded) {
+		return &Error{Code: ErrorCodeInternal, Message: err.Error(), err: err}
+	}
+
+	result := &Error{Code: coded.ErrorCode(), Message: err.Error(), err: err}
+
+	var routerErr *Error
+
+	if errors.As(err, &routerErr) {
+		result.Details = routerErr.Details
+	}
+
+	return result
+}
+
+func NewInvalidActionError(request Request) error {
+	return NewError(ErrorCodeNotFound, "invalid action '%s' for module '%s'", request.GetAction(), request.GetModule())
+}
+
+// NewInvalidDataError is returned by handlers if the request data can not be decoded
+func NewInvalidDataError(format string, args ...interface{}) error {
+	return NewError(ErrorCodeInvalidRequest, format, args...)
+}
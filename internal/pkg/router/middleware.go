@@ -0,0 +1,95 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+)
+
+// HandleFunc executes a request
+type HandleFunc func(ctx context.Context, request Request) (interface{}, error)
+
+// Middleware wraps the execution of requests by module handlers
+type Middleware func(next HandleFunc) HandleFunc
+
+// RecoverMiddleware converts a panic of a handler into an internal error, so the client receives a response
+func RecoverMiddleware(logger logger.Logger) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, request Request) (response interface{}, err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					logger.Error("command '%s' panicked: %v\n%s", request.GetCommand(), value, debug.Stack())
+					response, err = nil, NewError(ErrorCodeInternal, "command '%s' failed unexpectedly", request.GetCommand())
+				}
+			}()
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// LoggingMiddleware logs each command with its duration and error code
+func LoggingMiddleware(logger logger.Logger) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, request Request) (interface{}, error) {
+			start := time.Now()
+			response, err := next(ctx, request)
+			duration := time.Since(start)
+
+			if err != nil {
+				logger.Warning("command '%s' by '%s' failed in %s: [%s] %v", request.GetCommand(), request.TokenName, duration, GetError(err).Code, err)
+			} else {
+				logger.Info("command '%s' by '%s' succeeded in %s", request.GetCommand(), request.TokenName, duration)
+			}
+
+			return response, err
+		}
+	}
+}
+
+// TimeoutMiddleware cancels the context of the command if it is not finished in the time returned by getTimeout.
+// Commands without a timeout are not limited. The handler is always waited for, so a command never keeps changing
+// the server after its response is sent: handlers stop and roll back their changes when their context is done.
+// A handler error caused by the cancellation is reported as the timeout error, or as the cancelled error if the context
+// is cancelled before, e.g. the client disconnected. The result of a handler that finished its work is kept.
+func TimeoutMiddleware(getTimeout func(request Request) time.Duration) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, request Request) (interface{}, error) {
+			timeout := getTimeout(request)
+
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			response, err := next(ctx, request)
+
+			if err != nil && ctx.Err() != nil {
+				return nil, getContextError(ctx, request, timeout)
+			}
+
+			return response, err
+		}
+	}
+}
+
+func getContextError(ctx context.Context, request Request, timeout time.Duration) error {
+	if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return NewError(ErrorCodeTimeout, "command '%s' is not finished in %s", request.GetCommand(), timeout)
+	}
+
+	return NewContextError(ctx)
+}
+
+// chain wraps the handler with the middlewares, the first middleware is the outermost one
+func chain(handle HandleFunc, middlewares []Middleware) HandleFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handle = middlewares[i](handle)
+	}
+
+	return handle
+}
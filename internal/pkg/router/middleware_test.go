@@ -0,0 +1,138 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type testHandler struct{}
+
+func (h *testHandler) Handle(ctx context.Context, request Request) (interface{}, error) {
+	switch request.GetAction() {
+	case "echo":
+		return request.Data, nil
+	case "panic":
+		panic("handler failure")
+	case "sleep":
+		select {
+		case <-time.After(200 * time.Millisecond):
+			return "slept", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	default:
+		return nil, NewInvalidActionError(request)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	getMiddleware := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx context.Context, request Request) (interface{}, error) {
+				calls = append(calls, name)
+
+				return next(ctx, request)
+			}
+		}
+	}
+
+	r := Router{}
+	r.RegisterHandler("test", &testHandler{})
+	r.Use(getMiddleware("first"), getMiddleware("second"))
+
+	response, err := r.HandleRequest(context.Background(), Request{Command: "test.echo", Data: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", response)
+	assert.Equal(t, []string{"first", "second"}, calls)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	r := Router{}
+	r.RegisterHandler("test", &testHandler{})
+	r.Use(RecoverMiddleware(&logger.NilLogger{}))
+
+	_, err := r.HandleRequest(context.Background(), Request{Command: "test.panic"})
+	assert.Equal(t, ErrorCodeInternal, GetError(err).Code)
+	assert.Equal(t, "command 'test.panic' failed unexpectedly", err.Error())
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	r := Router{}
+	r.RegisterHandler("test", &testHandler{})
+	r.Use(
+		RecoverMiddleware(&logger.NilLogger{}),
+		TimeoutMiddleware(func(request Request) time.Duration {
+			if request.GetAction() == "echo" {
+				return 0
+			}
+
+			return 50 * time.Millisecond
+		}),
+	)
+
+	_, err := r.HandleRequest(context.Background(), Request{Command: "test.sleep"})
+	var routerErr *Error
+	assert.True(t, errors.As(err, &routerErr))
+	assert.Equal(t, ErrorCodeTimeout, routerErr.Code)
+
+	response, err := r.HandleRequest(context.Background(), Request{Command: "test.echo", Data: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", response)
+
+	// A panic in the handler is recovered by the outer middleware
+	_, err = r.HandleRequest(context.Background(), Request{Command: "test.panic"})
+	assert.Equal(t, ErrorCodeInternal, GetError(err).Code)
+}
+
+func TestTimeoutMiddlewareWaitsForHandler(t *testing.T) {
+	finished := false
+	r := Router{}
+	r.RegisterHandler("test", NewModule(NewActionWithoutData("deploy", "", func(ctx context.Context, request Request) (interface{}, error) {
+		// The handler ignores its context, e.g. it is in the middle of a change that can not be interrupted
+		time.Sleep(100 * time.Millisecond)
+		finished = true
+
+		return "deployed", nil
+	})))
+	r.Use(TimeoutMiddleware(func(request Request) time.Duration {
+		return 20 * time.Millisecond
+	}))
+
+	// The change is finished when the middleware returns, so its successful result is reported
+	response, err := r.HandleRequest(context.Background(), Request{Command: "test.deploy"})
+	assert.True(t, finished)
+	assert.Nil(t, err)
+	assert.Equal(t, "deployed", response)
+}
+
+func TestTimeoutMiddlewareCancelsContext(t *testing.T) {
+	handlerErr := make(chan error, 1)
+	r := Router{}
+	r.RegisterHandler("test", NewModule(NewActionWithoutData("wait", "", func(ctx context.Context, request Request) (interface{}, error) {
+		<-ctx.Done()
+		handlerErr <- ctx.Err()
+
+		return nil, ctx.Err()
+	})))
+	r.Use(TimeoutMiddleware(func(request Request) time.Duration {
+		return time.Duration(request.Data.(float64)) * time.Millisecond
+	}))
+
+	_, err := r.HandleRequest(context.Background(), Request{Command: "test.wait", Data: float64(50)})
+	assert.Equal(t, ErrorCodeTimeout, GetError(err).Code)
+	assert.ErrorIs(t, <-handlerErr, context.DeadlineExceeded)
+
+	// A command without a timeout is cancelled with the request context, e.g. when the client disconnects
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, err = r.HandleRequest(ctx, Request{Command: "test.wait", Data: float64(0)})
+	assert.Equal(t, ErrorCodeCancelled, GetError(err).Code)
+	assert.ErrorIs(t, <-handlerErr, context.Canceled)
+}
@@ -1,11 +1,44 @@
 package router
 
-import "strings"
+import (
+	"io"
+	"strings"
+
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
+)
 
 type Request struct {
+	// Version is the latest protocol version supported by the client. Version 1 is used if it is not set.
+	Version int
+	// Id is echoed in the response to match it with the request on a persistent connection
+	Id string
 	Command,
 	Token string
-	Data interface{}
+	// KeepAlive keeps the connection open after the request to send further requests through it
+	KeepAlive bool
+	// Async runs the command as a background job: the response contains the job instead of the command result
+	Async bool
+	// Events requests progress event frames to be sent before the response
+	Events bool
+	Data   interface{}
+	// KeyId, Timestamp, Nonce and Signature are set by clients signing requests with auth.SignRequest. KeyId is the name
+	// of the token whose key signs the request, "default" for the main token. Signed requests do not contain the token.
+	KeyId     string
+	Timestamp int64
+	Nonce,
+	Signature string
+	// RemoteAddress is the address of the client, "unix:<credentials>" for clients of the local socket
+	RemoteAddress string `json:"-"`
+	// ClientSubject is the subject of the verified TLS client certificate if the client presented one
+	ClientSubject string `json:"-"`
+	// TokenName is the name of the token the request is authenticated with
+	TokenName string `json:"-"`
+	// Scopes are the commands the authenticated identity is allowed to execute
+	Scopes []string `json:"-"`
+	// Output receives the output of external programs run by the command, e.g. the ACME client, if it is not nil
+	Output io.Writer `json:"-"`
+	// Progress receives progress events of the command. It is nil if the client did not request events.
+	Progress progress.Reporter `json:"-"`
 }
 
 func (r *Request) GetModule() string {
@@ -27,3 +60,8 @@ func (r *Request) GetAction() string {
 
 	return r.Command
 }
+
+// GetCommand returns the command in the "<module>.<action>" form
+func (r *Request) GetCommand() string {
+	return r.GetModule() + "." + r.GetAction()
+}
@@ -1,7 +1,102 @@
 package router
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
 type Response struct {
+	// Version is the protocol version of the response. Version 1 responses contain an error message only,
+	// version 2 responses contain an error object with the code and details.
+	Version int
+	Id      string
+	Status,
+	Error string
+	ErrorCode    string
+	ErrorDetails interface{}
+	Data         interface{}
+}
+
+// Redactor is implemented by response data containing secrets, e.g. tokens. The redacted copy is logged instead of the data.
+type Redactor interface {
+	Redact() interface{}
+}
+
+// RedactData returns the redacted copy of the data if it contains secrets and the data itself otherwise
+func RedactData(data interface{}) interface{} {
+	if redactor, ok := data.(Redactor); ok {
+		return redactor.Redact()
+	}
+
+	return data
+}
+
+type responseV1 struct {
+	Id string `json:",omitempty"`
 	Status,
 	Error string
 	Data interface{}
 }
+
+type responseV2 struct {
+	Version int
+	Id      string `json:",omitempty"`
+	Status  string
+	Error   *Error      `json:",omitempty"`
+	Data    interface{} `json:",omitempty"`
+}
+
+// NegotiateVersion returns the protocol version of the response to a request of the requested version
+func NegotiateVersion(requestedVersion int) int {
+	return max(1, min(requestedVersion, ProtocolVersion))
+}
+
+func (r Response) MarshalJSON() ([]byte, error) {
+	if r.Version < 2 {
+		return json.Marshal(responseV1{Id: r.Id, Status: r.Status, Error: r.Error, Data: r.Data})
+	}
+
+	response := responseV2{Version: r.Version, Id: r.Id, Status: r.Status, Data: r.Data}
+
+	if r.Error != "" {
+		response.Error = &Error{Code: r.ErrorCode, Message: r.Error, Details: r.ErrorDetails}
+	}
+
+	return json.Marshal(response)
+}
+
+func (r *Response) UnmarshalJSON(data []byte) error {
+	var response struct {
+		Version int
+		Id      string
+		Status  string
+		Error   json.RawMessage
+		Data    interface{}
+	}
+
+	if err := json.Unmarshal(data, &response); err != nil {
+		return err
+	}
+
+	*r = Response{Version: max(response.Version, 1), Id: response.Id, Status: response.Status, Data: response.Data}
+
+	if len(response.Error) == 0 || bytes.Equal(response.Error, []byte("null")) {
+		return nil
+	}
+
+	if response.Error[0] != '{' {
+		return json.Unmarshal(response.Error, &r.Error)
+	}
+
+	var responseErr Error
+
+	if err := json.Unmarshal(response.Error, &responseErr); err != nil {
+		return err
+	}
+
+	r.Error = responseErr.Message
+	r.ErrorCode = responseErr.Code
+	r.ErrorDetails = responseErr.Details
+
+	return nil
+}
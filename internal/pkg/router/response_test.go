@@ -0,0 +1,52 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseVersions(t *testing.T) {
+	response := Response{Id: "1", Status: "error", Error: "job not found", ErrorCode: ErrorCodeNotFound}
+
+	data, err := json.Marshal(response)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"Id":"1","Status":"error","Error":"job not found","Data":null}`, string(data))
+
+	response.Version = 2
+	data, err = json.Marshal(response)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"Version":2,"Id":"1","Status":"error","Error":{"Code":"not_found","Message":"job not found"}}`, string(data))
+
+	var decoded Response
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, response, decoded)
+
+	assert.Nil(t, json.Unmarshal([]byte(`{"Status":"error","Error":"failed"}`), &decoded))
+	assert.Equal(t, Response{Version: 1, Status: "error", Error: "failed"}, decoded)
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	assert.Equal(t, 1, NegotiateVersion(0))
+	assert.Equal(t, 1, NegotiateVersion(1))
+	assert.Equal(t, 2, NegotiateVersion(2))
+	assert.Equal(t, ProtocolVersion, NegotiateVersion(ProtocolVersion+1))
+}
+
+func TestGetError(t *testing.T) {
+	err := fmt.Errorf("could not deploy: %w", NewError(ErrorCodeWebServerReloadFailed, "nginx is down").WithDetails("details"))
+	responseErr := GetError(err)
+	assert.Equal(t, ErrorCodeWebServerReloadFailed, responseErr.Code)
+	assert.Equal(t, "could not deploy: nginx is down", responseErr.Message)
+	assert.Equal(t, "details", responseErr.Details)
+
+	assert.Equal(t, ErrorCodeInternal, GetError(errors.New("failed")).Code)
+
+	r := &Router{}
+	_, err = r.HandleRequest(context.Background(), Request{Command: "unknown.action"})
+	assert.Equal(t, ErrorCodeNotFound, GetError(err).Code)
+}
@@ -1,13 +1,34 @@
 package router
 
-import "fmt"
+import (
+	"context"
+	"slices"
+	"sort"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+// ProtocolVersion is the version of the request and response format supported by the agent
+const ProtocolVersion = protocol.ProtocolVersion
 
 type HandlerInterface interface {
-	Handle(request Request) (interface{}, error)
+	// Handle executes the request. The context is cancelled if the client disconnects or the command deadline is exceeded.
+	Handle(ctx context.Context, request Request) (interface{}, error)
+}
+
+// ActionProvider is implemented by handlers that can list the actions they support
+type ActionProvider interface {
+	GetActions() []string
 }
 
 type Router struct {
-	handlers map[string]HandlerInterface
+	handlers    map[string]HandlerInterface
+	middlewares []Middleware
+}
+
+// Use appends middlewares to the chain wrapping every handler call. Middlewares are called in the order they are added.
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
 }
 
 func (r *Router) RegisterHandler(module string, handler HandlerInterface) {
@@ -26,12 +47,73 @@ func (r *Router) GetHandler(request Request) HandlerInterface {
 	return r.handlers[request.GetModule()]
 }
 
-func (r *Router) HandleRequest(request Request) (interface{}, error) {
+// GetModules returns names of registered modules in alphabetical order
+func (r *Router) GetModules() []string {
+	modules := make([]string, 0, len(r.handlers))
+
+	for module := range r.handlers {
+		modules = append(modules, module)
+	}
+
+	sort.Strings(modules)
+
+	return modules
+}
+
+// GetActions returns actions of the module if its handler can list them
+func (r *Router) GetActions(module string) []string {
+	provider, ok := r.handlers[module].(ActionProvider)
+
+	if !ok {
+		return nil
+	}
+
+	return provider.GetActions()
+}
+
+// DescribeActions returns the catalogue of the module actions. Handlers that can not describe their actions are
+// described by action names only.
+func (r *Router) DescribeActions(module string) []ActionInfo {
+	if describer, ok := r.handlers[module].(ActionDescriber); ok {
+		return describer.DescribeActions()
+	}
+
+	var infos []ActionInfo
+
+	for _, action := range r.GetActions(module) {
+		infos = append(infos, ActionInfo{Name: action})
+	}
+
+	return infos
+}
+
+// IsMutating reports whether the command changes the server state. Commands of handlers that do not declare it are not mutating.
+func (r *Router) IsMutating(request Request) bool {
+	provider, ok := r.handlers[request.GetModule()].(MutationProvider)
+
+	return ok && provider.IsMutating(request.GetAction())
+}
+
+// HasAction reports whether the module of the command is registered and has the action. All actions of handlers that
+// can not list them are considered existing.
+func (r *Router) HasAction(request Request) bool {
+	handler, ok := r.handlers[request.GetModule()]
+
+	if !ok {
+		return false
+	}
+
+	provider, ok := handler.(ActionProvider)
+
+	return !ok || slices.Contains(provider.GetActions(), request.GetAction())
+}
+
+func (r *Router) HandleRequest(ctx context.Context, request Request) (interface{}, error) {
 	handler := r.GetHandler(request)
 
 	if handler == nil {
-		return nil, fmt.Errorf("could not find handler for the command '%s'", request.Command)
+		return nil, NewError(ErrorCodeNotFound, "could not find handler for the command '%s'", request.Command)
 	}
 
-	return handler.Handle(request)
+	return chain(handler.Handle, r.middlewares)(ctx, request)
 }
@@ -0,0 +1,146 @@
+package router
+
+import (
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Validation rules of the validate tag. Rules are separated by commas, e.g. `validate:"required,domain"`.
+// Rules except required skip empty values, rules of string slices are applied to each element.
+const (
+	// RuleRequired rejects zero values
+	RuleRequired = "required"
+	// RuleDomain accepts domain names including wildcard ones
+	RuleDomain = "domain"
+	RuleEmail  = "email"
+	// RuleFileName accepts names that can be used as file names without leaving a directory
+	RuleFileName = "filename"
+	// RuleOneOf accepts the listed values separated by spaces, e.g. "oneof=http dns"
+	RuleOneOf = "oneof"
+)
+
+var domainLabelRegex = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?$`)
+
+// validateData checks fields of the request data struct against the rules of their validate tags
+func validateData(data interface{}) error {
+	value := reflect.ValueOf(data)
+
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		for _, rule := range getFieldRules(field) {
+			if err := validateField(field.Name, value.Field(i), rule); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateField(name string, value reflect.Value, rule string) error {
+	if rule == RuleRequired {
+		if value.IsZero() || (value.Kind() == reflect.Slice && value.Len() == 0) {
+			return newValidationError(name, rule, "field '%s' is required", name)
+		}
+
+		return nil
+	}
+
+	var values []string
+
+	switch {
+	case value.Kind() == reflect.String:
+		values = []string{value.String()}
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String:
+		for i := 0; i < value.Len(); i++ {
+			values = append(values, value.Index(i).String())
+		}
+	}
+
+	for _, item := range values {
+		if item != "" && !checkRule(rule, item) {
+			return newValidationError(name, rule, "field '%s' has invalid value '%s': %s", name, item, getRuleDescription(rule))
+		}
+	}
+
+	return nil
+}
+
+func checkRule(rule, value string) bool {
+	ruleName, argument, _ := strings.Cut(rule, "=")
+
+	switch ruleName {
+	case RuleDomain:
+		return isDomain(value)
+	case RuleEmail:
+		address, err := mail.ParseAddress(value)
+
+		return err == nil && address.Address == value
+	case RuleFileName:
+		return value != "." && value != ".." && !strings.ContainsAny(value, `/\`)
+	case RuleOneOf:
+		for _, allowed := range strings.Fields(argument) {
+			if value == allowed {
+				return true
+			}
+		}
+
+		return false
+	default:
+		return true
+	}
+}
+
+func getRuleDescription(rule string) string {
+	ruleName, argument, _ := strings.Cut(rule, "=")
+
+	switch ruleName {
+	case RuleDomain:
+		return "domain name is expected"
+	case RuleEmail:
+		return "email address is expected"
+	case RuleFileName:
+		return "name must not contain path separators"
+	case RuleOneOf:
+		return "one of " + strings.Join(strings.Fields(argument), ", ") + " is expected"
+	default:
+		return rule
+	}
+}
+
+func isDomain(value string) bool {
+	value = strings.TrimPrefix(value, "*.")
+
+	if len(value) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(value, ".") {
+		if !domainLabelRegex.MatchString(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getFieldRules(field reflect.StructField) []string {
+	tag := field.Tag.Get("validate")
+
+	if tag == "" {
+		return nil
+	}
+
+	return strings.Split(tag, ",")
+}
+
+func newValidationError(field, rule, format string, args ...interface{}) error {
+	return NewError(ErrorCodeInvalidRequest, format, args...).WithDetails(map[string]interface{}{"Field": field, "Rule": rule})
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+)
+
+// RunWithOutput runs the command and returns its combined output. The output is also copied to the writer as it is produced if the writer is not nil.
+func RunWithOutput(cmd *exec.Cmd, writer io.Writer) ([]byte, error) {
+	if writer == nil {
+		return cmd.CombinedOutput()
+	}
+
+	var output bytes.Buffer
+	combinedWriter := io.MultiWriter(&output, writer)
+	cmd.Stdout = combinedWriter
+	cmd.Stderr = combinedWriter
+	err := cmd.Run()
+
+	return output.Bytes(), err
+}
@@ -1,7 +1,9 @@
 package webserver
 
 import (
+	"context"
 	"fmt"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -14,6 +16,7 @@ import (
 
 const (
 	defaultNginxRoot      = "/etc/nginx"
+	defaultNginxBin       = "nginx"
 	NginxCertKeyDirective = "ssl_certificate_key"
 	NginxCertDirective    = "ssl_certificate"
 )
@@ -110,6 +113,35 @@ func GetNginxWebServer(options map[string]string) (*NginxWebServer, error) {
 	}, nil
 }
 
+// GetNginxVersion returns the version reported by "nginx -v", e.g. "1.24.0"
+func GetNginxVersion(ctx context.Context, options map[string]string) (string, error) {
+	bin, ok := options["nginx_bin"]
+
+	if !ok || bin == "" {
+		bin = defaultNginxBin
+	}
+
+	output, err := exec.CommandContext(ctx, bin, "-v").CombinedOutput()
+
+	if err != nil {
+		return "", fmt.Errorf("could not detect nginx version: %v", err)
+	}
+
+	return parseNginxVersion(string(output))
+}
+
+func parseNginxVersion(output string) (string, error) {
+	// nginx version: nginx/1.24.0 (Ubuntu)
+	_, version, _ := strings.Cut(output, "nginx/")
+	fields := strings.Fields(version)
+
+	if len(fields) == 0 {
+		return "", fmt.Errorf("could not parse nginx version: %s", strings.TrimSpace(output))
+	}
+
+	return fields[0], nil
+}
+
 func getNginxRoot(options map[string]string) string {
 	root, ok := options["nginx_root"]
 
@@ -13,6 +13,15 @@ func TestNginxGetVHosts(t *testing.T) {
 	assert.Len(t, hosts, 7)
 }
 
+func TestParseNginxVersion(t *testing.T) {
+	version, err := parseNginxVersion("nginx version: nginx/1.24.0 (Ubuntu)\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.24.0", version)
+
+	_, err = parseNginxVersion("command not found")
+	assert.NotNil(t, err)
+}
+
 func TestNginxGetVHost(t *testing.T) {
 	nginxWebServer := getNginxWebServer(t)
 	host, err := nginxWebServer.GetVhostByName("example2.com")
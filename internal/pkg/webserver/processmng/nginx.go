@@ -1,9 +1,11 @@
 package processmng
 
 import (
+	"context"
 	"fmt"
 	"syscall"
 
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
 	"github.com/shirou/gopsutil/process"
 )
 
@@ -11,10 +13,16 @@ type NginxProcessManager struct {
 	proc *process.Process
 }
 
-func (m *NginxProcessManager) Reload() error {
+func (m *NginxProcessManager) Reload(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("nginx reload is cancelled: %w", err)
+	}
+
 	err := m.proc.SendSignal(syscall.SIGHUP)
 
 	if err != nil {
+		metrics.ReloadFailures.WithLabelValues("nginx").Inc()
+
 		return fmt.Errorf("failed to reload nginx: %v", err)
 	}
 
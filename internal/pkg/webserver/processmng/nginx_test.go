@@ -1,6 +1,7 @@
 package processmng
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,6 +11,6 @@ func TestNginxReload(t *testing.T) {
 	nginxProcessManager, err := GetNginxProcessManager()
 	assert.Nil(t, err)
 
-	err = nginxProcessManager.Reload()
+	err = nginxProcessManager.Reload(context.Background())
 	assert.Nil(t, err)
 }
@@ -1,11 +1,15 @@
 package reverter
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"slices"
+	"sync"
 
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
 	"github.com/unknwon/com"
 )
 
@@ -22,17 +26,36 @@ type hostManager interface {
 	Disable(configFilePath string) error
 }
 
+// active contains reverters with uncommitted changes to roll them back on shutdown
+var active = struct {
+	sync.Mutex
+	reverters map[*Reverter]struct{}
+	// rolledBack is set once RollbackActive is called: changes released after it are rolled back immediately
+	rolledBack bool
+}{reverters: make(map[*Reverter]struct{})}
+
 // Reverter reverts change back for configuration files of virtual hosts
 type Reverter struct {
+	mu               sync.Mutex
 	configsToDelete  []string
 	configsToRestore map[string]string
 	configsToDisable []string
-	HostMng          hostManager
-	Logger           logger.Logger
+	// released is set once the owner no longer uses the reverter, so RollbackActive may roll back its changes.
+	// It is guarded by the lock of active.
+	released bool
+	HostMng  hostManager
+	Logger   logger.Logger
+	Progress progress.Reporter
+	// Audit records files changed by committed changes if it is not nil
+	Audit *audit.Record
 }
 
 func (r *Reverter) AddConfigToDeletion(filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.configsToDelete = append(r.configsToDelete, filePath)
+	r.track()
 }
 
 func (r *Reverter) BackupConfigs(filePaths []string) error {
@@ -46,6 +69,9 @@ func (r *Reverter) BackupConfigs(filePaths []string) error {
 }
 
 func (r *Reverter) BackupConfig(filePath string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	bFilePath := r.getBackupConfigPath(filePath)
 
 	if _, ok := r.configsToRestore[filePath]; ok {
@@ -76,15 +102,36 @@ func (r *Reverter) BackupConfig(filePath string) error {
 	}
 
 	r.configsToRestore[filePath] = bFilePath
+	r.track()
 
 	return nil
 }
 
 func (r *Reverter) AddConfigToDisable(filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.configsToDisable = append(r.configsToDisable, filePath)
+	r.track()
 }
 
 func (r *Reverter) Rollback() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.untrack()
+
+	if err := r.rollback(); err != nil {
+		r.Progress.Report(progress.StageRollback, "%v", err)
+
+		return err
+	}
+
+	r.Progress.Report(progress.StageRollback, "rollback performed")
+
+	return nil
+}
+
+func (r *Reverter) rollback() error {
 	// Disable all enabled before sites
 	for _, configToDisable := range r.configsToDisable {
 		if err := r.HostMng.Disable(configToDisable); err != nil {
@@ -92,6 +139,8 @@ func (r *Reverter) Rollback() error {
 		}
 	}
 
+	r.configsToDisable = nil
+
 	// remove created files
 	for _, fileToDelete := range r.configsToDelete {
 		_, err := os.Stat(fileToDelete)
@@ -112,6 +161,8 @@ func (r *Reverter) Rollback() error {
 		}
 	}
 
+	r.configsToDelete = nil
+
 	if r.configsToRestore == nil {
 		return nil
 	}
@@ -141,7 +192,16 @@ func (r *Reverter) Rollback() error {
 }
 
 func (r *Reverter) Commit() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.untrack()
+
+	r.Audit.AddFiles(r.configsToDelete...)
+	r.Audit.AddFiles(r.configsToDisable...)
+
 	for filePath, bFilePath := range r.configsToRestore {
+		r.Audit.AddFiles(filePath)
+
 		if com.IsFile(bFilePath) {
 			if err := os.Remove(bFilePath); err != nil {
 				r.Logger.Error(fmt.Sprintf("could not remove file '%s' on reverter commit: %v", bFilePath, err))
@@ -152,10 +212,68 @@ func (r *Reverter) Commit() error {
 	}
 
 	r.configsToDelete = nil
+	r.configsToDisable = nil
 
 	return nil
 }
 
+// Release hands uncommitted changes over to RollbackActive. The owner calls it when it no longer uses the reverter,
+// usually deferred right after creating it, so the changes are never rolled back while the owner is still making them.
+func (r *Reverter) Release() {
+	active.Lock()
+	_, tracked := active.reverters[r]
+	r.released = true
+	rolledBack := active.rolledBack
+	active.Unlock()
+
+	// The changes were left after the shutdown rollback, e.g. by a handler that did not stop in time
+	if tracked && rolledBack {
+		if err := r.Rollback(); err != nil {
+			r.Logger.Error(fmt.Sprintf("could not roll back released changes: %v", err))
+		}
+	}
+}
+
+func (r *Reverter) track() {
+	active.Lock()
+	defer active.Unlock()
+
+	active.reverters[r] = struct{}{}
+}
+
+func (r *Reverter) untrack() {
+	active.Lock()
+	defer active.Unlock()
+
+	delete(active.reverters, r)
+}
+
+// RollbackActive rolls back uncommitted changes of released reverters and returns their number. Changes of reverters
+// still used by their owners are rolled back as soon as the owners release them.
+func RollbackActive() (int, error) {
+	active.Lock()
+	active.rolledBack = true
+	reverters := make([]*Reverter, 0, len(active.reverters))
+
+	for r := range active.reverters {
+		if r.released {
+			reverters = append(reverters, r)
+		}
+	}
+
+	active.Unlock()
+
+	var errs []error
+
+	for _, r := range reverters {
+		if err := r.Rollback(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return len(reverters), errors.Join(errs...)
+}
+
 func (r *Reverter) getBackupConfigPath(filePath string) string {
 	return filePath + ".back"
 }
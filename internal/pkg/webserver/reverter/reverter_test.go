@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/unknwon/com"
@@ -40,6 +41,7 @@ func TestReverterRollback(t *testing.T) {
 
 func TestReverterCommit(t *testing.T) {
 	reverter := getReverter()
+	reverter.Audit = &audit.Record{}
 	fileToBackup := "/tmp/fileToRemove"
 	createFile(t, fileToBackup)
 	err := reverter.BackupConfigs([]string{fileToBackup})
@@ -50,6 +52,10 @@ func TestReverterCommit(t *testing.T) {
 	assert.Nilf(t, err, "revert error: %v", err)
 	assert.Equalf(t, false, com.IsExist(bFileToBackup), "backed up file '%s' steel exists", bFileToBackup)
 	assert.Equalf(t, true, com.IsExist(fileToBackup), "file '%s' does not exist", fileToBackup)
+
+	var entry audit.Entry
+	reverter.Audit.Fill(&entry)
+	assert.Equal(t, []string{fileToBackup}, entry.Files)
 }
 
 func getReverter() *Reverter {
@@ -63,3 +69,77 @@ func createFile(t *testing.T, path string) {
 	assert.Nilf(t, err, "could not create tmp file: %v", err)
 	assert.Equal(t, true, com.IsExist(path), "create file does not exist")
 }
+
+func TestRollbackActive(t *testing.T) {
+	committedReverter := getReverter()
+	activeReverter := getReverter()
+	fileToBackup := "/tmp/fileToRollbackOnShutdown"
+	createFile(t, fileToBackup)
+	fileToCommit := "/tmp/fileToCommitBeforeShutdown"
+	createFile(t, fileToCommit)
+
+	err := committedReverter.BackupConfig(fileToCommit)
+	assert.Nilf(t, err, "could not backup file: %v", err)
+	err = committedReverter.Commit()
+	assert.Nilf(t, err, "commit error: %v", err)
+
+	err = activeReverter.BackupConfig(fileToBackup)
+	assert.Nilf(t, err, "could not backup file: %v", err)
+	err = os.WriteFile(fileToBackup, []byte("changed"), 0644)
+	assert.Nil(t, err)
+
+	releasedReverter := getReverter()
+	releasedFile := "/tmp/fileToRollbackAfterRelease"
+	createFile(t, releasedFile)
+	releasedReverter.AddConfigToDeletion(releasedFile)
+	releasedReverter.Release()
+
+	// Changes of the reverter that is still used by its owner are not touched
+	rolledBack, err := RollbackActive()
+	assert.Nilf(t, err, "rollback error: %v", err)
+	assert.Equal(t, 1, rolledBack)
+	assert.False(t, com.IsExist(releasedFile))
+
+	content, err := os.ReadFile(fileToBackup)
+	assert.Nil(t, err)
+	assert.Equal(t, "changed", string(content))
+
+	// The owner releases its changes after the shutdown rollback, so they are rolled back immediately
+	activeReverter.Release()
+
+	content, err = os.ReadFile(fileToBackup)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "changed", string(content))
+
+	rolledBack, err = RollbackActive()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, rolledBack)
+}
+
+type countingHostManager struct {
+	disabled int
+}
+
+func (m *countingHostManager) Enable(configFilePath, originSslConfigFilePath string) error {
+	return nil
+}
+
+func (m *countingHostManager) Disable(configFilePath string) error {
+	m.disabled++
+
+	return nil
+}
+
+func TestReverterRollbackResetsChanges(t *testing.T) {
+	hostManager := &countingHostManager{}
+	reverter := &Reverter{Logger: &logger.NilLogger{}, HostMng: hostManager}
+	reverter.AddConfigToDisable("/tmp/configToDisable")
+	assert.Nil(t, reverter.Rollback())
+	assert.Nil(t, reverter.Rollback())
+	assert.Equal(t, 1, hostManager.disabled)
+
+	reverter.AddConfigToDisable("/tmp/configToDisable")
+	assert.Nil(t, reverter.Commit())
+	assert.Nil(t, reverter.Rollback())
+	assert.Equal(t, 1, hostManager.disabled)
+}
@@ -1,6 +1,7 @@
 package webserver
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/r2dtools/agentintegration"
@@ -17,7 +18,8 @@ type HostManager interface {
 }
 
 type ProcessManager interface {
-	Reload() error
+	// Reload applies the configuration. The webserver is not reloaded if the context is already done.
+	Reload(ctx context.Context) error
 }
 
 func GetSupportedWebServers() []string {
@@ -46,6 +48,16 @@ func GetWebServer(webServerCode string, options map[string]string) (WebServer, e
 	return webServer, err
 }
 
+// GetWebServerVersion detects the version of the installed webserver
+func GetWebServerVersion(ctx context.Context, webServerCode string, options map[string]string) (string, error) {
+	switch webServerCode {
+	case WebServerNginxCode:
+		return GetNginxVersion(ctx, options)
+	default:
+		return "", fmt.Errorf("webserver '%s' is not supported", webServerCode)
+	}
+}
+
 func getVhostByName(vhosts []agentintegration.VirtualHost, serverName string) *agentintegration.VirtualHost {
 	for _, vhost := range vhosts {
 		if vhost.ServerName == serverName {
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/r2dtools/agentintegration"
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/r2dtools/sslbot/pkg/tlsutil"
+)
+
+const (
+	// heartbeatStatus is the status of frames the agent sends to the control plane to show that the connection is alive
+	heartbeatStatus = "heartbeat"
+
+	controlPlaneDialTimeout      = 30 * time.Second
+	controlPlaneHandshakeTimeout = time.Minute
+	controlPlaneMinBackoff       = time.Second
+)
+
+// ControlPlaneRegistration is the first frame the agent sends to the control plane. The control plane accepts
+// the agent with a response frame with the ok status or rejects it with an error response.
+type ControlPlaneRegistration struct {
+	// Token authenticates the agent to the control plane
+	Token           string
+	Hostname        string
+	AgentVersion    string
+	ProtocolVersion int
+	Server          *agentintegration.ServerData
+	Capabilities    *CapabilitiesResponseData
+}
+
+// ControlPlaneConnector connects the agent to the control plane that can not reach it, e.g. behind NAT or a firewall.
+// The agent dials out, registers itself and serves requests of the control plane over the connection as over
+// a persistent connection: requests are authenticated by agent tokens as usual. The agent sends heartbeat frames
+// while connected and reconnects with exponential backoff when the connection is lost.
+type ControlPlaneConnector struct {
+	Server  *Server
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped bool
+	conn    net.Conn
+	running sync.WaitGroup
+}
+
+// Serve keeps the agent connected to the control plane until the connector or the server is shut down
+func (c *ControlPlaneConnector) Serve() error {
+	s := c.Server
+	s.initOnce.Do(s.init)
+
+	if s.Config.ControlPlaneToken == "" {
+		return errors.New("control_plane_token is required to connect to the control plane")
+	}
+
+	ctx, ok := c.start()
+
+	if !ok {
+		return nil
+	}
+
+	defer c.running.Done()
+
+	if s.Config.ControlPlaneTlsDisabled {
+		s.Logger.Warning("TLS is disabled for the control plane connection: requests and tokens are transmitted in plaintext")
+	}
+
+	backoff := controlPlaneMinBackoff
+
+	for {
+		registered, err := c.connect(ctx)
+
+		if ctx.Err() != nil || s.shuttingDown.Load() {
+			return nil
+		}
+
+		// The backoff is reset after a successful registration, so a dropped connection is restored quickly
+		if registered {
+			backoff = controlPlaneMinBackoff
+		}
+
+		// Jitter spreads reconnections of agents that lost the control plane at the same time
+		delay := backoff/2 + rand.N(backoff/2+1)
+		s.Logger.Warning("control plane connection is lost: %v, reconnecting in %s", err, delay.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		backoff = min(backoff*2, max(s.Config.ControlPlaneMaxBackoff, controlPlaneMinBackoff))
+	}
+}
+
+// Shutdown stops reconnecting and waits until the current connection is closed or the context is done.
+// In-flight requests of the connection are handled before it is closed.
+func (c *ControlPlaneConnector) Shutdown(ctx context.Context) error {
+	c.Server.Logger.Info("disconnecting from the control plane")
+	c.mu.Lock()
+	c.stopped = true
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	// Interrupt the connection waiting for a request
+	if c.conn != nil {
+		c.conn.SetReadDeadline(time.Now())
+	}
+
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("control plane connection is still open: %v", ctx.Err())
+	}
+}
+
+func (c *ControlPlaneConnector) start() (context.Context, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return nil, false
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.running.Add(1)
+
+	return c.ctx, true
+}
+
+// connect dials the control plane, registers the agent and serves requests until the connection is closed.
+// It reports whether the agent was registered.
+func (c *ControlPlaneConnector) connect(ctx context.Context) (bool, error) {
+	s := c.Server
+	conn, err := c.dial(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	if err = c.register(ctx, conn); err != nil {
+		return false, err
+	}
+
+	// The server interrupts reads of its connections on shutdown, so no deadline is set after the connection is added
+	conn.SetDeadline(time.Time{})
+
+	if !c.setConn(conn) {
+		return true, errShuttingDown
+	}
+
+	defer c.setConn(nil)
+
+	if !s.addConn(conn) {
+		return true, errShuttingDown
+	}
+
+	defer s.removeConn(conn)
+
+	s.Logger.Info("agent is registered on the control plane %s", s.Config.ControlPlaneAddress)
+	peer := peer{address: "control-plane:" + conn.RemoteAddr().String()}
+	peer.ip, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	writer := &responseWriter{conn: conn, server: s}
+	requestCtx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	go c.sendHeartbeats(requestCtx, conn, writer)
+
+	err = s.servePersistent(requestCtx, cancel, conn, peer, writer, 0, nil)
+
+	return true, err
+}
+
+func (c *ControlPlaneConnector) setConn(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn = conn
+
+	return !c.stopped
+}
+
+func (c *ControlPlaneConnector) dial(ctx context.Context) (net.Conn, error) {
+	config := c.Server.Config
+	interval := config.ControlPlaneHeartbeatInterval
+	// Keepalive probes detect a control plane that disappeared without closing the connection
+	dialer := &net.Dialer{
+		Timeout:         controlPlaneDialTimeout,
+		KeepAliveConfig: net.KeepAliveConfig{Enable: true, Idle: interval, Interval: interval, Count: 3},
+	}
+
+	if config.ControlPlaneTlsDisabled {
+		return dialer.DialContext(ctx, "tcp", config.ControlPlaneAddress)
+	}
+
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: getControlPlaneTlsConfig(config)}
+
+	return tlsDialer.DialContext(ctx, "tcp", config.ControlPlaneAddress)
+}
+
+func (c *ControlPlaneConnector) register(ctx context.Context, conn net.Conn) error {
+	s := c.Server
+	conn.SetDeadline(time.Now().Add(controlPlaneHandshakeTimeout))
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	registration, err := c.getRegistration(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(registration)
+
+	if err != nil {
+		return fmt.Errorf("could not encode registration: %v", err)
+	}
+
+	if err = s.writeData(conn, data); err != nil {
+		return fmt.Errorf("could not send registration: %v", err)
+	}
+
+	if data, err = s.readData(conn); err != nil {
+		return fmt.Errorf("could not read registration response: %v", err)
+	}
+
+	var response router.Response
+
+	if err = json.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("could not decode registration response: %v", err)
+	}
+
+	if response.Status != "ok" {
+		return fmt.Errorf("control plane rejected the registration: %s", response.Error)
+	}
+
+	return nil
+}
+
+func (c *ControlPlaneConnector) getRegistration(ctx context.Context) (*ControlPlaneRegistration, error) {
+	s := c.Server
+	handler := &MainHandler{Config: s.Config, Logger: s.Logger, Server: s}
+	serverData, err := handler.refresh(ctx, router.Request{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities, err := handler.capabilities(ctx, router.Request{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ControlPlaneRegistration{
+		Token:           s.Config.ControlPlaneToken,
+		Hostname:        serverData.HostName,
+		AgentVersion:    s.Config.Version,
+		ProtocolVersion: router.ProtocolVersion,
+		Server:          serverData,
+		Capabilities:    capabilities,
+	}, nil
+}
+
+// sendHeartbeats sends heartbeat frames until the context is done. The connection is closed if a heartbeat can not be sent,
+// so the agent reconnects.
+func (c *ControlPlaneConnector) sendHeartbeats(ctx context.Context, conn net.Conn, writer *responseWriter) {
+	interval := c.Server.Config.ControlPlaneHeartbeatInterval
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := writer.write(router.Response{Version: router.ProtocolVersion, Status: heartbeatStatus}); err != nil {
+				conn.Close()
+
+				return
+			}
+		}
+	}
+}
+
+// getControlPlaneTlsConfig returns the TLS configuration verifying the control plane certificate by the configured
+// fingerprint or by system CAs
+func getControlPlaneTlsConfig(config *config.Config) *tls.Config {
+	if config.ControlPlaneTlsFingerprint != "" {
+		return tlsutil.GetFingerprintTlsConfig(config.ControlPlaneTlsFingerprint, "control plane")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	tlsConfig.ServerName, _, _ = net.SplitHostPort(config.ControlPlaneAddress)
+
+	return tlsConfig
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlPlaneConnector(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	server := getTestServer(t)
+	server.Config.ControlPlaneAddress = listener.Addr().String()
+	server.Config.ControlPlaneToken = "cp-secret"
+	server.Config.ControlPlaneTlsDisabled = true
+	server.Config.ControlPlaneHeartbeatInterval = 50 * time.Millisecond
+	server.Config.ControlPlaneMaxBackoff = 100 * time.Millisecond
+	connector := &ControlPlaneConnector{Server: server}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- connector.Serve()
+	}()
+
+	conn := acceptControlPlaneConn(t, server, listener, "ok")
+	writeTestFrame(t, conn, router.Request{Version: router.ProtocolVersion, Id: "1", Command: "test.echo", Token: testToken, Data: "hello"})
+
+	response := readControlPlaneResponse(t, conn)
+	assert.Equal(t, "1", response.Id)
+	assert.Equal(t, "ok", response.Status)
+	assert.Equal(t, "hello", response.Data)
+
+	// Heartbeats are sent while the connection is idle
+	time.Sleep(120 * time.Millisecond)
+	assert.Equal(t, heartbeatStatus, readTestFrame(t, conn).Status)
+
+	// The agent reconnects after the connection is lost and retries a rejected registration
+	conn.Close()
+	conn = acceptControlPlaneConn(t, server, listener, "error")
+	conn.Close()
+	conn = acceptControlPlaneConn(t, server, listener, "ok")
+	defer conn.Close()
+
+	writeTestFrame(t, conn, router.Request{Version: router.ProtocolVersion, Id: "2", Command: "test.getStatus", Token: "invalid"})
+
+	response = readControlPlaneResponse(t, conn)
+	assert.Equal(t, "2", response.Id)
+	assert.Equal(t, "error", response.Status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.Nil(t, server.Shutdown(ctx))
+	assert.Nil(t, connector.Shutdown(ctx))
+	assert.Nil(t, <-serveErr)
+}
+
+func TestControlPlaneConnectorRequiresToken(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.ControlPlaneAddress = "127.0.0.1:1"
+	connector := &ControlPlaneConnector{Server: server}
+
+	assert.ErrorContains(t, connector.Serve(), "control_plane_token is required")
+}
+
+// acceptControlPlaneConn accepts the agent connection, checks its registration and responds with the status
+func acceptControlPlaneConn(t *testing.T, server *Server, listener net.Listener, status string) net.Conn {
+	conn, err := listener.Accept()
+	assert.Nil(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	data, err := server.readData(conn)
+	assert.Nil(t, err)
+
+	var registration ControlPlaneRegistration
+	assert.Nil(t, json.Unmarshal(data, &registration))
+	assert.Equal(t, "cp-secret", registration.Token)
+	assert.Equal(t, router.ProtocolVersion, registration.ProtocolVersion)
+	assert.NotNil(t, registration.Server)
+	assert.NotNil(t, registration.Capabilities)
+
+	response := router.Response{Version: router.ProtocolVersion, Status: status}
+
+	if status != "ok" {
+		response.Error = "agent is not allowed"
+	}
+
+	data, err = json.Marshal(response)
+	assert.Nil(t, err)
+	assert.Nil(t, server.writeData(conn, data))
+
+	return conn
+}
+
+// readControlPlaneResponse reads frames until a response that is not a heartbeat
+func readControlPlaneResponse(t *testing.T, conn net.Conn) router.Response {
+	for {
+		response := readTestFrame(t, conn)
+
+		if response.Status != heartbeatStatus {
+			return response
+		}
+	}
+}
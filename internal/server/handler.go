@@ -1,47 +1,74 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
-	"github.com/mitchellh/mapstructure"
 	"github.com/r2dtools/agentintegration"
 	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/modules/certificates/acme/client"
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
 	"github.com/r2dtools/sslbot/internal/pkg/certificate"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
 	"github.com/r2dtools/sslbot/internal/pkg/router"
 	"github.com/r2dtools/sslbot/internal/pkg/webserver"
+	"github.com/r2dtools/sslbot/pkg/protocol"
 	"github.com/shirou/gopsutil/host"
 )
 
+type RotateTokenResponseData = protocol.RotatedToken
+
+// protocolFeatures lists optional request fields supported by the agent
+var protocolFeatures = []string{"keepalive", "signature", "async", "events"}
+
+type CapabilitiesResponseData = protocol.Capabilities
+
+type WebServerCapabilities = protocol.WebServerCapabilities
+
 type MainHandler struct {
 	Config *config.Config
 	Logger logger.Logger
+	Server *Server
 }
 
-func (h *MainHandler) Handle(request router.Request) (any, error) {
-	var response interface{}
-	var err error
+// VhostCertificateRequestData identifies the vhost whose certificate is requested over TLS
+type VhostCertificateRequestData struct {
+	VhostName string `validate:"required,domain"`
+}
 
-	switch action := request.GetAction(); action {
-	case "refresh":
-		response, err = h.refresh()
-	case "getVhosts":
-		response, err = h.getVhosts()
-	case "getVhostCertificate":
-		response, err = h.getVhostCertificate(request.Data)
-	case "getvhostconfig":
-		response, err = h.getVhostConfig(request.Data)
-	default:
-		response, err = nil, fmt.Errorf("invalid action '%s' for module '%s'", action, request.GetModule())
-	}
+// VhostConfigRequestData mirrors agentintegration.VirtualHostConfigRequestData with validation rules
+type VhostConfigRequestData struct {
+	WebServer  string `validate:"required"`
+	ServerName string `validate:"required"`
+}
 
-	return response, err
+// AuditLogRequestData filters audit log entries. Since and Until are RFC 3339 timestamps.
+type AuditLogRequestData = protocol.AuditLogFilter
+
+// NewMainHandler creates the main module. The server is used to report statistics and the action catalogue, it may be nil.
+func NewMainHandler(config *config.Config, logger logger.Logger, server *Server) *router.Module {
+	h := &MainHandler{Config: config, Logger: logger, Server: server}
+
+	return router.NewModule(
+		router.NewActionWithoutData("refresh", "Get information about the server and the agent", h.refresh),
+		router.NewActionWithoutData("getVhosts", "List vhosts of the supported webservers", h.getVhosts),
+		router.NewAction("getVhostCertificate", "Get the certificate served for the vhost", h.getVhostCertificate),
+		router.NewAction("getvhostconfig", "Get the configuration file content of the vhost", h.getVhostConfig),
+		router.NewActionWithoutData("rotateToken", "Rotate the agent token", h.rotateToken).Mutates(),
+		router.NewActionWithoutData("stats", "Get request statistics of the server", h.stats),
+		router.NewActionWithoutData("capabilities", "Get the protocol version and supported features", h.capabilities),
+		router.NewActionWithoutData("actions", "Describe actions of the registered modules", h.actions),
+		router.NewActionWithoutData("health", "Check whether the agent is able to issue and deploy certificates", h.health),
+		router.NewAction("auditLog", "Query the audit log of mutating commands, the newest entries first", h.auditLog),
+	)
 }
 
-func (h *MainHandler) refresh() (*agentintegration.ServerData, error) {
+func (h *MainHandler) refresh(ctx context.Context, request router.Request) (*agentintegration.ServerData, error) {
 	info, err := host.Info()
 	if err != nil {
 		return nil, fmt.Errorf("could not get system info: %v", err)
@@ -62,7 +89,129 @@ func (h *MainHandler) refresh() (*agentintegration.ServerData, error) {
 	return &serverData, nil
 }
 
-func (h *MainHandler) getVhosts() ([]agentintegration.VirtualHost, error) {
+func (h *MainHandler) rotateToken(ctx context.Context, request router.Request) (*RotateTokenResponseData, error) {
+	// The main token allows all commands, so a token with limited scopes must not obtain it
+	if !auth.AllowsAll(request.Scopes) {
+		return nil, router.NewError(router.ErrorCodeForbidden, "token '%s' is not allowed to rotate the main token", request.TokenName)
+	}
+
+	token, previousTokenExpiresAt, err := auth.RotateToken(h.Config, time.Now())
+
+	if err != nil {
+		h.Logger.Error("failed to rotate token: %v", err)
+
+		return nil, errors.New("could not rotate token")
+	}
+
+	h.Logger.Info("token is rotated by '%s'", request.TokenName)
+
+	return &RotateTokenResponseData{Token: token, PreviousTokenExpiresAt: previousTokenExpiresAt}, nil
+}
+
+func (h *MainHandler) stats(ctx context.Context, request router.Request) (ServerStats, error) {
+	if h.Server == nil {
+		return ServerStats{}, errors.New("server statistics are not available")
+	}
+
+	return h.Server.GetStats(), nil
+}
+
+func (h *MainHandler) capabilities(ctx context.Context, request router.Request) (*CapabilitiesResponseData, error) {
+	response := &CapabilitiesResponseData{
+		ProtocolVersion: router.ProtocolVersion,
+		AgentVersion:    h.Config.Version,
+		Features:        protocolFeatures,
+		Modules:         make(map[string][]string),
+	}
+
+	if h.Server != nil {
+		for _, module := range h.Server.Router.GetModules() {
+			response.Modules[module] = h.Server.Router.GetActions(module)
+		}
+	}
+
+	options := h.Config.ToMap()
+
+	for _, webServerCode := range webserver.GetSupportedWebServers() {
+		webServerCapabilities := WebServerCapabilities{Code: webServerCode}
+		version, err := webserver.GetWebServerVersion(ctx, webServerCode, options)
+
+		if err != nil {
+			webServerCapabilities.Error = err.Error()
+		} else {
+			webServerCapabilities.Version = version
+		}
+
+		response.WebServers = append(response.WebServers, webServerCapabilities)
+	}
+
+	acmeClient, err := client.CreateAcmeClient(h.Config)
+
+	if err == nil {
+		response.AcmeClient, err = acmeClient.GetInfo(ctx)
+	}
+
+	if err != nil {
+		response.AcmeClientError = err.Error()
+	}
+
+	return response, nil
+}
+
+func (h *MainHandler) actions(ctx context.Context, request router.Request) (map[string][]router.ActionInfo, error) {
+	if h.Server == nil {
+		return nil, errors.New("action catalogue is not available")
+	}
+
+	response := make(map[string][]router.ActionInfo)
+
+	for _, module := range h.Server.Router.GetModules() {
+		response[module] = h.Server.Router.DescribeActions(module)
+	}
+
+	return response, nil
+}
+
+func (h *MainHandler) health(ctx context.Context, request router.Request) (*HealthResponseData, error) {
+	return CheckHealth(ctx, h.Config, h.Logger), nil
+}
+
+func (h *MainHandler) auditLog(ctx context.Context, request router.Request, data AuditLogRequestData) ([]audit.Entry, error) {
+	if h.Server == nil || h.Server.AuditLog == nil {
+		return nil, errors.New("audit log is not available")
+	}
+
+	filter := audit.Filter{
+		Command:   data.Command,
+		TokenName: data.TokenName,
+		Vhost:     data.Vhost,
+		CertName:  data.CertName,
+		Status:    data.Status,
+		Limit:     data.Limit,
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value string
+		time  *time.Time
+	}{{"Since", data.Since, &filter.Since}, {"Until", data.Until, &filter.Until}} {
+		if bound.value == "" {
+			continue
+		}
+
+		parsedTime, err := time.Parse(time.RFC3339, bound.value)
+
+		if err != nil {
+			return nil, router.NewInvalidDataError("%s must be an RFC 3339 timestamp: %v", bound.name, err)
+		}
+
+		*bound.time = parsedTime
+	}
+
+	return h.Server.AuditLog.Query(filter)
+}
+
+func (h *MainHandler) getVhosts(ctx context.Context, request router.Request) ([]agentintegration.VirtualHost, error) {
 	webServerCodes := webserver.GetSupportedWebServers()
 	var vhosts []agentintegration.VirtualHost
 	options := h.Config.ToMap()
@@ -88,26 +237,9 @@ func (h *MainHandler) getVhosts() ([]agentintegration.VirtualHost, error) {
 	return vhosts, nil
 }
 
-func (h *MainHandler) getVhostCertificate(data interface{}) (*agentintegration.Certificate, error) {
-	mData, ok := data.(map[string]interface{})
-
-	if !ok {
-		return nil, errors.New("invalid request data format")
-	}
-
-	vhostNameRaw, ok := mData["vhostName"]
-
-	if !ok {
-		return nil, errors.New("invalid request data: vhost name is not specified")
-	}
-
-	vhostName, ok := vhostNameRaw.(string)
-
-	if !ok {
-		return nil, errors.New("invalid request data: vhost name is invalid")
-	}
-
-	cert, err := certificate.GetCertificateForDomainFromRequest(vhostName)
+func (h *MainHandler) getVhostCertificate(ctx context.Context, request router.Request, data VhostCertificateRequestData) (*agentintegration.Certificate, error) {
+	vhostName := data.VhostName
+	cert, err := certificate.GetCertificateForDomainFromRequest(ctx, vhostName)
 
 	if err != nil {
 		message := "could not get vhost '%s' certificate: %v"
@@ -119,31 +251,24 @@ func (h *MainHandler) getVhostCertificate(data interface{}) (*agentintegration.C
 	return cert, nil
 }
 
-func (h *MainHandler) getVhostConfig(data interface{}) (agentintegration.VirtualHostConfigResponseData, error) {
+func (h *MainHandler) getVhostConfig(ctx context.Context, request router.Request, data VhostConfigRequestData) (agentintegration.VirtualHostConfigResponseData, error) {
 	var response agentintegration.VirtualHostConfigResponseData
-	var request agentintegration.VirtualHostConfigRequestData
-
-	err := mapstructure.Decode(data, &request)
-
-	if err != nil {
-		return response, fmt.Errorf("invalid vhodt config request data: %v", err)
-	}
 
 	options := h.Config.ToMap()
-	wServer, err := webserver.GetWebServer(request.WebServer, options)
+	wServer, err := webserver.GetWebServer(data.WebServer, options)
 
 	if err != nil {
 		return response, err
 	}
 
-	vhost, err := wServer.GetVhostByName(request.ServerName)
+	vhost, err := wServer.GetVhostByName(data.ServerName)
 
 	if err != nil {
 		return response, err
 	}
 
 	if vhost == nil {
-		return response, fmt.Errorf("vhost %s not found", request.ServerName)
+		return response, router.NewError(router.ErrorCodeNotFound, "vhost %s not found", data.ServerName)
 	}
 
 	configFile, err := os.Open(vhost.FilePath)
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.VarDir = t.TempDir()
+	server.Config.Version = "1.2.3"
+	server.Config.LegoBin = filepath.Join(server.Config.VarDir, "lego")
+	legoScript := `#!/bin/sh
+case "$1" in
+	--version) echo "lego version v4.17.4 linux/amd64" ;;
+	dnshelp) printf 'Supported DNS providers:\n  cloudflare, route53\n' ;;
+esac
+`
+	assert.Nil(t, os.WriteFile(server.Config.LegoBin, []byte(legoScript), 0755))
+
+	handler := NewMainHandler(server.Config, server.Logger, server)
+	server.Router.RegisterHandler("main", handler)
+
+	response, err := handler.Handle(context.Background(), router.Request{Command: "main.capabilities"})
+	assert.Nil(t, err)
+
+	capabilities := response.(*CapabilitiesResponseData)
+	assert.Equal(t, router.ProtocolVersion, capabilities.ProtocolVersion)
+	assert.Equal(t, "1.2.3", capabilities.AgentVersion)
+	assert.Equal(t, []string{"echo", "sleep", "getStatus", "progress"}, capabilities.Modules["test"])
+	assert.Contains(t, capabilities.Modules["main"], "capabilities")
+	assert.Len(t, capabilities.WebServers, 1)
+	assert.Equal(t, "nginx", capabilities.WebServers[0].Code)
+	assert.Equal(t, "lego", capabilities.AcmeClient.Name)
+	assert.Equal(t, "4.17.4", capabilities.AcmeClient.Version)
+	assert.Equal(t, []string{"http", "dns"}, capabilities.AcmeClient.ChallengeTypes)
+	assert.Equal(t, []string{"cloudflare", "route53"}, capabilities.AcmeClient.DnsProviders)
+}
+
+func TestActions(t *testing.T) {
+	server := getTestServer(t)
+	handler := NewMainHandler(server.Config, server.Logger, server)
+	server.Router.RegisterHandler("main", handler)
+
+	response, err := handler.Handle(context.Background(), router.Request{Command: "main.actions"})
+	assert.Nil(t, err)
+
+	catalogue := response.(map[string][]router.ActionInfo)
+	assert.Equal(t, []router.ActionInfo{{Name: "echo"}, {Name: "sleep"}, {Name: "getStatus"}, {Name: "progress"}}, catalogue["test"])
+	assert.Contains(t, catalogue["main"], router.ActionInfo{
+		Name:        "getVhostCertificate",
+		Description: "Get the certificate served for the vhost",
+		Fields:      []router.FieldInfo{{Name: "VhostName", Type: "string", Rules: []string{"required", "domain"}}},
+	})
+
+	_, err = handler.Handle(context.Background(), router.Request{Command: "main.getVhostCertificate", Data: map[string]interface{}{"vhostName": "example.com/path"}})
+	assert.Equal(t, router.ErrorCodeInvalidRequest, router.GetError(err).Code)
+}
+
+func TestAuditLog(t *testing.T) {
+	server := getTestServer(t)
+	handler := NewMainHandler(server.Config, server.Logger, server)
+
+	_, err := handler.Handle(context.Background(), router.Request{Command: "main.auditLog"})
+	assert.EqualError(t, err, "audit log is not available")
+
+	server.AuditLog = audit.NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.Nil(t, server.AuditLog.Append(audit.Entry{Time: now.Add(-2 * time.Hour), Command: "certificates.issue", Vhost: "example.com", Status: audit.StatusOk}))
+	assert.Nil(t, server.AuditLog.Append(audit.Entry{Time: now, Command: "certificates.issue", Vhost: "example.com", Status: audit.StatusError}))
+	assert.Nil(t, server.AuditLog.Append(audit.Entry{Time: now, Command: "main.rotateToken", Status: audit.StatusOk}))
+
+	response, err := handler.Handle(context.Background(), router.Request{Command: "main.auditLog", Data: map[string]interface{}{
+		"Vhost": "example.com",
+		"Since": now.Add(-time.Hour).Format(time.RFC3339),
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, []audit.Entry{{Time: now, Command: "certificates.issue", Vhost: "example.com", Status: audit.StatusError}}, response)
+
+	_, err = handler.Handle(context.Background(), router.Request{Command: "main.auditLog", Data: map[string]interface{}{"Since": "yesterday"}})
+	assert.Equal(t, router.ErrorCodeInvalidRequest, router.GetError(err).Code)
+}
+
+func TestRotateToken(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.ConfigFilePath = filepath.Join(t.TempDir(), "config.yaml")
+	server.Config.TokenGracePeriod = time.Hour
+	assert.Nil(t, os.WriteFile(server.Config.ConfigFilePath, []byte("port: 60150\n"), 0644))
+	handler := NewMainHandler(server.Config, server.Logger, server)
+
+	// A token with limited scopes could escalate its privileges with the main token
+	_, err := handler.Handle(context.Background(), router.Request{Command: "main.rotateToken", TokenName: "monitoring", Scopes: []string{"main.*"}})
+	assert.Equal(t, router.ErrorCodeForbidden, router.GetError(err).Code)
+
+	response, err := handler.Handle(context.Background(), router.Request{Command: "main.rotateToken", TokenName: "default", Scopes: []string{"*"}})
+	assert.Nil(t, err)
+
+	rotatedToken := response.(*RotateTokenResponseData)
+	assert.NotEmpty(t, rotatedToken.Token)
+	assert.Equal(t, "[redacted]", router.RedactData(response).(*RotateTokenResponseData).Token)
+
+	identity, err := auth.Authenticate(server.Config, rotatedToken.Token, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, auth.DefaultIdentity, identity.Name)
+
+	// The previous token remains valid during the grace period
+	_, err = auth.Authenticate(server.Config, testToken, time.Now())
+	assert.Nil(t, err)
+}
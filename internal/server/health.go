@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/modules/certificates/acme/client"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/webserver"
+	"github.com/r2dtools/sslbot/internal/pkg/webserver/processmng"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	HealthStatusOk     = protocol.HealthStatusOk
+	HealthStatusFailed = protocol.HealthStatusFailed
+)
+
+// Names of health checks
+const (
+	HealthCheckConfig       = "config"
+	HealthCheckVarDir       = "var_dir"
+	HealthCheckCertStorage  = "cert_storage"
+	HealthCheckAcmeClient   = "acme_client"
+	HealthCheckNginxConfig  = "nginx_config"
+	HealthCheckNginxProcess = "nginx_process"
+)
+
+type HealthResponseData = protocol.Health
+
+type HealthCheck = protocol.HealthCheck
+
+// CheckHealth checks whether the agent is able to issue and deploy certificates. All checks are run even if one of them fails.
+func CheckHealth(ctx context.Context, config *config.Config, logger logger.Logger) *HealthResponseData {
+	checks := []struct {
+		name  string
+		check func() (string, error)
+	}{
+		{HealthCheckConfig, func() (string, error) { return checkConfigFile(config.ConfigFilePath) }},
+		{HealthCheckVarDir, func() (string, error) { return checkVarDir(config.VarDir) }},
+		{HealthCheckCertStorage, func() (string, error) {
+			storage, err := client.CreateCertStorage(config, logger)
+
+			if err != nil {
+				return "", err
+			}
+
+			certificates, err := storage.GetCertificates()
+
+			if err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("%d certificate(s) in the storage", len(certificates)), nil
+		}},
+		{HealthCheckAcmeClient, func() (string, error) {
+			acmeClient, err := client.CreateAcmeClient(config)
+
+			if err != nil {
+				return "", err
+			}
+
+			return acmeClient.GetVersion(ctx)
+		}},
+		{HealthCheckNginxConfig, func() (string, error) {
+			if _, err := webserver.GetNginxWebServer(config.ToMap()); err != nil {
+				return "", err
+			}
+
+			return "nginx configuration is parsed", nil
+		}},
+		{HealthCheckNginxProcess, func() (string, error) {
+			if _, err := processmng.GetNginxProcessManager(); err != nil {
+				return "", err
+			}
+
+			return "nginx master process is running", nil
+		}},
+	}
+
+	response := &HealthResponseData{Status: HealthStatusOk}
+
+	for _, check := range checks {
+		result := HealthCheck{Name: check.name, Status: HealthStatusOk}
+		message, err := check.check()
+
+		if err != nil {
+			result.Status = HealthStatusFailed
+			result.Error = err.Error()
+			response.Status = HealthStatusFailed
+		} else {
+			result.Message = message
+		}
+
+		response.Checks = append(response.Checks, result)
+	}
+
+	return response
+}
+
+// checkConfigFile parses the config file without applying it, so a broken file is reported before the agent restarts
+func checkConfigFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", fmt.Errorf("could not read config file: %v", err)
+	}
+
+	var values map[string]interface{}
+
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return "", fmt.Errorf("could not parse config file: %v", err)
+	}
+
+	return path, nil
+}
+
+func checkVarDir(path string) (string, error) {
+	file, err := os.CreateTemp(path, ".health-*")
+
+	if err != nil {
+		return "", fmt.Errorf("var directory is not writable: %v", err)
+	}
+
+	file.Close()
+
+	if err = os.Remove(file.Name()); err != nil {
+		return "", fmt.Errorf("could not remove file from var directory: %v", err)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.VarDir = t.TempDir()
+	server.Config.ConfigFilePath = filepath.Join(server.Config.VarDir, "config.yaml")
+	server.Config.LegoBin = filepath.Join(server.Config.VarDir, "lego")
+	assert.Nil(t, os.WriteFile(server.Config.ConfigFilePath, []byte("port: 60150\n"), 0644))
+	assert.Nil(t, os.WriteFile(server.Config.LegoBin, []byte("#!/bin/sh\necho \"lego version v4.17.4 linux/amd64\"\n"), 0755))
+	handler := NewMainHandler(server.Config, server.Logger, server)
+
+	response, err := handler.Handle(context.Background(), router.Request{Command: "main.health"})
+	assert.Nil(t, err)
+
+	health := response.(*HealthResponseData)
+	checks := make(map[string]HealthCheck)
+	expectedStatus := HealthStatusOk
+
+	for _, check := range health.Checks {
+		checks[check.Name] = check
+
+		if check.Status != HealthStatusOk {
+			expectedStatus = HealthStatusFailed
+		}
+	}
+
+	assert.Equal(t, expectedStatus, health.Status)
+	assert.Equal(t, HealthCheck{Name: HealthCheckConfig, Status: HealthStatusOk, Message: server.Config.ConfigFilePath}, checks[HealthCheckConfig])
+	assert.Equal(t, HealthCheck{Name: HealthCheckVarDir, Status: HealthStatusOk, Message: server.Config.VarDir}, checks[HealthCheckVarDir])
+	assert.Equal(t, HealthCheck{Name: HealthCheckCertStorage, Status: HealthStatusOk, Message: "0 certificate(s) in the storage"}, checks[HealthCheckCertStorage])
+	assert.Equal(t, HealthCheck{Name: HealthCheckAcmeClient, Status: HealthStatusOk, Message: "lego 4.17.4"}, checks[HealthCheckAcmeClient])
+	// nginx checks depend on the host, they are only expected to be reported
+	assert.Contains(t, checks, HealthCheckNginxConfig)
+	assert.Contains(t, checks, HealthCheckNginxProcess)
+
+	// A broken config file fails the health of the agent
+	assert.Nil(t, os.WriteFile(server.Config.ConfigFilePath, []byte("port: [60150\n"), 0644))
+	health = CheckHealth(context.Background(), server.Config, server.Logger)
+	assert.Equal(t, HealthStatusFailed, health.Status)
+	assert.Equal(t, HealthCheckConfig, health.Checks[0].Name)
+	assert.Equal(t, HealthStatusFailed, health.Checks[0].Status)
+	assert.Contains(t, health.Checks[0].Error, "could not parse config file")
+
+	metricsServer := httptest.NewServer((&MetricsServer{Registry: metrics.NewRegistry(), Logger: server.Logger, Config: server.Config}).Handler())
+	defer metricsServer.Close()
+
+	httpResponse, err := http.Get(metricsServer.URL + "/health")
+	assert.Nil(t, err)
+	defer httpResponse.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, httpResponse.StatusCode)
+
+	// The unauthenticated endpoint returns the overall status only
+	var httpHealth map[string]interface{}
+	assert.Nil(t, json.NewDecoder(httpResponse.Body).Decode(&httpHealth))
+	assert.Equal(t, map[string]interface{}{"Status": HealthStatusFailed}, httpHealth)
+}
+
+func TestHealthEndpointIsCached(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.VarDir = t.TempDir()
+	server.Config.ConfigFilePath = filepath.Join(server.Config.VarDir, "config.yaml")
+	server.Config.LegoBin = filepath.Join(server.Config.VarDir, "lego")
+	callsPath := filepath.Join(server.Config.VarDir, "calls")
+	assert.Nil(t, os.WriteFile(server.Config.ConfigFilePath, []byte("port: 60150\n"), 0644))
+	assert.Nil(t, os.WriteFile(server.Config.LegoBin, []byte("#!/bin/sh\necho \"$@\" >> "+callsPath+"\necho \"lego version v4.17.4 linux/amd64\"\n"), 0755))
+
+	metricsServer := httptest.NewServer((&MetricsServer{Registry: metrics.NewRegistry(), Logger: server.Logger, Config: server.Config}).Handler())
+	defer metricsServer.Close()
+
+	var wg sync.WaitGroup
+
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := http.Get(metricsServer.URL + "/health")
+			assert.Nil(t, err)
+			response.Body.Close()
+		}()
+	}
+
+	wg.Wait()
+
+	// Concurrent probes share one run of the checks, and the ACME client is only asked for its version
+	calls, err := os.ReadFile(callsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "--version\n", string(calls))
+}
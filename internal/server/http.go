@@ -0,0 +1,445 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+)
+
+const (
+	httpApiPrefix         = "/v1/"
+	httpOpenApiPath       = httpApiPrefix + "openapi.json"
+	httpVersionHeader     = "X-Sslbot-Protocol-Version"
+	httpKeyIdHeader       = "X-Sslbot-Key-Id"
+	httpTimestampHeader   = "X-Sslbot-Timestamp"
+	httpNonceHeader       = "X-Sslbot-Nonce"
+	httpSignatureHeader   = "X-Sslbot-Signature"
+	httpBearerTokenPrefix = "Bearer "
+)
+
+// HttpGateway exposes router commands as HTTP/JSON endpoints: POST /v1/<module>/<action>, or GET for commands that
+// do not change the server. Requests are authenticated, rate limited and signed the same way as requests of the TCP server,
+// and connections count against the connection limit of the TCP server.
+type HttpGateway struct {
+	Port       int
+	Server     *Server
+	mu         sync.Mutex
+	httpServer *http.Server
+}
+
+func (g *HttpGateway) Serve() error {
+	s := g.Server
+	port := strconv.Itoa(g.Port)
+	s.Logger.Info("starting HTTP gateway on port %s ...", port)
+	listener, err := g.listen(":" + port)
+
+	if err != nil {
+		s.Logger.Error("error starting HTTP gateway: %v", err)
+		return err
+	}
+
+	if err = s.checkAuthConfig(); err != nil {
+		listener.Close()
+		s.Logger.Error("error starting HTTP gateway: %v", err)
+
+		return err
+	}
+
+	s.Logger.Info("HTTP gateway successfully started")
+
+	return g.serve(listener)
+}
+
+// Shutdown stops accepting requests and waits for in-flight ones until the context is done
+func (g *HttpGateway) Shutdown(ctx context.Context) error {
+	g.Server.Logger.Info("shutting down HTTP gateway")
+
+	return g.getHttpServer().Shutdown(ctx)
+}
+
+// listen accepts connections while the server has free connection slots. TLS is applied to the accepted connections,
+// so the HTTP server sees the client certificates.
+func (g *HttpGateway) listen(address string) (net.Listener, error) {
+	s := g.Server
+	s.initOnce.Do(s.init)
+	tlsConfig, err := s.getListenerTlsConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var limitedListener net.Listener = &connLimitListener{Listener: listener, server: s, tlsConfig: tlsConfig}
+
+	if tlsConfig != nil {
+		limitedListener = tls.NewListener(limitedListener, tlsConfig)
+	}
+
+	return limitedListener, nil
+}
+
+func (g *HttpGateway) serve(listener net.Listener) error {
+	g.Server.initOnce.Do(g.Server.init)
+	err := g.getHttpServer().Serve(listener)
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (g *HttpGateway) getHttpServer() *http.Server {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.httpServer == nil {
+		g.httpServer = &http.Server{
+			Handler:      g.Handler(),
+			ReadTimeout:  g.Server.Config.ReadTimeout,
+			WriteTimeout: g.Server.Config.WriteTimeout,
+			// Requests are cancelled with the ones of the TCP server if its shutdown times out
+			BaseContext: func(net.Listener) context.Context {
+				return g.Server.ctx
+			},
+			ConnState: g.trackConnState,
+		}
+	}
+
+	return g.httpServer
+}
+
+// trackConnState counts the connections closed without a completed TLS handshake. The HTTP server performs
+// the handshake lazily, so its failures are only seen when the connection is closed.
+func (g *HttpGateway) trackConnState(conn net.Conn, state http.ConnState) {
+	tlsConn, ok := conn.(*tls.Conn)
+
+	if ok && state == http.StateClosed && !tlsConn.ConnectionState().HandshakeComplete {
+		g.Server.handshakeFailed()
+	}
+}
+
+func (g *HttpGateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+httpOpenApiPath, g.handleOpenApi)
+	mux.HandleFunc("GET "+httpApiPrefix+"{module}/{action}", g.handleCommand)
+	mux.HandleFunc("POST "+httpApiPrefix+"{module}/{action}", g.handleCommand)
+
+	return mux
+}
+
+func (g *HttpGateway) handleCommand(w http.ResponseWriter, r *http.Request) {
+	s := g.Server
+	s.initOnce.Do(s.init)
+
+	peer := peer{address: r.RemoteAddr}
+	peer.ip, _, _ = net.SplitHostPort(peer.address)
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		peer.certSubject = r.TLS.PeerCertificates[0].Subject.String()
+	}
+
+	request, rawData, err := g.decodeRequest(r)
+
+	if err != nil {
+		g.writeResponse(w, s.prepareResponse(nil, nil, err), getHttpStatus(err))
+
+		return
+	}
+
+	// GET requests may be sent by prefetching proxies and browsers, so they must not change the server
+	if r.Method == http.MethodGet && s.Router.IsMutating(*request) {
+		err = router.NewError(router.ErrorCodeInvalidRequest, "command '%s' changes the server and must be sent with POST", request.Command)
+		w.Header().Set("Allow", http.MethodPost)
+		g.writeResponse(w, s.prepareResponse(request, nil, err), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	data, err := s.handleRequest(r.Context(), *request, rawData, peer)
+	g.writeResponse(w, s.prepareResponse(request, data, err), getHttpStatus(err))
+}
+
+// decodeRequest builds a router request from the HTTP request. The JSON body of a POST request or
+// the query parameters of a GET request are used as request data.
+func (g *HttpGateway) decodeRequest(r *http.Request) (*router.Request, []byte, error) {
+	request := &router.Request{
+		Command:   r.PathValue("module") + "." + g.resolveAction(r.PathValue("module"), r.PathValue("action")),
+		Token:     strings.TrimPrefix(r.Header.Get("Authorization"), httpBearerTokenPrefix),
+		KeyId:     r.Header.Get(httpKeyIdHeader),
+		Nonce:     r.Header.Get(httpNonceHeader),
+		Signature: r.Header.Get(httpSignatureHeader),
+	}
+
+	if version := r.Header.Get(httpVersionHeader); version != "" {
+		value, err := strconv.Atoi(version)
+
+		if err != nil {
+			return nil, nil, router.NewError(router.ErrorCodeInvalidRequest, "invalid %s header: %v", httpVersionHeader, err)
+		}
+
+		request.Version = value
+	}
+
+	if timestamp := r.Header.Get(httpTimestampHeader); timestamp != "" {
+		value, err := strconv.ParseInt(timestamp, 10, 64)
+
+		if err != nil {
+			return nil, nil, router.NewError(router.ErrorCodeInvalidRequest, "invalid %s header: %v", httpTimestampHeader, err)
+		}
+
+		request.Timestamp = value
+	}
+
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+
+		if len(query) > 0 {
+			data, err := g.decodeQuery(request, query)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			request.Data = data
+		}
+
+		// The signature of a GET request covers its raw query string
+		return request, []byte(r.URL.RawQuery), nil
+	}
+
+	var reader io.Reader = r.Body
+
+	if maxSize := g.Server.Config.MaxRequestSize; maxSize > 0 {
+		reader = io.LimitReader(r.Body, int64(maxSize)+1)
+	}
+
+	body, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, nil, router.NewError(router.ErrorCodeInvalidRequest, "could not read request body: %v", err)
+	}
+
+	if maxSize := g.Server.Config.MaxRequestSize; maxSize > 0 && len(body) > maxSize {
+		return nil, nil, router.NewError(router.ErrorCodeRequestTooLarge, "request size exceeds the maximum of %d bytes", maxSize)
+	}
+
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &request.Data); err != nil {
+			return nil, nil, router.NewError(router.ErrorCodeInvalidRequest, "could not decode request data: %v", err)
+		}
+	}
+
+	return request, body, nil
+}
+
+// decodeQuery converts the query parameters to the types of the action fields. Parameters of actions that do not
+// describe their fields are passed as strings.
+func (g *HttpGateway) decodeQuery(request *router.Request, query url.Values) (map[string]interface{}, error) {
+	var fields []router.FieldInfo
+
+	for _, info := range g.Server.Router.DescribeActions(request.GetModule()) {
+		if info.Name == request.GetAction() {
+			fields = info.Fields
+		}
+	}
+
+	data := make(map[string]interface{}, len(query))
+
+	for key, values := range query {
+		fieldType := "string"
+
+		for _, field := range fields {
+			if strings.EqualFold(field.Name, key) {
+				fieldType = field.Type
+			}
+		}
+
+		value, err := decodeQueryValue(fieldType, values)
+
+		if err != nil {
+			return nil, router.NewError(router.ErrorCodeInvalidRequest, "invalid query parameter '%s': %v", key, err)
+		}
+
+		data[key] = value
+	}
+
+	return data, nil
+}
+
+func decodeQueryValue(fieldType string, values []string) (interface{}, error) {
+	switch fieldType {
+	case "boolean":
+		return strconv.ParseBool(values[0])
+	case "integer":
+		return strconv.ParseInt(values[0], 10, 64)
+	case "number":
+		return strconv.ParseFloat(values[0], 64)
+	case "array":
+		return values, nil
+	case "object":
+		return nil, errors.New("objects can be sent with POST only")
+	default:
+		return values[0], nil
+	}
+}
+
+// resolveAction maps the action from the URL to the registered one: actions are matched case-insensitively
+// and the "get" prefix may be omitted, e.g. /v1/main/vhosts executes main.getVhosts
+func (g *HttpGateway) resolveAction(module, action string) string {
+	actions := g.Server.Router.GetActions(module)
+
+	for _, name := range actions {
+		if name == action {
+			return name
+		}
+	}
+
+	for _, name := range actions {
+		if strings.EqualFold(name, action) || strings.EqualFold(name, "get"+action) {
+			return name
+		}
+	}
+
+	return action
+}
+
+func (g *HttpGateway) writeResponse(w http.ResponseWriter, response router.Response, status int) {
+	if response.Error != "" {
+		g.Server.Logger.Error(response.Error)
+	}
+
+	data, encodeErr := json.Marshal(response)
+
+	if encodeErr != nil {
+		err := fmt.Errorf("could not encode response data: %v", encodeErr)
+		response = g.Server.prepareResponse(nil, nil, err)
+		status = getHttpStatus(err)
+		data, _ = json.Marshal(response)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (g *HttpGateway) handleOpenApi(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(getOpenApiDocument(&g.Server.Router, g.Server.Config.Version))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func getHttpStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	switch router.GetError(err).Code {
+	case router.ErrorCodeInvalidRequest:
+		return http.StatusBadRequest
+	case router.ErrorCodeUnauthorized:
+		return http.StatusUnauthorized
+	case router.ErrorCodeForbidden:
+		return http.StatusForbidden
+	case router.ErrorCodeNotFound:
+		return http.StatusNotFound
+	case router.ErrorCodeRequestTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case router.ErrorCodeRateLimited:
+		return http.StatusTooManyRequests
+	case router.ErrorCodeShuttingDown:
+		return http.StatusServiceUnavailable
+	case router.ErrorCodeTimeout:
+		return http.StatusGatewayTimeout
+	case router.ErrorCodeCancelled:
+		// Non-standard status used by nginx for requests closed by the client
+		return 499
+	case router.ErrorCodeAcmeValidationFailed:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// connLimitListener acquires a connection slot of the server for each accepted connection. Connections exceeding
+// the limit get the rate limited error response and are closed.
+type connLimitListener struct {
+	net.Listener
+	server    *Server
+	tlsConfig *tls.Config
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if l.server.acquireConnSlot() {
+			return &slotConn{Conn: conn, server: l.server}, nil
+		}
+
+		l.server.rejectedConns.Add(1)
+		l.server.Logger.Warning("HTTP connection from %v is rejected: maximum of %d concurrent connections is reached", conn.RemoteAddr(), cap(l.server.connSlots))
+		go l.reject(conn)
+	}
+}
+
+// reject reads the request of the connection and responds with 429 Too Many Requests
+func (l *connLimitListener) reject(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if l.tlsConfig != nil {
+		conn = tls.Server(conn, l.tlsConfig)
+	}
+
+	if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+		return
+	}
+
+	response := l.server.prepareResponse(nil, nil, router.NewError(router.ErrorCodeRateLimited, "too many concurrent connections"))
+	data, _ := json.Marshal(response)
+	fmt.Fprintf(conn, "HTTP/1.1 429 Too Many Requests\r\nContent-Type: application/json\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(data), data)
+}
+
+// slotConn releases the connection slot when the connection is closed
+type slotConn struct {
+	net.Conn
+	server *Server
+	once   sync.Once
+}
+
+func (c *slotConn) Close() error {
+	c.once.Do(c.server.releaseConnSlot)
+
+	return c.Conn.Close()
+}
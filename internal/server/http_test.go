@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpGatewayCommands(t *testing.T) {
+	server := getTestServer(t)
+	monitoringHash, err := auth.HashSecret("monitoring")
+	assert.Nil(t, err)
+	server.Config.Tokens = []config.ApiToken{{Name: "monitoring", Hash: monitoringHash, Scopes: []string{"test.getStatus"}}}
+	httpServer := httptest.NewServer((&HttpGateway{Server: server}).Handler())
+	defer httpServer.Close()
+
+	type testData struct {
+		method, path, token, body string
+		status                    int
+		data                      interface{}
+	}
+	items := []testData{
+		{http.MethodPost, "/v1/test/echo", testToken, `{"name":"value"}`, http.StatusOK, map[string]interface{}{"name": "value"}},
+		{http.MethodGet, "/v1/test/echo?name=value", testToken, "", http.StatusOK, map[string]interface{}{"name": "value"}},
+		{http.MethodGet, "/v1/test/status", "monitoring", "", http.StatusOK, "up"},
+		{http.MethodPost, "/v1/test/echo", "monitoring", "", http.StatusForbidden, nil},
+		{http.MethodPost, "/v1/test/echo", "wrong", "", http.StatusUnauthorized, nil},
+		{http.MethodPost, "/v1/test/unknown", testToken, "", http.StatusNotFound, nil},
+		{http.MethodPost, "/v1/unknown/echo", testToken, "", http.StatusNotFound, nil},
+		{http.MethodPost, "/v1/test/echo", testToken, "{", http.StatusBadRequest, nil},
+	}
+
+	for _, item := range items {
+		request, err := http.NewRequest(item.method, httpServer.URL+item.path, strings.NewReader(item.body))
+		assert.Nil(t, err)
+		request.Header.Set("Authorization", "Bearer "+item.token)
+
+		httpResponse, err := http.DefaultClient.Do(request)
+		assert.Nil(t, err)
+
+		var response router.Response
+		assert.Nil(t, json.NewDecoder(httpResponse.Body).Decode(&response))
+		httpResponse.Body.Close()
+
+		assert.Equalf(t, item.status, httpResponse.StatusCode, "%s %s: %s", item.method, item.path, response.Error)
+		assert.Equalf(t, item.data, response.Data, "%s %s", item.method, item.path)
+	}
+}
+
+type typedRequestData struct {
+	Count   int
+	Enabled bool
+	Names   []string
+}
+
+func TestHttpGatewayQueryAndMethods(t *testing.T) {
+	server := getTestServer(t)
+	echo := func(ctx context.Context, request router.Request, data typedRequestData) (typedRequestData, error) {
+		return data, nil
+	}
+	server.Router.RegisterHandler("typed", router.NewModule(
+		router.NewAction("getValues", "", echo),
+		router.NewAction("setValues", "", echo).Mutates(),
+	))
+	httpServer := httptest.NewServer((&HttpGateway{Server: server}).Handler())
+	defer httpServer.Close()
+
+	values := map[string]interface{}{"Count": float64(2), "Enabled": true, "Names": []interface{}{"a", "b"}}
+	items := []struct {
+		method, path string
+		status       int
+		data         interface{}
+	}{
+		{http.MethodGet, "/v1/typed/values?count=2&enabled=true&names=a&names=b", http.StatusOK, values},
+		{http.MethodGet, "/v1/typed/values?count=two", http.StatusBadRequest, nil},
+		{http.MethodGet, "/v1/typed/setValues?count=2&enabled=true&names=a&names=b", http.StatusMethodNotAllowed, nil},
+		{http.MethodPost, "/v1/typed/setValues", http.StatusOK, values},
+	}
+
+	for _, item := range items {
+		body := `{"count":2,"enabled":true,"names":["a","b"]}`
+
+		if item.method == http.MethodGet {
+			body = ""
+		}
+
+		request, err := http.NewRequest(item.method, httpServer.URL+item.path, strings.NewReader(body))
+		assert.Nil(t, err)
+		request.Header.Set("Authorization", "Bearer "+testToken)
+
+		httpResponse, err := http.DefaultClient.Do(request)
+		assert.Nil(t, err)
+
+		var response router.Response
+		assert.Nil(t, json.NewDecoder(httpResponse.Body).Decode(&response))
+		httpResponse.Body.Close()
+
+		assert.Equalf(t, item.status, httpResponse.StatusCode, "%s %s: %s", item.method, item.path, response.Error)
+		assert.Equalf(t, item.data, response.Data, "%s %s", item.method, item.path)
+
+		if item.status == http.StatusMethodNotAllowed {
+			assert.Equal(t, http.MethodPost, httpResponse.Header.Get("Allow"))
+		}
+	}
+}
+
+func TestHttpGatewayMaxConnections(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.TlsDisabled = true
+	server.Config.MaxConnections = 1
+	gateway := &HttpGateway{Server: server}
+	listener, err := gateway.listen("127.0.0.1:0")
+	assert.Nil(t, err)
+	go gateway.serve(listener)
+	defer gateway.Shutdown(context.Background())
+
+	// The slot is taken by a connection of the TCP server
+	assert.True(t, server.acquireConnSlot())
+
+	url := "http://" + listener.Addr().String() + "/v1/test/getStatus"
+	httpClient := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.Nil(t, err)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+
+	httpResponse, err := httpClient.Do(request)
+	assert.Nil(t, err)
+	httpResponse.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, httpResponse.StatusCode)
+	assert.Equal(t, int64(1), server.GetStats().RejectedConnections)
+
+	server.releaseConnSlot()
+
+	httpResponse, err = httpClient.Do(request)
+	assert.Nil(t, err)
+	httpResponse.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResponse.StatusCode)
+}
+
+func TestGetHttpStatus(t *testing.T) {
+	assert.Equal(t, http.StatusOK, getHttpStatus(nil))
+	assert.Equal(t, http.StatusUnauthorized, getHttpStatus(router.NewError(router.ErrorCodeUnauthorized, "unauthorized")))
+	assert.Equal(t, http.StatusGatewayTimeout, getHttpStatus(router.NewError(router.ErrorCodeTimeout, "timed out")))
+	assert.Equal(t, 499, getHttpStatus(router.NewError(router.ErrorCodeCancelled, "cancelled")))
+	assert.Equal(t, http.StatusUnprocessableEntity, getHttpStatus(router.NewError(router.ErrorCodeAcmeValidationFailed, "validation failed")))
+	assert.Equal(t, http.StatusInternalServerError, getHttpStatus(router.NewError(router.ErrorCodeDeployFailed, "deploy failed")))
+}
+
+func TestHttpGatewaySignedRequest(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.RequestSigningRequired = true
+	server.Config.SignatureMaxAge = time.Minute
+	server.Config.NonceCacheSize = 10
+	httpServer := httptest.NewServer((&HttpGateway{Server: server}).Handler())
+	defer httpServer.Close()
+
+	body := `{"name":"value"}`
+	timestamp := time.Now().Unix()
+
+	for _, status := range []int{http.StatusOK, http.StatusUnauthorized} {
+		request, err := http.NewRequest(http.MethodPost, httpServer.URL+"/v1/test/echo", strings.NewReader(body))
+		assert.Nil(t, err)
+		request.Header.Set(httpKeyIdHeader, auth.DefaultIdentity)
+		request.Header.Set(httpTimestampHeader, strconv.FormatInt(timestamp, 10))
+		request.Header.Set(httpNonceHeader, "nonce")
+		request.Header.Set(httpSignatureHeader, protocol.SignRequest(testToken, protocol.SignedRequest{
+			KeyId:     auth.DefaultIdentity,
+			Command:   "test.echo",
+			Timestamp: timestamp,
+			Nonce:     "nonce",
+			Data:      []byte(body),
+		}))
+
+		httpResponse, err := http.DefaultClient.Do(request)
+		assert.Nil(t, err)
+		httpResponse.Body.Close()
+
+		// The nonce can not be reused
+		assert.Equal(t, status, httpResponse.StatusCode)
+	}
+}
+
+func TestHttpGatewayOpenApi(t *testing.T) {
+	httpServer := httptest.NewServer((&HttpGateway{Server: getTestServer(t)}).Handler())
+	defer httpServer.Close()
+
+	httpResponse, err := http.Get(httpServer.URL + "/v1/openapi.json")
+	assert.Nil(t, err)
+	defer httpResponse.Body.Close()
+
+	data, err := io.ReadAll(httpResponse.Body)
+	assert.Nil(t, err)
+
+	var document struct {
+		Paths map[string]interface{} `json:"paths"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &document))
+	assert.Contains(t, document.Paths, "/v1/test/echo")
+	assert.Contains(t, document.Paths, "/v1/test/getStatus")
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/r2dtools/sslbot/pkg/protocol"
+)
+
+// maxTrackedClients is the maximum number of clients tracked by the limiter. When it is reached, idle entries
+// are removed first and then the oldest ones.
+const maxTrackedClients = 1024
+
+type LockedClient = protocol.LockedClient
+
+type LimiterStats = protocol.LimiterStats
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type authFailures struct {
+	count       int
+	firstAt     time.Time
+	lockedUntil time.Time
+}
+
+// limiter applies per client IP token bucket rate limiting and locks clients out after repeated authentication failures
+type limiter struct {
+	mu              sync.Mutex
+	rate            float64
+	burst           int
+	maxAuthFailures int
+	lockoutDuration time.Duration
+	buckets         map[string]*tokenBucket
+	failures        map[string]*authFailures
+	stats           LimiterStats
+}
+
+func newLimiter(rate float64, burst, maxAuthFailures int, lockoutDuration time.Duration) *limiter {
+	return &limiter{
+		rate:            rate,
+		burst:           burst,
+		maxAuthFailures: maxAuthFailures,
+		lockoutDuration: lockoutDuration,
+		buckets:         make(map[string]*tokenBucket),
+		failures:        make(map[string]*authFailures),
+	}
+}
+
+// allow takes a token from the client bucket. Rate limiting is disabled if the rate is not positive.
+func (l *limiter) allow(ip string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[ip]
+
+	if !ok {
+		if len(l.buckets) >= maxTrackedClients {
+			l.removeIdleBuckets(now)
+			l.removeOldestBucket()
+		}
+
+		bucket = &tokenBucket{tokens: float64(l.burst), updatedAt: now}
+		l.buckets[ip] = bucket
+	}
+
+	bucket.tokens = min(float64(l.burst), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*l.rate)
+	bucket.updatedAt = now
+
+	if bucket.tokens < 1 {
+		l.stats.RateLimitedRequests++
+
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
+
+// lockedUntil returns the time until which the client is locked out or zero time if it is not
+func (l *limiter) lockedUntil(ip string, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	failures, ok := l.failures[ip]
+
+	if !ok || !now.Before(failures.lockedUntil) {
+		return time.Time{}
+	}
+
+	l.stats.LockedOutRequests++
+
+	return failures.lockedUntil
+}
+
+// authFailed registers an authentication failure and returns true if the client gets locked out. Successful
+// authentications do not reset the failures, so a client can not interleave valid requests with guesses.
+func (l *limiter) authFailed(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats.AuthFailures++
+
+	if l.maxAuthFailures <= 0 {
+		return false
+	}
+
+	failures, ok := l.failures[ip]
+
+	// Failures are counted within the lockout window
+	if !ok || now.Sub(failures.firstAt) > l.lockoutDuration {
+		if len(l.failures) >= maxTrackedClients {
+			l.removeExpiredFailures(now)
+			l.removeOldestFailures(now)
+		}
+
+		failures = &authFailures{firstAt: now}
+		l.failures[ip] = failures
+	}
+
+	failures.count++
+
+	if failures.count < l.maxAuthFailures {
+		return false
+	}
+
+	failures.lockedUntil = now.Add(l.lockoutDuration)
+	failures.count = 0
+	failures.firstAt = now
+
+	return true
+}
+
+// handshakeFailed counts a connection rejected in the TLS handshake because of the client certificate.
+// The client IP is not known to be the one that sent the requests, so it is not locked out.
+func (l *limiter) handshakeFailed() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats.AuthFailures++
+}
+
+func (l *limiter) getStats(now time.Time) LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.stats
+	stats.LockedClients = []LockedClient{}
+
+	for ip, failures := range l.failures {
+		if now.Before(failures.lockedUntil) {
+			stats.LockedClients = append(stats.LockedClients, LockedClient{Ip: ip, LockedUntil: failures.lockedUntil})
+		}
+	}
+
+	return stats
+}
+
+func (l *limiter) removeIdleBuckets(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+func (l *limiter) removeExpiredFailures(now time.Time) {
+	for ip, failures := range l.failures {
+		if now.Sub(failures.firstAt) > l.lockoutDuration && !now.Before(failures.lockedUntil) {
+			delete(l.failures, ip)
+		}
+	}
+}
+
+// removeOldestBucket removes the least recently used bucket if the limit of tracked clients is still reached
+func (l *limiter) removeOldestBucket() {
+	if len(l.buckets) < maxTrackedClients {
+		return
+	}
+
+	var oldestIp string
+
+	for ip, bucket := range l.buckets {
+		if oldestIp == "" || bucket.updatedAt.Before(l.buckets[oldestIp].updatedAt) {
+			oldestIp = ip
+		}
+	}
+
+	delete(l.buckets, oldestIp)
+}
+
+// removeOldestFailures removes the failures counted first if the limit of tracked clients is still reached.
+// Clients that are not locked out are removed before the locked out ones.
+func (l *limiter) removeOldestFailures(now time.Time) {
+	if len(l.failures) < maxTrackedClients {
+		return
+	}
+
+	var oldestIp string
+
+	for ip, failures := range l.failures {
+		if oldestIp == "" {
+			oldestIp = ip
+
+			continue
+		}
+
+		oldest := l.failures[oldestIp]
+		locked, oldestLocked := now.Before(failures.lockedUntil), now.Before(oldest.lockedUntil)
+
+		if (oldestLocked && !locked) || (locked == oldestLocked && failures.firstAt.Before(oldest.firstAt)) {
+			oldestIp = ip
+		}
+	}
+
+	delete(l.failures, oldestIp)
+}
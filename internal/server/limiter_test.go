@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterRateLimit(t *testing.T) {
+	now := time.Now()
+	limiter := newLimiter(1, 2, 0, time.Minute)
+
+	assert.True(t, limiter.allow("10.0.0.1", now))
+	assert.True(t, limiter.allow("10.0.0.1", now))
+	assert.False(t, limiter.allow("10.0.0.1", now))
+	assert.True(t, limiter.allow("10.0.0.2", now))
+	assert.True(t, limiter.allow("10.0.0.1", now.Add(time.Second)))
+	assert.Equal(t, int64(1), limiter.getStats(now).RateLimitedRequests)
+}
+
+func TestLimiterLockout(t *testing.T) {
+	now := time.Now()
+	limiter := newLimiter(0, 0, 3, time.Minute)
+
+	assert.False(t, limiter.authFailed("10.0.0.1", now))
+	assert.False(t, limiter.authFailed("10.0.0.1", now))
+	assert.True(t, limiter.lockedUntil("10.0.0.1", now).IsZero())
+	assert.True(t, limiter.authFailed("10.0.0.1", now))
+	assert.Equal(t, now.Add(time.Minute), limiter.lockedUntil("10.0.0.1", now))
+	assert.True(t, limiter.lockedUntil("10.0.0.2", now).IsZero())
+
+	stats := limiter.getStats(now)
+	assert.Equal(t, int64(3), stats.AuthFailures)
+	assert.Equal(t, []LockedClient{{Ip: "10.0.0.1", LockedUntil: now.Add(time.Minute)}}, stats.LockedClients)
+
+	assert.True(t, limiter.lockedUntil("10.0.0.1", now.Add(2*time.Minute)).IsZero())
+	assert.Empty(t, limiter.getStats(now.Add(2*time.Minute)).LockedClients)
+
+	// Failures are counted until the window ends, successful authentications in between do not reset them
+	limiter.authFailed("10.0.0.3", now)
+	limiter.authFailed("10.0.0.3", now.Add(30*time.Second))
+	assert.True(t, limiter.authFailed("10.0.0.3", now.Add(50*time.Second)))
+	assert.False(t, limiter.authFailed("10.0.0.4", now))
+	assert.False(t, limiter.authFailed("10.0.0.4", now.Add(2*time.Minute)))
+}
+
+func TestLimiterTrackedClientsAreCapped(t *testing.T) {
+	now := time.Now()
+	limiter := newLimiter(0.001, 2, 3, time.Minute)
+
+	for i := range maxTrackedClients + 10 {
+		ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		// The buckets refill slowly, so there are no idle entries to remove
+		limiter.allow(ip, now.Add(time.Duration(i)*time.Millisecond))
+		limiter.authFailed(ip, now.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	assert.Equal(t, maxTrackedClients, len(limiter.buckets))
+	assert.Equal(t, maxTrackedClients, len(limiter.failures))
+	// The oldest clients are removed
+	_, ok := limiter.buckets["10.0.0.0"]
+	assert.False(t, ok)
+	_, ok = limiter.failures["10.0.0.0"]
+	assert.False(t, ok)
+	_, ok = limiter.buckets[fmt.Sprintf("10.0.%d.%d", (maxTrackedClients+9)/256, (maxTrackedClients+9)%256)]
+	assert.True(t, ok)
+
+	// Locked out clients are kept while there are clients that are not locked out
+	limiter.authFailed("10.0.3.255", now.Add(time.Second))
+	assert.True(t, limiter.authFailed("10.0.3.255", now.Add(time.Second)))
+	limiter.authFailed("192.168.0.1", now.Add(2*time.Second))
+	_, ok = limiter.failures["10.0.3.255"]
+	assert.True(t, ok)
+}
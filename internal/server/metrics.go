@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
+)
+
+const (
+	metricsPath = "/metrics"
+	healthPath  = "/health"
+	// healthCacheTtl limits how often unauthenticated probes run health checks, which start the ACME client and parse
+	// the webserver configuration
+	healthCacheTtl = 10 * time.Second
+)
+
+// MetricsServer serves metrics of the registry in the Prometheus exposition format on GET /metrics and the health of the agent
+// on GET /health. They are not authenticated and are served over plain HTTP, so the port should be reachable by
+// the monitoring system only.
+type MetricsServer struct {
+	Port     int
+	Registry *metrics.Registry
+	Logger   logger.Logger
+	// Config is used by health checks, GET /health is not served if it is nil
+	Config     *config.Config
+	mu         sync.Mutex
+	httpServer *http.Server
+	// healthMu is held while the health is checked, so concurrent probes share one run
+	healthMu        sync.Mutex
+	health          healthSummary
+	healthCheckedAt time.Time
+}
+
+func (m *MetricsServer) Serve() error {
+	port := strconv.Itoa(m.Port)
+	m.Logger.Info("starting metrics listener on port %s ...", port)
+	listener, err := net.Listen("tcp", ":"+port)
+
+	if err != nil {
+		m.Logger.Error("error starting metrics listener: %v", err)
+		return err
+	}
+
+	m.Logger.Info("metrics listener successfully started")
+
+	return m.serve(listener)
+}
+
+// Shutdown stops the listener and waits for in-flight scrapes until the context is done
+func (m *MetricsServer) Shutdown(ctx context.Context) error {
+	m.Logger.Info("shutting down metrics listener")
+
+	return m.getHttpServer().Shutdown(ctx)
+}
+
+func (m *MetricsServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET "+metricsPath, m.Registry.Handler())
+
+	if m.Config != nil {
+		mux.HandleFunc("GET "+healthPath, m.handleHealth)
+	}
+
+	return mux
+}
+
+// healthSummary is the response of GET /health. The endpoint is not authenticated, so the results of separate checks,
+// e.g. paths and versions, are only returned by the authenticated main.health command.
+type healthSummary struct {
+	Status string
+}
+
+// handleHealth responds with 200 if all health checks passed and with 503 otherwise, so it can be used as a readiness probe
+func (m *MetricsServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := m.getHealth(r.Context())
+	statusCode := http.StatusOK
+
+	if health.Status != HealthStatusOk {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(health)
+}
+
+// getHealth returns the health checked less than healthCacheTtl ago or checks it again
+func (m *MetricsServer) getHealth(ctx context.Context) healthSummary {
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+
+	if !m.healthCheckedAt.IsZero() && time.Since(m.healthCheckedAt) < healthCacheTtl {
+		return m.health
+	}
+
+	// The result is shared with other probes, so it must not fail because the probe that started the checks disconnected
+	m.health = healthSummary{Status: CheckHealth(context.WithoutCancel(ctx), m.Config, m.Logger).Status}
+	m.healthCheckedAt = time.Now()
+
+	return m.health
+}
+
+func (m *MetricsServer) serve(listener net.Listener) error {
+	err := m.getHttpServer().Serve(listener)
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (m *MetricsServer) getHttpServer() *http.Server {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.httpServer == nil {
+		m.httpServer = &http.Server{
+			Handler:           m.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+
+	return m.httpServer
+}
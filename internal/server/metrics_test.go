@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/r2dtools/sslbot/internal/pkg/certificate"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsServer(t *testing.T) {
+	metricsServer := httptest.NewServer((&MetricsServer{Registry: metrics.Default, Logger: &logger.NilLogger{}}).Handler())
+	defer metricsServer.Close()
+	gateway := httptest.NewServer((&HttpGateway{Server: getTestServer(t)}).Handler())
+	defer gateway.Close()
+
+	authFailures := getMetricValue(t, metricsServer.URL, "sslbot_auth_failures_total")
+
+	request, err := http.NewRequest(http.MethodPost, gateway.URL+"/v1/test/echo", nil)
+	assert.Nil(t, err)
+	request.Header.Set("Authorization", "Bearer invalid")
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	assert.Equal(t, authFailures+1, getMetricValue(t, metricsServer.URL, "sslbot_auth_failures_total"))
+
+	response, err = http.Post(metricsServer.URL+"/metrics", "text/plain", nil)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+}
+
+func TestClientCertificateFailureIsCounted(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.VarDir = t.TempDir()
+	caPem, _, err := certificate.GenerateSelfSignedCertificate("client-ca", []string{"client-ca"})
+	assert.Nil(t, err)
+	server.Config.TlsClientCaFile = filepath.Join(server.Config.VarDir, "client-ca.crt")
+	assert.Nil(t, os.WriteFile(server.Config.TlsClientCaFile, caPem, 0644))
+
+	listener, err := server.listen("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	handled := make(chan struct{})
+
+	go func() {
+		defer close(handled)
+		conn, err := listener.Accept()
+
+		if err == nil {
+			server.handleConn(conn)
+		}
+	}()
+
+	authFailures := getCounterValue(t, metrics.AuthFailures)
+	// The client has no certificate, so the server rejects the handshake
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+
+	if err == nil {
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+
+	<-handled
+	assert.Equal(t, authFailures+1, getCounterValue(t, metrics.AuthFailures))
+	assert.Equal(t, int64(1), server.GetStats().LimiterStats.AuthFailures)
+}
+
+func TestHttpGatewayClientCertificateFailureIsCounted(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.VarDir = t.TempDir()
+	caPem, _, err := certificate.GenerateSelfSignedCertificate("client-ca", []string{"client-ca"})
+	assert.Nil(t, err)
+	server.Config.TlsClientCaFile = filepath.Join(server.Config.VarDir, "client-ca.crt")
+	assert.Nil(t, os.WriteFile(server.Config.TlsClientCaFile, caPem, 0644))
+
+	gateway := &HttpGateway{Server: server}
+	listener, err := gateway.listen("127.0.0.1:0")
+	assert.Nil(t, err)
+	go gateway.serve(listener)
+	defer gateway.Shutdown(context.Background())
+
+	authFailures := getCounterValue(t, metrics.AuthFailures)
+	// The client has no certificate, so the server rejects the handshake
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	response, err := httpClient.Get("https://" + listener.Addr().String() + "/v1/test/getStatus")
+
+	if err == nil {
+		response.Body.Close()
+	}
+
+	assert.NotNil(t, err)
+	assert.Eventually(t, func() bool {
+		return server.GetStats().LimiterStats.AuthFailures == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, authFailures+1, getCounterValue(t, metrics.AuthFailures))
+}
+
+func getCounterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var metric dto.Metric
+	assert.Nil(t, counter.Write(&metric))
+
+	return metric.GetCounter().GetValue()
+}
+
+// getMetricValue scrapes the metrics and returns the value of the sample
+func getMetricValue(t *testing.T, url, sample string) float64 {
+	response, err := http.Get(url + "/metrics")
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/plain"))
+
+	body, err := io.ReadAll(response.Body)
+	assert.Nil(t, err)
+
+	for _, line := range strings.Split(string(body), "\n") {
+		if value, ok := strings.CutPrefix(line, sample+" "); ok {
+			parsedValue, err := strconv.ParseFloat(value, 64)
+			assert.Nil(t, err)
+
+			return parsedValue
+		}
+	}
+
+	t.Fatalf("sample %s is not found", sample)
+
+	return 0
+}
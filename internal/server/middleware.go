@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+)
+
+// unknownCommand is the command label of requests for actions that do not exist, so clients can not create arbitrary labels
+const unknownCommand = "unknown"
+
+// MetricsMiddleware counts commands by their error code and observes their duration. hasAction reports whether
+// the requested action exists.
+func MetricsMiddleware(hasAction func(request router.Request) bool) router.Middleware {
+	return func(next router.HandleFunc) router.HandleFunc {
+		return func(ctx context.Context, request router.Request) (interface{}, error) {
+			start := time.Now()
+			response, err := next(ctx, request)
+			command := request.GetCommand()
+
+			if !hasAction(request) {
+				command = unknownCommand
+			}
+
+			code := "ok"
+
+			if err != nil {
+				code = router.GetError(err).Code
+			}
+
+			metrics.Requests.WithLabelValues(command, code).Inc()
+			metrics.RequestDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+
+			return response, err
+		}
+	}
+}
+
+// AuditMiddleware records mutating commands with their outcome in the audit log. Read-only commands are not recorded.
+func AuditMiddleware(auditLog *audit.Log, logger logger.Logger, isMutating func(request router.Request) bool) router.Middleware {
+	return func(next router.HandleFunc) router.HandleFunc {
+		return func(ctx context.Context, request router.Request) (interface{}, error) {
+			if !isMutating(request) {
+				return next(ctx, request)
+			}
+
+			entry := audit.Entry{
+				Time:          time.Now(),
+				RemoteAddress: request.RemoteAddress,
+				TokenName:     request.TokenName,
+				ClientSubject: request.ClientSubject,
+				Command:       request.GetCommand(),
+				Params:        audit.SanitizeParams(request.Data),
+				Status:        audit.StatusOk,
+			}
+			record := &audit.Record{}
+			response, err := next(audit.WithRecord(ctx, record), request)
+			record.Fill(&entry)
+
+			if err != nil {
+				entry.Status = audit.StatusError
+				entry.ErrorCode = router.GetError(err).Code
+				entry.Error = err.Error()
+			}
+
+			if auditErr := auditLog.Append(entry); auditErr != nil {
+				logger.Error("could not record command '%s' in the audit log: %v", entry.Command, auditErr)
+			}
+
+			return response, err
+		}
+	}
+}
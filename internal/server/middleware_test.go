@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	auditLog := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	r := router.Router{}
+	r.RegisterHandler("test", router.NewModule(
+		router.NewActionWithoutData("list", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+			return nil, nil
+		}),
+		router.NewAction("assign", "", func(ctx context.Context, request router.Request, data map[string]interface{}) (interface{}, error) {
+			audit.GetRecord(ctx).SetVhost("example.com")
+			audit.GetRecord(ctx).AddFiles("/etc/nginx/sites-enabled/example.com")
+
+			return nil, nil
+		}).Mutates(),
+		router.NewActionWithoutData("fail", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+			return nil, router.NewError(router.ErrorCodeDeployFailed, "could not deploy certificate")
+		}).Mutates(),
+	))
+	r.Use(AuditMiddleware(auditLog, &logger.NilLogger{}, r.IsMutating))
+
+	_, err := r.HandleRequest(context.Background(), router.Request{Command: "test.list"})
+	assert.Nil(t, err)
+	_, err = r.HandleRequest(context.Background(), router.Request{
+		Command:       "test.assign",
+		RemoteAddress: "127.0.0.1:5000",
+		TokenName:     "panel",
+		Data:          map[string]interface{}{"ServerName": "example.com", "PemCertificate": "-----BEGIN CERTIFICATE-----"},
+	})
+	assert.Nil(t, err)
+	_, err = r.HandleRequest(context.Background(), router.Request{Command: "test.fail"})
+	assert.Equal(t, router.ErrorCodeDeployFailed, router.GetError(err).Code)
+
+	entries, err := auditLog.Query(audit.Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "test.fail", entries[0].Command)
+	assert.Equal(t, audit.StatusError, entries[0].Status)
+	assert.Equal(t, router.ErrorCodeDeployFailed, entries[0].ErrorCode)
+	assert.Equal(t, "could not deploy certificate", entries[0].Error)
+	assert.Equal(t, "test.assign", entries[1].Command)
+	assert.Equal(t, audit.StatusOk, entries[1].Status)
+	assert.Equal(t, "127.0.0.1:5000", entries[1].RemoteAddress)
+	assert.Equal(t, "panel", entries[1].TokenName)
+	assert.Equal(t, "example.com", entries[1].Vhost)
+	assert.Equal(t, []string{"/etc/nginx/sites-enabled/example.com"}, entries[1].Files)
+	assert.Equal(t, map[string]interface{}{"ServerName": "example.com", "PemCertificate": "[redacted]"}, entries[1].Params)
+}
+
+func TestAuditMiddlewareRecordsOutcomeOfTimedOutCommand(t *testing.T) {
+	auditLog := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	r := router.Router{}
+	r.RegisterHandler("test", router.NewModule(
+		router.NewActionWithoutData("deploy", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+			<-ctx.Done()
+			// The changes are rolled back before the handler returns
+			time.Sleep(20 * time.Millisecond)
+			audit.GetRecord(ctx).SetVhost("example.com")
+
+			return nil, ctx.Err()
+		}).Mutates(),
+		router.NewActionWithoutData("reload", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+			// The reload is finished after the deadline, so the command succeeded
+			time.Sleep(50 * time.Millisecond)
+
+			return nil, nil
+		}).Mutates(),
+	))
+	r.Use(
+		AuditMiddleware(auditLog, &logger.NilLogger{}, r.IsMutating),
+		router.TimeoutMiddleware(func(request router.Request) time.Duration {
+			return 10 * time.Millisecond
+		}),
+	)
+
+	_, err := r.HandleRequest(context.Background(), router.Request{Command: "test.deploy"})
+	assert.Equal(t, router.ErrorCodeTimeout, router.GetError(err).Code)
+	_, err = r.HandleRequest(context.Background(), router.Request{Command: "test.reload"})
+	assert.Nil(t, err)
+
+	entries, err := auditLog.Query(audit.Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "test.reload", entries[0].Command)
+	assert.Equal(t, audit.StatusOk, entries[0].Status)
+	assert.Equal(t, "test.deploy", entries[1].Command)
+	assert.Equal(t, audit.StatusError, entries[1].Status)
+	assert.Equal(t, router.ErrorCodeTimeout, entries[1].ErrorCode)
+	assert.Equal(t, "example.com", entries[1].Vhost)
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := router.Router{}
+	r.RegisterHandler("metrics", router.NewModule(
+		router.NewActionWithoutData("list", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+			return nil, nil
+		}),
+		router.NewActionWithoutData("fail", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+			return nil, router.NewError(router.ErrorCodeDeployFailed, "could not deploy certificate")
+		}),
+	))
+	r.Use(MetricsMiddleware(r.HasAction))
+
+	for _, command := range []string{"metrics.list", "metrics.list", "metrics.fail", "metrics.missing", "metrics.other"} {
+		r.HandleRequest(context.Background(), router.Request{Command: command})
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Default.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	output := recorder.Body
+	assert.Contains(t, output.String(), `sslbot_requests_total{code="ok",command="metrics.list"} 2`+"\n")
+	assert.Contains(t, output.String(), `sslbot_requests_total{code="deploy_failed",command="metrics.fail"} 1`+"\n")
+	// Unknown actions share the label, so clients can not create arbitrary series
+	assert.Contains(t, output.String(), `sslbot_requests_total{code="not_found",command="unknown"} 2`+"\n")
+	assert.Contains(t, output.String(), `sslbot_request_duration_seconds_count{command="metrics.list"} 2`+"\n")
+	assert.NotContains(t, output.String(), "metrics.missing")
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+)
+
+// getOpenApiDocument describes the HTTP gateway endpoints of all actions registered in the router
+func getOpenApiDocument(r *router.Router, version string) map[string]interface{} {
+	paths := make(map[string]interface{})
+	errorResponse := map[string]interface{}{
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Response"},
+			},
+		},
+	}
+	responses := map[string]interface{}{
+		"200": withDescription(errorResponse, "Command is executed"),
+		"400": withDescription(errorResponse, "Request is malformed"),
+		"401": withDescription(errorResponse, "Token or signature is invalid"),
+		"403": withDescription(errorResponse, "Token is not allowed to execute the command"),
+		"404": withDescription(errorResponse, "Command does not exist"),
+		"413": withDescription(errorResponse, "Request is too large"),
+		"422": withDescription(errorResponse, "ACME validation of the domains failed"),
+		"429": withDescription(errorResponse, "Rate limit is exceeded or the client is locked out"),
+		"499": withDescription(errorResponse, "Command is cancelled, e.g. the client disconnected"),
+		"500": withDescription(errorResponse, "Command failed"),
+		"503": withDescription(errorResponse, "Agent is shutting down"),
+		"504": withDescription(errorResponse, "Command is not finished in time"),
+	}
+
+	parameters := []interface{}{
+		map[string]interface{}{
+			"name":        httpVersionHeader,
+			"in":          "header",
+			"description": "Protocol version of the response",
+			"schema":      map[string]interface{}{"type": "integer", "default": 1},
+		},
+	}
+
+	for _, module := range r.GetModules() {
+		for _, action := range r.DescribeActions(module) {
+			command := module + "." + action.Name
+			operations := map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": command,
+					"tags":        []string{module},
+					"summary":     "Executes " + command + " with JSON body as request data",
+					"description": action.Description,
+					"parameters":  parameters,
+					"requestBody": map[string]interface{}{
+						"required": false,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": getRequestSchema(action)},
+						},
+					},
+					"responses": responses,
+				},
+			}
+
+			// Commands changing the server are accepted with POST only
+			if !action.Mutating {
+				operations["get"] = map[string]interface{}{
+					"operationId": command + ".get",
+					"tags":        []string{module},
+					"summary":     "Executes " + command + " with query parameters as request data",
+					"description": action.Description,
+					"parameters":  parameters,
+					"responses":   responses,
+				}
+			}
+
+			paths[httpApiPrefix+module+"/"+action.Name] = operations
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "SSLBot agent API",
+			"version": version,
+		},
+		"paths":    paths,
+		"security": []interface{}{map[string]interface{}{"bearerAuth": []string{}}},
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": map[string]interface{}{
+				"Response": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"Version": map[string]interface{}{"type": "integer", "description": "Sent if protocol version 2 or later is requested"},
+						"Status":  map[string]interface{}{"type": "string", "enum": []string{"ok", "error"}},
+						"Error": map[string]interface{}{
+							"description": "Error message in protocol version 1, error object in later versions",
+							"oneOf": []interface{}{
+								map[string]interface{}{"type": "string"},
+								map[string]interface{}{"$ref": "#/components/schemas/Error"},
+							},
+						},
+						"Data": map[string]interface{}{},
+					},
+				},
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"Code":    map[string]interface{}{"type": "string"},
+						"Message": map[string]interface{}{"type": "string"},
+						"Details": map[string]interface{}{},
+					},
+				},
+			},
+		},
+	}
+}
+
+// getRequestSchema describes the request data of the action. Any data is allowed for actions of handlers
+// that can not describe their fields.
+func getRequestSchema(action router.ActionInfo) map[string]interface{} {
+	if len(action.Fields) == 0 {
+		return map[string]interface{}{}
+	}
+
+	properties := make(map[string]interface{}, len(action.Fields))
+	var required []string
+
+	for _, field := range action.Fields {
+		property := map[string]interface{}{"type": field.Type}
+
+		for _, rule := range field.Rules {
+			switch ruleName, argument, _ := strings.Cut(rule, "="); ruleName {
+			case router.RuleRequired:
+				required = append(required, field.Name)
+			case router.RuleOneOf:
+				property["enum"] = strings.Fields(argument)
+			case router.RuleEmail:
+				property["format"] = "email"
+			case router.RuleDomain:
+				property["format"] = "hostname"
+			}
+		}
+
+		if field.Type == "array" {
+			property = map[string]interface{}{"type": "array", "items": getItemSchema(property)}
+		}
+
+		properties[field.Name] = property
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	if action.StringData {
+		return map[string]interface{}{"oneOf": []interface{}{schema, map[string]interface{}{"type": "string"}}}
+	}
+
+	return schema
+}
+
+// getItemSchema describes elements of string slice fields, rules of such fields are applied to each element
+func getItemSchema(property map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{"type": "string"}
+
+	for key, value := range property {
+		if key != "type" {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+func withDescription(response map[string]interface{}, description string) map[string]interface{} {
+	result := map[string]interface{}{"description": description}
+
+	for key, value := range response {
+		result[key] = value
+	}
+
+	return result
+}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// getPeerCredentials returns credentials of the Unix socket client or nil for other connections
+func getPeerCredentials(conn net.Conn) (*peerCredentials, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+
+	if !ok {
+		return nil, nil
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get peer credentials: %v", err)
+	}
+
+	var ucred *syscall.Ucred
+	var ucredErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err == nil {
+		err = ucredErr
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get peer credentials: %v", err)
+	}
+
+	return &peerCredentials{uid: int(ucred.Uid), gid: int(ucred.Gid), pid: int(ucred.Pid)}, nil
+}
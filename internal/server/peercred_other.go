@@ -0,0 +1,17 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// getPeerCredentials returns credentials of the Unix socket client or nil for other connections
+func getPeerCredentials(conn net.Conn) (*peerCredentials, error) {
+	if _, ok := conn.(*net.UnixConn); ok {
+		return nil, errors.New("peer credentials are not supported on this platform")
+	}
+
+	return nil, nil
+}
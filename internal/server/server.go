@@ -1,40 +1,211 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/modules/jobs"
+	"github.com/r2dtools/sslbot/internal/pkg/audit"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
 	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/metrics"
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
 	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/r2dtools/sslbot/internal/pkg/webserver/reverter"
+	"github.com/r2dtools/sslbot/pkg/protocol"
 )
 
 const headerDataLength = 4 // bytes
 
+// peer describes the remote side of a connection
+type peer struct {
+	address     string
+	ip          string
+	certSubject string
+	// credentials are set for clients of the Unix socket
+	credentials *peerCredentials
+}
+
+type ServerStats = protocol.ServerStats
+
+var errShuttingDown = router.NewError(router.ErrorCodeShuttingDown, "server is shutting down")
+
+// rollbackGracePeriod is the time handlers get to stop and roll back their changes after the shutdown timed out
+var rollbackGracePeriod = 10 * time.Second
+
 type Server struct {
-	Port     int
-	Router   router.Router
-	Logger   logger.Logger
-	Config   *config.Config
-	listener net.Listener
+	Port   int
+	Router router.Router
+	Logger logger.Logger
+	Config *config.Config
+	// Jobs runs asynchronous requests. Asynchronous requests are rejected if it is nil.
+	Jobs *jobs.Manager
+	// AuditLog records mutating commands. The audit log can not be queried if it is nil.
+	AuditLog      *audit.Log
+	verifier      *auth.SignatureVerifier
+	limiter       *limiter
+	connSlots     chan struct{}
+	initOnce      sync.Once
+	mu            sync.Mutex
+	listeners     []net.Listener
+	conns         map[net.Conn]struct{}
+	connWg        sync.WaitGroup
+	shuttingDown  atomic.Bool
+	handledConns  atomic.Int64
+	rejectedConns atomic.Int64
+	// commands contains commands being handled by their sequence numbers, so the shutdown can report the ones that did not stop
+	commands   map[uint64]string
+	commandSeq uint64
+	commandWg  sync.WaitGroup
+	// ctx is the parent of request contexts, it is cancelled if the shutdown times out
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (s *Server) Serve() error {
 	port := strconv.Itoa(s.Port)
 	s.Logger.Info("starting TCP server on port %s ...", port)
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(s.Port))
+	listener, err := s.listen(":" + port)
 
 	if err != nil {
 		s.Logger.Error("error starting TCP server: %v", err)
 		return err
 	}
 
-	s.listener = listener
+	if err = s.checkAuthConfig(); err != nil {
+		listener.Close()
+		s.Logger.Error("error starting TCP server: %v", err)
+
+		return err
+	}
+
 	s.Logger.Info("TCP server successfully started")
+
+	return s.ServeListener(listener)
+}
+
+// Shutdown stops accepting connections and waits for the in-flight requests to finish.
+// If the context expires first, the requests are cancelled and their handlers get rollbackGracePeriod to roll back
+// their changes. Uncommitted changes of handlers that are still running are rolled back once the handlers return.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	s.mu.Lock()
+
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+
+	inProgress := len(s.conns)
+
+	// Interrupt connections waiting for a request: they are closed as soon as their in-flight requests are handled
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+
+	s.mu.Unlock()
+	s.Logger.Info("shutting down TCP server: %d connection(s) in progress", inProgress)
+
+	done := make(chan struct{})
+
+	go func() {
+		s.connWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.Logger.Info("TCP server stopped: %d connection(s) handled", s.handledConns.Load())
+
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	remaining := len(s.conns)
+	s.mu.Unlock()
+
+	// Handlers watching their contexts stop and roll back their changes themselves. Changes of handlers that are still
+	// running are rolled back when the handlers return, not concurrently with them.
+	s.cancel()
+	// Commands of the HTTP gateway are waited for too, their connections are not tracked by the TCP server
+	stopped := make(chan struct{})
+
+	go func() {
+		<-done
+		s.commandWg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(rollbackGracePeriod):
+	}
+
+	rolledBack, err := reverter.RollbackActive()
+
+	if err != nil {
+		s.Logger.Error("failed to roll back webserver configuration on shutdown: %v", err)
+	}
+
+	if commands := s.getRunningCommands(); len(commands) > 0 {
+		s.Logger.Error("commands are still running after %s of the rollback: %s", rollbackGracePeriod, strings.Join(commands, ", "))
+	}
+
+	s.Logger.Error("TCP server stopped: %d connection(s) handled, %d connection(s) interrupted, %d configuration change(s) rolled back", s.handledConns.Load(), remaining, rolledBack)
+
+	return fmt.Errorf("shutdown timed out: %d connection(s) interrupted", remaining)
+}
+
+// GetStats returns connection and rate limiting statistics
+func (s *Server) GetStats() ServerStats {
+	s.initOnce.Do(s.init)
+	s.mu.Lock()
+	activeConns := len(s.conns)
+	s.mu.Unlock()
+
+	return ServerStats{
+		ActiveConnections:   activeConns,
+		MaxConnections:      cap(s.connSlots),
+		HandledConnections:  s.handledConns.Load(),
+		RejectedConnections: s.rejectedConns.Load(),
+		LimiterStats:        s.limiter.getStats(time.Now()),
+	}
+}
+
+func (s *Server) init() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.verifier = auth.NewSignatureVerifier(s.Config.SignatureMaxAge, s.Config.NonceCacheSize)
+	s.limiter = newLimiter(s.Config.RateLimit, s.Config.RateLimitBurst, s.Config.AuthFailureLimit, s.Config.AuthLockoutDuration)
+
+	if s.Config.MaxConnections > 0 {
+		s.connSlots = make(chan struct{}, s.Config.MaxConnections)
+	}
+}
+
+// ServeListener accepts connections on the listener until it is closed or the server is shut down
+func (s *Server) ServeListener(listener net.Listener) error {
+	s.initOnce.Do(s.init)
+
+	if !s.addListener(listener) {
+		listener.Close()
+
+		return nil
+	}
+
 	defer listener.Close()
 
 	for {
@@ -42,105 +213,675 @@ func (s *Server) Serve() error {
 		conn, err := listener.Accept()
 
 		if err != nil {
+			if s.shuttingDown.Load() {
+				return nil
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
 			s.Logger.Error("error accepting remote connection: %v", err)
 			continue
 		}
 
+		if !s.acquireConnSlot() {
+			s.rejectedConns.Add(1)
+			s.Logger.Warning("connection from %v is rejected: maximum of %d concurrent connections is reached", conn.RemoteAddr(), cap(s.connSlots))
+			go s.rejectConn(conn, router.NewError(router.ErrorCodeRateLimited, "too many concurrent connections"))
+
+			continue
+		}
+
+		if !s.addConn(conn) {
+			s.releaseConnSlot()
+			conn.Close()
+
+			return nil
+		}
+
 		s.Logger.Info("accepted connection from the remote address: %v", conn.RemoteAddr())
-		go s.handleConn(conn)
+		go func() {
+			defer s.releaseConnSlot()
+			defer s.removeConn(conn)
+			s.handleConn(conn)
+		}()
 	}
 }
 
-func (s *Server) prepareResponse(data interface{}, err error) router.Response {
-	var response router.Response
+func (s *Server) acquireConnSlot() bool {
+	if s.connSlots == nil {
+		return true
+	}
+
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseConnSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// rejectConn notifies the client why the connection is rejected without reading its request
+func (s *Server) rejectConn(conn net.Conn, err error) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	writer := &responseWriter{conn: conn, server: s}
+	writer.write(s.prepareResponse(nil, nil, err))
+}
+
+func (s *Server) addListener(listener net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown.Load() {
+		return false
+	}
+
+	s.listeners = append(s.listeners, listener)
+
+	return true
+}
+
+func (s *Server) addConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown.Load() {
+		return false
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+
+	s.conns[conn] = struct{}{}
+	s.connWg.Add(1)
+
+	return true
+}
+
+func (s *Server) removeConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.handledConns.Add(1)
+	s.connWg.Done()
+}
+
+func (s *Server) checkAuthConfig() error {
+	if s.Config.TokenAuthDisabled && (s.Config.TlsDisabled || s.Config.TlsClientCaFile == "") {
+		return errors.New("token authentication can be disabled only if mutual TLS is configured")
+	}
+
+	if s.Config.TokenAuthDisabled && s.Config.RequestSigningRequired {
+		return errors.New("request signing requires token authentication")
+	}
+
+	return nil
+}
+
+func (s *Server) listen(address string) (net.Listener, error) {
+	tlsConfig, err := s.getListenerTlsConfig()
 
 	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil || tlsConfig == nil {
+		return listener, err
+	}
+
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+// getListenerTlsConfig returns the TLS config of the server listeners or nil if TLS is disabled
+func (s *Server) getListenerTlsConfig() (*tls.Config, error) {
+	if s.Config.TlsDisabled {
+		s.Logger.Warning("TLS is disabled: requests and tokens are transmitted in plaintext")
+
+		return nil, nil
+	}
+
+	return getTlsConfig(s.Config)
+}
+
+// handshakeFailed counts a failed TLS handshake as an authentication failure if client certificates are required:
+// the handshake fails if the client certificate is missing or not signed by the client CA
+func (s *Server) handshakeFailed() {
+	if s.Config.TlsClientCaFile == "" {
+		return
+	}
+
+	metrics.AuthFailures.Inc()
+	s.limiter.handshakeFailed()
+}
+
+func (s *Server) prepareResponse(request *router.Request, data interface{}, err error) router.Response {
+	response := router.Response{Version: 1}
+
+	if request != nil {
+		response.Id = request.Id
+		response.Version = router.NegotiateVersion(request.Version)
+	}
+
+	if err != nil {
+		responseErr := router.GetError(err)
 		response.Status = "error"
-		response.Error = err.Error()
+		response.Error = responseErr.Message
+		response.ErrorCode = responseErr.Code
+		response.ErrorDetails = responseErr.Details
 	} else {
 		response.Status = "ok"
 		response.Data = data
 	}
 
-	s.Logger.Debug("send response: %v", response)
+	loggedResponse := response
+	loggedResponse.Data = router.RedactData(response.Data)
+	s.Logger.Debug("send response: %v", loggedResponse)
 
 	return response
 }
 
-func (s *Server) getResponse(reader io.Reader) router.Response {
+func (s *Server) readData(reader io.Reader) ([]byte, error) {
 	dataLen, err := s.readDataLen(reader)
 
 	if err != nil {
-		return s.prepareResponse(nil, err)
+		return nil, err
+	}
+
+	if s.Config.MaxRequestSize > 0 && dataLen > s.Config.MaxRequestSize {
+		return nil, router.NewError(router.ErrorCodeRequestTooLarge, "request size %d bytes exceeds the maximum of %d bytes", dataLen, s.Config.MaxRequestSize)
+	}
+
+	data := make([]byte, dataLen)
+	rLen, err := io.ReadFull(reader, data)
+
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, router.NewError(router.ErrorCodeInvalidRequest, "request is truncated: received %d of %d bytes", rLen, dataLen)
+		}
+
+		return nil, fmt.Errorf("could not read request data: %w", err)
+	}
+
+	return data, nil
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	s.initOnce.Do(s.init)
+	peer := peer{address: conn.RemoteAddr().String()}
+	peer.ip, _, _ = net.SplitHostPort(peer.address)
+	s.setReadDeadline(conn)
+	certSubject, err := getPeerCertificateSubject(conn)
+
+	if err != nil {
+		s.handshakeFailed()
+		s.Logger.Error("%v: %v", peer.address, err)
+
+		return
+	}
+
+	if certSubject != "" {
+		peer.certSubject = certSubject
+		s.Logger.Info("client %s authenticated with certificate: %s", peer.address, certSubject)
+	}
+
+	writer := &responseWriter{conn: conn, server: s}
+	// Requests are cancelled if the client closes the connection before they are handled
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	credentials, err := getPeerCredentials(conn)
+
+	if err != nil {
+		s.Logger.Error("%v: %v", peer.address, err)
+
+		return
+	}
+
+	if credentials != nil {
+		// Rate limiting and lockouts are applied per local user
+		peer.address = "unix:" + credentials.String()
+		peer.ip = fmt.Sprintf("uid:%d", credentials.uid)
+		peer.credentials = credentials
+
+		if !s.isAllowedPeer(credentials) {
+			s.Logger.Warning("connection from %s is rejected: user is not allowed to use the socket", peer.address)
+			writer.write(s.prepareResponse(nil, nil, router.NewError(router.ErrorCodeUnauthorized, "uid %d is not allowed to use the socket", credentials.uid)))
+
+			return
+		}
+	}
+
+	data, err := s.readData(conn)
+
+	if err != nil {
+		if s.shuttingDown.Load() {
+			err = errShuttingDown
+		}
+
+		writer.write(s.prepareResponse(nil, nil, err))
+
+		return
 	}
 
-	var data []byte
-	buffer := make([]byte, 256)
-	rLen := 0
+	request, err := s.decodeRequest(data, peer)
+
+	if err != nil || !request.KeepAlive {
+		stopWatching := s.watchDisconnect(conn, cancel)
+		response := s.getResponse(ctx, request, data, err, peer, writer)
+		stopWatching()
+		writer.write(response)
+		s.Logger.Info("Connection successfully handled")
+
+		return
+	}
+
+	s.Logger.Info("client %s switched to persistent connection", peer.address)
+	s.servePersistent(ctx, cancel, conn, peer, writer, s.Config.ReadTimeout, data)
+	s.Logger.Info("Connection successfully handled")
+}
+
+// servePersistent handles requests of the persistent connection concurrently and sends responses as soon as they are ready.
+// data is the first request, the next one is read if it is nil. idleTimeout limits the time between requests if it is positive.
+// It returns the error that ended the connection after in-flight requests are handled.
+func (s *Server) servePersistent(ctx context.Context, cancel context.CancelFunc, conn net.Conn, peer peer, writer *responseWriter, idleTimeout time.Duration, data []byte) error {
+	var wg sync.WaitGroup
+	var err error
+	var inFlight chan struct{}
+
+	if s.Config.MaxInFlightRequests > 0 {
+		inFlight = make(chan struct{}, s.Config.MaxInFlightRequests)
+	}
 
 	for {
-		len, err := reader.Read(buffer)
+		// The next request is not read while the connection has the maximum of requests in flight,
+		// so the client can not start an unbounded number of handlers
+		acquireSlot(inFlight)
+
+		if data == nil {
+			// The read timeout limits the idle time between requests
+			if idleTimeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(idleTimeout))
+			}
+
+			if data, err = s.readData(conn); err != nil {
+				releaseSlot(inFlight)
 
-		if err != nil {
-			if err == io.EOF {
 				break
 			}
+		}
+
+		request, decodeErr := s.decodeRequest(data, peer)
+		wg.Add(1)
+		go func(request *router.Request, data []byte, err error) {
+			defer wg.Done()
+			defer releaseSlot(inFlight)
+			writer.write(s.getResponse(ctx, request, data, err, peer, writer))
+		}(request, data, decodeErr)
+		data = nil
+	}
+
+	var netErr net.Error
 
-			return s.prepareResponse(nil, err)
+	// In-flight requests are not cancelled on the idle timeout and on shutdown, they are handled before the connection is closed
+	if errors.Is(err, io.EOF) || (errors.As(err, &netErr) && !netErr.Timeout()) {
+		cancel()
+	}
+
+	wg.Wait()
+
+	switch {
+	case errors.Is(err, io.EOF):
+	case s.shuttingDown.Load():
+		s.Logger.Info("persistent connection with %s is closed on shutdown", peer.address)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		s.Logger.Info("persistent connection with %s is closed after being idle for %s", peer.address, idleTimeout)
+	default:
+		// The frame stream can not be recovered, so the client is notified before the connection is closed
+		writer.write(s.prepareResponse(nil, nil, err))
+	}
+
+	return err
+}
+
+// watchDisconnect cancels the request context if the client closes the connection while the request is handled.
+// The client does not send data before it receives the response, so the read only returns when the connection is closed
+// or the returned function stops watching.
+func (s *Server) watchDisconnect(conn net.Conn, cancel context.CancelFunc) func() {
+	done := make(chan struct{})
+	conn.SetReadDeadline(time.Time{})
+
+	go func() {
+		defer close(done)
+
+		_, err := conn.Read(make([]byte, 1))
+		var netErr net.Error
+
+		if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return
 		}
 
-		data = append(data, buffer[:len]...)
-		rLen += len
+		s.Logger.Info("client %s closed the connection before the response was sent", conn.RemoteAddr())
+		cancel()
+	}()
+
+	return func() {
+		conn.SetReadDeadline(time.Now())
+		<-done
+	}
+}
+
+func (s *Server) setReadDeadline(conn net.Conn) {
+	if s.Config.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.Config.ReadTimeout))
+	}
+}
+
+func (s *Server) decodeRequest(data []byte, peer peer) (*router.Request, error) {
+	s.Logger.Debug("received %d bytes from %s", len(data), peer.address)
+
+	var request router.Request
+	err := json.Unmarshal(data, &request)
+
+	if err != nil {
+		return nil, router.NewError(router.ErrorCodeInvalidRequest, "could not decode request data: %v", err)
+	}
+
+	return &request, nil
+}
+
+func (s *Server) getResponse(ctx context.Context, request *router.Request, data []byte, err error, peer peer, writer *responseWriter) router.Response {
+	if err != nil {
+		return s.prepareResponse(request, nil, err)
+	}
+
+	if request.Events {
+		request.Progress = writer.eventReporter(request)
+	}
+
+	var rawData []byte
+
+	if request.Signature != "" || s.Config.RequestSigningRequired {
+		// The signature covers the data exactly as it was sent
+		var rawRequest struct {
+			Data json.RawMessage
+		}
 
-		if rLen >= dataLen {
-			break
+		if err = json.Unmarshal(data, &rawRequest); err != nil {
+			return s.prepareResponse(request, nil, router.NewError(router.ErrorCodeInvalidRequest, "could not decode request data: %v", err))
 		}
+
+		rawData = rawRequest.Data
 	}
 
-	s.Logger.Debug("received data: %v", string(data))
-	responseData, err := s.handleRequest(data)
+	responseData, err := s.handleRequest(ctx, *request, rawData, peer)
 
-	return s.prepareResponse(responseData, err)
+	return s.prepareResponse(request, responseData, err)
 }
 
-func (s *Server) handleConn(conn net.Conn) {
-	defer conn.Close()
-	var response router.Response
+// handleRequest authenticates the request and dispatches it to the router. rawData is the JSON encoded request data used to verify the signature.
+func (s *Server) handleRequest(ctx context.Context, request router.Request, rawData []byte, peer peer) (interface{}, error) {
+	now := time.Now()
 
-	response = s.getResponse(conn)
+	if lockedUntil := s.limiter.lockedUntil(peer.ip, now); !lockedUntil.IsZero() {
+		return nil, router.NewError(router.ErrorCodeRateLimited, "too many authentication failures: try again after %s", lockedUntil.Format(time.RFC3339)).
+			WithDetails(map[string]interface{}{"LockedUntil": lockedUntil})
+	}
 
-	if response.Error != "" {
-		s.Logger.Error(response.Error)
+	if !s.limiter.allow(peer.ip, now) {
+		s.Logger.Warning("request from %s is rejected: rate limit exceeded", peer.address)
+
+		return nil, router.NewError(router.ErrorCodeRateLimited, "rate limit exceeded")
+	}
+
+	identity, err := s.authenticate(request, rawData, peer)
+
+	// A full nonce cache is caused by the request rate, not by the client credentials
+	if errors.Is(err, auth.ErrNonceCacheFull) {
+		return nil, router.WrapError(router.ErrorCodeRateLimited, err)
 	}
 
-	responseByte, err := json.Marshal(response)
 	if err != nil {
-		response = s.prepareResponse(nil, fmt.Errorf("could not encode response data: %v", err))
-		responseByte, _ = json.Marshal(response)
-		s.Logger.Error(response.Error)
+		metrics.AuthFailures.Inc()
+
+		if s.limiter.authFailed(peer.ip, now) {
+			s.Logger.Warning("client %s is locked out for %s after repeated authentication failures", peer.ip, s.Config.AuthLockoutDuration)
+		}
+
+		return nil, router.WrapError(router.ErrorCodeUnauthorized, err)
 	}
 
-	if err = s.writeData(conn, responseByte); err != nil {
-		s.Logger.Error(err.Error())
-		return
+	if !identity.Allows(request.GetCommand()) {
+		return nil, router.NewError(router.ErrorCodeForbidden, "token '%s' is not allowed to execute command '%s'", identity.Name, request.GetCommand())
 	}
 
-	s.Logger.Info("Connection successfully handled")
+	request.RemoteAddress = peer.address
+	request.ClientSubject = peer.certSubject
+	request.TokenName = identity.Name
+	request.Scopes = identity.Scopes
+
+	if request.Async {
+		return s.submitJob(request)
+	}
+
+	defer s.trackCommand(request.GetCommand())()
+
+	return s.Router.HandleRequest(ctx, request)
 }
 
-func (s *Server) handleRequest(data []byte) (interface{}, error) {
-	var request router.Request
-	err := json.Unmarshal(data, &request)
+// trackCommand adds the command to the running ones and returns the function removing it
+func (s *Server) trackCommand(command string) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.commands == nil {
+		s.commands = make(map[uint64]string)
+	}
+
+	s.commandSeq++
+	seq := s.commandSeq
+	s.commands[seq] = command
+	s.commandWg.Add(1)
+
+	return func() {
+		s.mu.Lock()
+		delete(s.commands, seq)
+		s.mu.Unlock()
+		s.commandWg.Done()
+	}
+}
+
+// getRunningCommands returns the running commands in the order they were started
+func (s *Server) getRunningCommands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seqs := slices.Sorted(maps.Keys(s.commands))
+	commands := make([]string, 0, len(seqs))
+
+	for _, seq := range seqs {
+		commands = append(commands, s.commands[seq])
+	}
+
+	return commands
+}
+
+// GetCommandTimeout returns the execution timeout of the request command: the first matching entry of command_timeouts
+// or command_timeout if no entry matches
+func (s *Server) GetCommandTimeout(request router.Request) time.Duration {
+	command := request.GetCommand()
+
+	for _, commandTimeout := range s.Config.CommandTimeouts {
+		if auth.MatchScope(commandTimeout.Command, command) {
+			return commandTimeout.Timeout
+		}
+	}
+
+	return s.Config.CommandTimeout
+}
+
+func (s *Server) submitJob(request router.Request) (*jobs.Job, error) {
+	if s.Jobs == nil {
+		return nil, errors.New("asynchronous requests are not supported")
+	}
+
+	if request.GetModule() == "jobs" {
+		return nil, fmt.Errorf("command '%s' can not be run asynchronously", request.GetCommand())
+	}
+
+	return s.Jobs.Submit(request)
+}
+
+// authenticate returns the identity of the request. Signed requests are authenticated with the signature, the others with the token.
+func (s *Server) authenticate(request router.Request, rawData []byte, peer peer) (*auth.Identity, error) {
+	// Socket clients are authenticated by the kernel and do not know the token to sign requests with
+	if peer.credentials != nil {
+		return &auth.Identity{Name: peer.ip, Scopes: []string{auth.AllScope}}, nil
+	}
+
+	if s.Config.TokenAuthDisabled {
+		if request.Signature != "" {
+			return nil, errors.New("request signature can not be verified without token authentication")
+		}
+
+		if peer.certSubject == "" {
+			return nil, errors.New("client certificate is required")
+		}
+
+		return &auth.Identity{Name: peer.certSubject, Scopes: []string{auth.AllScope}}, nil
+	}
+
+	if request.Signature != "" || s.Config.RequestSigningRequired {
+		return s.verifySignature(request, rawData, peer)
+	}
+
+	identity, err := auth.Authenticate(s.Config, request.Token, time.Now())
 
 	if err != nil {
-		return nil, fmt.Errorf("could not decode request data: %v", err)
+		var expiredErr *auth.ExpiredTokenError
+
+		if errors.As(err, &expiredErr) {
+			s.Logger.Warning("authentication failed for %s: token '%s' is expired", peer.address, expiredErr.Name)
+		} else {
+			s.Logger.Warning("authentication failed for %s: %v", peer.address, err)
+		}
+
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// verifySignature authenticates the signed request with the signing keys of its key id. Details of the failure are logged only,
+// so clients can not find out token names.
+func (s *Server) verifySignature(request router.Request, rawData []byte, peer peer) (*auth.Identity, error) {
+	if request.Signature == "" {
+		return nil, auth.ErrSignatureRequired
 	}
 
-	if request.Token == "" || request.Token != s.Config.Token {
-		return nil, fmt.Errorf("invalid request token is specified: %s", request.Token)
+	// The token must not be sent with signed requests, otherwise a captured request reveals it
+	if request.Token != "" {
+		return nil, errors.New("signed requests must not contain the token")
 	}
 
-	return s.Router.HandleRequest(request)
+	now := time.Now()
+	identity, publicKeys, err := auth.FindSigningKeys(s.Config, request.KeyId, now)
+
+	if err != nil {
+		s.Logger.Warning("request signature verification failed for %s: %v", peer.address, err)
+
+		return nil, auth.ErrInvalidSignature
+	}
+
+	signedRequest := auth.SignedRequest{
+		Version:   request.Version,
+		KeyId:     request.KeyId,
+		Command:   request.Command,
+		KeepAlive: request.KeepAlive,
+		Async:     request.Async,
+		Events:    request.Events,
+		Timestamp: request.Timestamp,
+		Nonce:     request.Nonce,
+		Data:      rawData,
+	}
+
+	if err = s.verifier.Verify(publicKeys, signedRequest, request.Signature, now); err != nil {
+		s.Logger.Warning("request signature verification failed for %s: %v", peer.address, err)
+
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// acquireSlot blocks until the semaphore has a free slot. A nil semaphore is unlimited.
+func acquireSlot(semaphore chan struct{}) {
+	if semaphore != nil {
+		semaphore <- struct{}{}
+	}
+}
+
+func releaseSlot(semaphore chan struct{}) {
+	if semaphore != nil {
+		<-semaphore
+	}
+}
+
+// responseWriter serializes responses written to the connection from concurrent request handlers
+type responseWriter struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	server *Server
+}
+
+// eventReporter sends progress events of the request as frames with the "event" status
+func (w *responseWriter) eventReporter(request *router.Request) progress.Reporter {
+	version := router.NegotiateVersion(request.Version)
+
+	return func(event progress.Event) {
+		w.write(router.Response{Version: version, Id: request.Id, Status: "event", Data: event})
+	}
+}
+
+// write sends the response frame. Errors are logged, they are also returned to writers that need to detect a broken connection.
+func (w *responseWriter) write(response router.Response) error {
+	if response.Error != "" {
+		w.server.Logger.Error(response.Error)
+	}
+
+	responseByte, err := json.Marshal(response)
+
+	if err != nil {
+		response = w.server.prepareResponse(&router.Request{Id: response.Id, Version: response.Version}, nil, fmt.Errorf("could not encode response data: %v", err))
+		responseByte, _ = json.Marshal(response)
+		w.server.Logger.Error(response.Error)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.server.Config.WriteTimeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.server.Config.WriteTimeout))
+	}
+
+	if err = w.server.writeData(w.conn, responseByte); err != nil {
+		w.server.Logger.Error(err.Error())
+	}
+
+	return err
 }
 
 func (s *Server) writeData(writer io.Writer, data []byte) error {
@@ -163,8 +904,14 @@ func (s *Server) writeData(writer io.Writer, data []byte) error {
 // readDataLen reads first bytes where data length is stored
 func (s *Server) readDataLen(reader io.Reader) (int, error) {
 	header := make([]byte, headerDataLength)
-	if _, err := reader.Read(header); err != nil {
-		return 0, fmt.Errorf("could not read data length: %v", err)
+	rLen, err := io.ReadFull(reader, header)
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, router.NewError(router.ErrorCodeInvalidRequest, "request header is truncated: received %d of %d bytes", rLen, headerDataLength)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("could not read data length: %w", err)
 	}
 
 	return int(binary.BigEndian.Uint32(header)), nil
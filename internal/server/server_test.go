@@ -0,0 +1,471 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/modules/jobs"
+	"github.com/r2dtools/sslbot/internal/pkg/auth"
+	"github.com/r2dtools/sslbot/internal/pkg/logger"
+	"github.com/r2dtools/sslbot/internal/pkg/progress"
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/r2dtools/sslbot/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "test-token"
+
+type stubHandler struct{}
+
+func (h *stubHandler) Handle(ctx context.Context, request router.Request) (interface{}, error) {
+	switch request.GetAction() {
+	case "echo":
+		return request.Data, nil
+	case "sleep":
+		time.Sleep(100 * time.Millisecond)
+
+		return "slept", nil
+	case "getStatus":
+		return "up", nil
+	case "progress":
+		request.Progress.Report(progress.StageDeploy, "first step")
+		request.Progress.Report(progress.StageReload, "second step")
+
+		return "done", nil
+	default:
+		return nil, router.NewInvalidActionError(request)
+	}
+}
+
+func (h *stubHandler) GetActions() []string {
+	return []string{"echo", "sleep", "getStatus", "progress"}
+}
+
+func TestSingleRequestConnection(t *testing.T) {
+	conn := startTestConn(t, getTestServer(t))
+	writeTestFrame(t, conn, router.Request{Command: "test.echo", Token: testToken, Data: "hello"})
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "ok", response.Status)
+	assert.Equal(t, "hello", response.Data)
+
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestInvalidTokenIsNotEchoed(t *testing.T) {
+	conn := startTestConn(t, getTestServer(t))
+	writeTestFrame(t, conn, router.Request{Command: "test.echo", Token: "wrong-secret"})
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "error", response.Status)
+	assert.NotContains(t, response.Error, "wrong-secret")
+}
+
+func TestSignedRequestWithTokenIsRejected(t *testing.T) {
+	conn := startTestConn(t, getTestServer(t))
+	request := router.Request{Version: 2, Command: "test.echo", KeyId: auth.DefaultIdentity, Timestamp: time.Now().Unix(), Nonce: "nonce"}
+	request.Signature = protocol.SignRequest(testToken, protocol.SignedRequest{
+		Version:   request.Version,
+		KeyId:     request.KeyId,
+		Command:   request.Command,
+		Timestamp: request.Timestamp,
+		Nonce:     request.Nonce,
+		Data:      []byte("null"),
+	})
+	request.Token = testToken
+	writeTestFrame(t, conn, request)
+	response := readTestFrame(t, conn)
+	assert.Equal(t, router.ErrorCodeUnauthorized, response.ErrorCode)
+	assert.Contains(t, response.Error, "signed requests must not contain the token")
+}
+
+func TestErrorCodes(t *testing.T) {
+	conn := startTestConn(t, getTestServer(t))
+	writeTestFrame(t, conn, router.Request{Id: "1", Command: "test.unknown", Token: testToken, Version: 2, KeepAlive: true})
+	response := readTestFrame(t, conn)
+	assert.Equal(t, 2, response.Version)
+	assert.Equal(t, "error", response.Status)
+	assert.Equal(t, router.ErrorCodeNotFound, response.ErrorCode)
+
+	writeTestFrame(t, conn, router.Request{Id: "2", Command: "test.echo", Token: "wrong", Version: 2})
+	response = readTestFrame(t, conn)
+	assert.Equal(t, router.ErrorCodeUnauthorized, response.ErrorCode)
+
+	// Clients of the first protocol version get the error message only
+	writeTestFrame(t, conn, router.Request{Id: "3", Command: "test.unknown", Token: testToken})
+	response = readTestFrame(t, conn)
+	assert.Equal(t, 1, response.Version)
+	assert.Equal(t, "invalid action 'unknown' for module 'test'", response.Error)
+	assert.Empty(t, response.ErrorCode)
+}
+
+func TestPersistentConnection(t *testing.T) {
+	conn := startTestConn(t, getTestServer(t))
+	writeTestFrame(t, conn, router.Request{Id: "1", Command: "test.sleep", Token: testToken, KeepAlive: true})
+	writeTestFrame(t, conn, router.Request{Id: "2", Command: "test.echo", Token: testToken, Data: "fast"})
+
+	// The fast request is answered before the slow one
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "2", response.Id)
+	assert.Equal(t, "fast", response.Data)
+
+	response = readTestFrame(t, conn)
+	assert.Equal(t, "1", response.Id)
+	assert.Equal(t, "slept", response.Data)
+
+	writeTestFrame(t, conn, router.Request{Id: "3", Command: "test.unknown", Token: testToken})
+	response = readTestFrame(t, conn)
+	assert.Equal(t, "3", response.Id)
+	assert.Equal(t, "error", response.Status)
+}
+
+func TestMaxInFlightRequests(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.MaxInFlightRequests = 1
+	conn := startTestConn(t, server)
+	writeTestFrame(t, conn, router.Request{Id: "1", Command: "test.sleep", Token: testToken, KeepAlive: true})
+	writeTestFrame(t, conn, router.Request{Id: "2", Command: "test.echo", Token: testToken, Data: "fast"})
+
+	// The second request is not read before the first one is answered
+	assert.Equal(t, "1", readTestFrame(t, conn).Id)
+	assert.Equal(t, "2", readTestFrame(t, conn).Id)
+}
+
+func TestDisconnectCancelsRequest(t *testing.T) {
+	for _, keepAlive := range []bool{false, true} {
+		started := make(chan struct{}, 1)
+		handlerErr := make(chan error, 1)
+		server := getTestServer(t)
+		server.Router.RegisterHandler("wait", router.NewModule(router.NewActionWithoutData("wait", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			handlerErr <- ctx.Err()
+
+			return nil, ctx.Err()
+		})))
+
+		conn := startTestConn(t, server)
+		writeTestFrame(t, conn, router.Request{Command: "wait.wait", Token: testToken, KeepAlive: keepAlive})
+		<-started
+		conn.Close()
+
+		select {
+		case err := <-handlerErr:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatalf("request is not cancelled after the client disconnected, keepalive: %v", keepAlive)
+		}
+	}
+}
+
+func TestTimedOutRequestIsAnsweredAfterRollback(t *testing.T) {
+	var rolledBack atomic.Bool
+	server := getTestServer(t)
+	server.Router.RegisterHandler("deploy", router.NewModule(router.NewActionWithoutData("deploy", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+		<-ctx.Done()
+		// Rolling back the changes takes some time after the cancellation
+		time.Sleep(50 * time.Millisecond)
+		rolledBack.Store(true)
+
+		return nil, ctx.Err()
+	})))
+	server.Router.Use(router.TimeoutMiddleware(func(request router.Request) time.Duration {
+		return 20 * time.Millisecond
+	}))
+
+	conn := startTestConn(t, server)
+	writeTestFrame(t, conn, router.Request{Command: "deploy.deploy", Token: testToken, Version: 2})
+	response := readTestFrame(t, conn)
+
+	assert.Equal(t, router.ErrorCodeTimeout, response.ErrorCode)
+	assert.True(t, rolledBack.Load())
+}
+
+func TestProgressEvents(t *testing.T) {
+	conn := startTestConn(t, getTestServer(t))
+	writeTestFrame(t, conn, router.Request{Id: "1", Command: "test.progress", Token: testToken, Events: true})
+
+	for _, message := range []string{"first step", "second step"} {
+		response := readTestFrame(t, conn)
+		assert.Equal(t, "1", response.Id)
+		assert.Equal(t, "event", response.Status)
+		assert.Equal(t, message, response.Data.(map[string]interface{})["Message"])
+	}
+
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "ok", response.Status)
+	assert.Equal(t, "done", response.Data)
+
+	// Events are not sent unless they are requested
+	conn = startTestConn(t, getTestServer(t))
+	writeTestFrame(t, conn, router.Request{Command: "test.progress", Token: testToken})
+	assert.Equal(t, "done", readTestFrame(t, conn).Data)
+}
+
+func TestOversizedRequest(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.MaxRequestSize = 16
+	conn := startTestConn(t, server)
+	writeTestFrame(t, conn, router.Request{Command: "test.echo", Token: testToken, Data: "hello"})
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "error", response.Status)
+	assert.Contains(t, response.Error, "exceeds the maximum of 16 bytes")
+}
+
+func TestTruncatedRequest(t *testing.T) {
+	conn := startTestConn(t, getTestServer(t))
+	header := make([]byte, headerDataLength)
+	binary.BigEndian.PutUint32(header, 100)
+
+	go func() {
+		conn.Write(header)
+		conn.Write([]byte(`{"Command":`))
+		conn.CloseWrite()
+	}()
+
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "error", response.Status)
+	assert.Equal(t, "request is truncated: received 11 of 100 bytes", response.Error)
+}
+
+func TestIdlePersistentConnection(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.ReadTimeout = 50 * time.Millisecond
+	conn := startTestConn(t, server)
+	writeTestFrame(t, conn, router.Request{Id: "1", Command: "test.echo", Token: testToken, KeepAlive: true})
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "1", response.Id)
+
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	server := getTestServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ServeListener(listener)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	writeTestFrame(t, conn, router.Request{Id: "1", Command: "test.sleep", Token: testToken, KeepAlive: true})
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.Nil(t, server.Shutdown(ctx))
+	assert.Nil(t, <-serveErr)
+
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "1", response.Id)
+	assert.Equal(t, "slept", response.Data)
+
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.NotNil(t, err)
+}
+
+func TestShutdownTimeoutWaitsForRollback(t *testing.T) {
+	var rolledBack atomic.Bool
+	server := getTestServer(t)
+	started := make(chan struct{})
+	server.Router.RegisterHandler("deploy", router.NewModule(router.NewActionWithoutData("deploy", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		// Rolling back the changes takes some time after the cancellation
+		time.Sleep(50 * time.Millisecond)
+		rolledBack.Store(true)
+
+		return nil, ctx.Err()
+	})))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.ServeListener(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	writeTestFrame(t, conn, router.Request{Command: "deploy.deploy", Token: testToken})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorContains(t, server.Shutdown(ctx), "shutdown timed out")
+	assert.True(t, rolledBack.Load())
+	assert.Empty(t, server.getRunningCommands())
+}
+
+func TestShutdownStopsWaitingAfterRollbackGracePeriod(t *testing.T) {
+	defer func(gracePeriod time.Duration) { rollbackGracePeriod = gracePeriod }(rollbackGracePeriod)
+	rollbackGracePeriod = 20 * time.Millisecond
+
+	server := getTestServer(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server.Router.RegisterHandler("deploy", router.NewModule(router.NewActionWithoutData("deploy", "", func(ctx context.Context, request router.Request) (interface{}, error) {
+		close(started)
+		// The handler does not watch its context
+		<-release
+
+		return nil, nil
+	})))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.ServeListener(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	writeTestFrame(t, conn, router.Request{Command: "deploy.deploy", Token: testToken})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, server.Shutdown(ctx))
+	assert.Equal(t, []string{"deploy.deploy"}, server.getRunningCommands())
+}
+
+func TestAuthFailureLockout(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.AuthFailureLimit = 2
+	server.Config.AuthLockoutDuration = time.Minute
+
+	for range 2 {
+		conn := startTestConn(t, server)
+		writeTestFrame(t, conn, router.Request{Command: "test.echo", Token: "wrong"})
+		assert.Equal(t, auth.ErrInvalidToken.Error(), readTestFrame(t, conn).Error)
+	}
+
+	conn := startTestConn(t, server)
+	writeTestFrame(t, conn, router.Request{Command: "test.echo", Token: testToken})
+	assert.Contains(t, readTestFrame(t, conn).Error, "too many authentication failures")
+	assert.Len(t, server.GetStats().LockedClients, 1)
+}
+
+func TestMaxConnections(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.MaxConnections = 1
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.ServeListener(listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	writeTestFrame(t, conn, router.Request{Id: "1", Command: "test.echo", Token: testToken, KeepAlive: true})
+	assert.Equal(t, "1", readTestFrame(t, conn).Id)
+
+	rejectedConn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer rejectedConn.Close()
+	assert.Equal(t, "too many concurrent connections", readTestFrame(t, rejectedConn).Error)
+	assert.Equal(t, int64(1), server.GetStats().RejectedConnections)
+}
+
+func getTestServer(t *testing.T) *Server {
+	hash, err := auth.HashSecret(testToken)
+	assert.Nil(t, err)
+
+	r := router.Router{}
+	r.RegisterHandler("test", &stubHandler{})
+
+	return &Server{
+		Router: r,
+		Logger: &logger.NilLogger{},
+		Config: &config.Config{TokenHash: hash, TokenSigningKey: protocol.SigningPublicKey(testToken)},
+	}
+}
+
+func startTestConn(t *testing.T, server *Server) *net.TCPConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		serverConn, err := listener.Accept()
+
+		if err == nil {
+			server.handleConn(serverConn)
+		}
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	t.Cleanup(func() { clientConn.Close() })
+
+	return clientConn.(*net.TCPConn)
+}
+
+func writeTestFrame(t *testing.T, writer io.Writer, request router.Request) {
+	data, err := json.Marshal(request)
+	assert.Nil(t, err)
+
+	frame := make([]byte, headerDataLength+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[headerDataLength:], data)
+
+	_, err = writer.Write(frame)
+	assert.Nil(t, err)
+}
+
+func readTestFrame(t *testing.T, reader io.Reader) router.Response {
+	header := make([]byte, headerDataLength)
+	_, err := io.ReadFull(reader, header)
+	assert.Nil(t, err)
+
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	_, err = io.ReadFull(reader, data)
+	assert.Nil(t, err)
+
+	var response router.Response
+	assert.Nil(t, json.Unmarshal(data, &response))
+
+	return response
+}
+
+func TestAsyncRequest(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.VarDir = t.TempDir()
+	manager, err := jobs.NewManager(server.Config, server.Logger, server.Router.HandleRequest)
+	assert.Nil(t, err)
+	defer manager.Shutdown(context.Background())
+	server.Jobs = manager
+
+	conn := startTestConn(t, server)
+	writeTestFrame(t, conn, router.Request{Command: "test.echo", Token: testToken, Data: "hello", Async: true})
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "ok", response.Status, response.Error)
+
+	jobId := response.Data.(map[string]interface{})["Id"].(string)
+	assert.Eventually(t, func() bool {
+		job, err := manager.Get(jobId)
+
+		return err == nil && job.Status == jobs.StatusSucceeded && job.Result == "hello"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGetCommandTimeout(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.CommandTimeout = time.Minute
+	server.Config.CommandTimeouts = []config.CommandTimeout{
+		{Command: "certificates.issue", Timeout: 15 * time.Minute},
+		{Command: "certificates.*", Timeout: 5 * time.Minute},
+	}
+
+	assert.Equal(t, 15*time.Minute, server.GetCommandTimeout(router.Request{Command: "certificates.issue"}))
+	assert.Equal(t, 5*time.Minute, server.GetCommandTimeout(router.Request{Command: "certificates.upload"}))
+	assert.Equal(t, time.Minute, server.GetCommandTimeout(router.Request{Command: "getVhosts"}))
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/r2dtools/sslbot/config"
+	"github.com/r2dtools/sslbot/internal/pkg/certificate"
+	"github.com/unknwon/com"
+)
+
+const (
+	defaultTlsCertFileName = "server.crt"
+	defaultTlsKeyFileName  = "server.key"
+)
+
+// GetServerCertificatePaths returns paths to the TLS certificate and key used by the server.
+// If they are not configured, the self-generated ones inside the var directory are used.
+func GetServerCertificatePaths(config *config.Config) (certPath string, keyPath string) {
+	certPath = config.TlsCertFile
+	keyPath = config.TlsKeyFile
+
+	if certPath == "" || keyPath == "" {
+		certPath = config.GetPathInsideVarDir("tls", defaultTlsCertFileName)
+		keyPath = config.GetPathInsideVarDir("tls", defaultTlsKeyFileName)
+	}
+
+	return certPath, keyPath
+}
+
+// EnsureServerCertificate generates a self-signed server certificate if no certificate is configured or generated yet
+func EnsureServerCertificate(config *config.Config) (certPath string, keyPath string, err error) {
+	certPath, keyPath = GetServerCertificatePaths(config)
+
+	if com.IsFile(certPath) && com.IsFile(keyPath) {
+		return certPath, keyPath, nil
+	}
+
+	if config.TlsCertFile != "" && config.TlsKeyFile != "" {
+		return "", "", fmt.Errorf("configured TLS certificate '%s' or key '%s' does not exist", certPath, keyPath)
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	if hostName, err := os.Hostname(); err == nil && hostName != "" {
+		hosts = append([]string{hostName}, hosts...)
+	}
+
+	certPem, keyPem, err := certificate.GenerateSelfSignedCertificate(hosts[0], hosts)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return "", "", fmt.Errorf("could not create TLS directory: %v", err)
+	}
+
+	if err = os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		return "", "", fmt.Errorf("could not write TLS key: %v", err)
+	}
+
+	if err = os.WriteFile(certPath, certPem, 0644); err != nil {
+		return "", "", fmt.Errorf("could not write TLS certificate: %v", err)
+	}
+
+	return certPath, keyPath, nil
+}
+
+func getTlsConfig(config *config.Config) (*tls.Config, error) {
+	certPath, keyPath, err := EnsureServerCertificate(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.TlsClientCaFile != "" {
+		caPem, err := os.ReadFile(config.TlsClientCaFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA bundle: %v", err)
+		}
+
+		clientCAs := x509.NewCertPool()
+
+		if !clientCAs.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("could not find certificates in client CA bundle '%s'", config.TlsClientCaFile)
+		}
+
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// getPeerCertificateSubject returns the subject of the verified client certificate if the connection is a TLS one
+func getPeerCertificateSubject(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+
+	if !ok {
+		return "", nil
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		return "", fmt.Errorf("TLS handshake failed: %v", err)
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+
+	if len(certs) == 0 {
+		return "", nil
+	}
+
+	return certs[0].Subject.String(), nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// peerCredentials identifies the process on the other side of a Unix socket connection
+type peerCredentials struct {
+	uid int
+	gid int
+	pid int
+}
+
+func (c *peerCredentials) String() string {
+	return fmt.Sprintf("uid=%d gid=%d pid=%d", c.uid, c.gid, c.pid)
+}
+
+// ServeUnix listens on the local administration socket. Its clients are authenticated by peer credentials instead of a token.
+func (s *Server) ServeUnix() error {
+	path := s.Config.UnixSocketPath
+	s.Logger.Info("starting Unix socket server on %s ...", path)
+	listener, err := s.listenUnix(path)
+
+	if err != nil {
+		s.Logger.Error("error starting Unix socket server: %v", err)
+		return err
+	}
+
+	s.Logger.Info("Unix socket server successfully started")
+
+	return s.ServeListener(listener)
+}
+
+func (s *Server) listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create socket directory: %v", err)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(path, s.Config.UnixSocketMode); err != nil {
+		listener.Close()
+
+		return nil, fmt.Errorf("could not change socket permissions: %v", err)
+	}
+
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket file left by an agent that was not stopped gracefully
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("file '%s' exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+
+		return fmt.Errorf("socket '%s' is already in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// isAllowedPeer checks whether the socket client runs as root, as the agent user or as one of the allowed users or groups
+func (s *Server) isAllowedPeer(credentials *peerCredentials) bool {
+	if credentials.uid == 0 || credentials.uid == os.Getuid() {
+		return true
+	}
+
+	return slices.Contains(s.Config.UnixSocketAllowedUids, credentials.uid) || slices.Contains(s.Config.UnixSocketAllowedGids, credentials.gid)
+}
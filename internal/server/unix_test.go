@@ -0,0 +1,62 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/r2dtools/sslbot/internal/pkg/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixSocketRequest(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.UnixSocketPath = filepath.Join(t.TempDir(), "sslbot.sock")
+	server.Config.UnixSocketMode = 0600
+	server.Config.RequestSigningRequired = true
+
+	// A socket file left after a crash is replaced
+	staleListener, err := net.Listen("unix", server.Config.UnixSocketPath)
+	assert.Nil(t, err)
+	staleListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	staleListener.Close()
+
+	go server.ServeUnix()
+	defer server.Shutdown(context.Background())
+
+	var conn net.Conn
+
+	assert.Eventually(t, func() bool {
+		conn, err = net.Dial("unix", server.Config.UnixSocketPath)
+
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer conn.Close()
+
+	info, err := os.Stat(server.Config.UnixSocketPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The current user is allowed without a token and a signature
+	writeTestFrame(t, conn, router.Request{Command: "test.echo", Data: "hello"})
+	response := readTestFrame(t, conn)
+	assert.Equal(t, "ok", response.Status, response.Error)
+	assert.Equal(t, "hello", response.Data)
+}
+
+func TestIsAllowedPeer(t *testing.T) {
+	server := getTestServer(t)
+	server.Config.UnixSocketAllowedUids = []int{1001}
+	server.Config.UnixSocketAllowedGids = []int{2001}
+
+	assert.True(t, server.isAllowedPeer(&peerCredentials{uid: 0, gid: 0}))
+	assert.True(t, server.isAllowedPeer(&peerCredentials{uid: os.Getuid(), gid: os.Getgid()}))
+	assert.True(t, server.isAllowedPeer(&peerCredentials{uid: 1001, gid: 1001}))
+	assert.True(t, server.isAllowedPeer(&peerCredentials{uid: 1002, gid: 2001}))
+	assert.False(t, server.isAllowedPeer(&peerCredentials{uid: 1002, gid: 1002}))
+}
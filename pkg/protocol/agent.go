@@ -0,0 +1,111 @@
+package protocol
+
+import "time"
+
+const (
+	HealthStatusOk     = "ok"
+	HealthStatusFailed = "failed"
+)
+
+// Capabilities contains the protocol version and features supported by the agent
+type Capabilities struct {
+	ProtocolVersion int
+	AgentVersion    string
+	Features        []string
+	// Modules contains actions of the registered modules
+	Modules    map[string][]string
+	WebServers []WebServerCapabilities
+	AcmeClient *AcmeClientInfo `json:",omitempty"`
+	// AcmeClientError is set if the ACME client is not available
+	AcmeClientError string `json:",omitempty"`
+}
+
+type WebServerCapabilities struct {
+	Code    string
+	Version string `json:",omitempty"`
+	// Error is set if the webserver version could not be detected, e.g. it is not installed
+	Error string `json:",omitempty"`
+}
+
+// AcmeClientInfo describes the ACME client used to issue certificates
+type AcmeClientInfo struct {
+	Name           string
+	Version        string
+	ChallengeTypes []string
+	DnsProviders   []string
+}
+
+type ServerStats struct {
+	ActiveConnections   int
+	MaxConnections      int
+	HandledConnections  int64
+	RejectedConnections int64
+	LimiterStats
+}
+
+type LimiterStats struct {
+	RateLimitedRequests int64
+	AuthFailures        int64
+	LockedOutRequests   int64
+	LockedClients       []LockedClient
+}
+
+type LockedClient struct {
+	Ip          string
+	LockedUntil time.Time
+}
+
+// RotatedToken is the new main token of the agent. The previous token is accepted until PreviousTokenExpiresAt.
+type RotatedToken struct {
+	Token                  string
+	PreviousTokenExpiresAt time.Time
+}
+
+// Redact returns the copy without the token to be logged or persisted instead of the token
+func (t *RotatedToken) Redact() interface{} {
+	return &RotatedToken{Token: "[redacted]", PreviousTokenExpiresAt: t.PreviousTokenExpiresAt}
+}
+
+type Health struct {
+	// Status is ok if all checks passed
+	Status string
+	Checks []HealthCheck
+}
+
+type HealthCheck struct {
+	Name   string
+	Status string
+	// Message describes the checked object if the check passed, e.g. the ACME client version
+	Message string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// AuditEntry is a line of the audit log
+type AuditEntry struct {
+	Time          time.Time
+	RemoteAddress string `json:",omitempty"`
+	TokenName     string `json:",omitempty"`
+	ClientSubject string `json:",omitempty"`
+	Command       string
+	// Params are the request data with values of sensitive parameters redacted
+	Params   interface{} `json:",omitempty"`
+	Vhost    string      `json:",omitempty"`
+	CertName string      `json:",omitempty"`
+	// Files are webserver configuration files created, changed or enabled by the command
+	Files     []string `json:",omitempty"`
+	Status    string
+	ErrorCode string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// AuditLogFilter selects audit log entries. Since and Until are RFC 3339 timestamps.
+type AuditLogFilter struct {
+	Command   string
+	TokenName string
+	Vhost     string
+	CertName  string
+	Status    string `validate:"oneof=ok error"`
+	Since     string
+	Until     string
+	Limit     int
+}
@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	StageIssue    = "issue"
+	StageAcme     = "acme"
+	StageDeploy   = "deploy"
+	StageReload   = "reload"
+	StageRollback = "rollback"
+)
+
+// Event describes a step of a long-running operation
+type Event struct {
+	Stage   string
+	Message string
+	Time    time.Time
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s [%s] %s", e.Time.Format(time.RFC3339), e.Stage, e.Message)
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import "time"
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// Job is a request run in the background
+type Job struct {
+	Id      string
+	Command string
+	// TokenName is the name of the token the job is submitted with
+	TokenName  string
+	Status     string
+	Error      string      `json:",omitempty"`
+	ErrorCode  string      `json:",omitempty"`
+	Result     interface{} `json:",omitempty"`
+	Output     string      `json:",omitempty"`
+	CreatedAt  time.Time
+	StartedAt  time.Time `json:",omitzero"`
+	FinishedAt time.Time `json:",omitzero"`
+}
+
+func (j *Job) IsFinished() bool {
+	return j.Status == JobStatusSucceeded || j.Status == JobStatusFailed || j.Status == JobStatusCancelled
+}
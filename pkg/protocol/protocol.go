@@ -0,0 +1,38 @@
+// Package protocol contains the types of the agent protocol shared by the agent and its clients
+package protocol
+
+// ProtocolVersion is the version of the request and response format supported by the agent
+const ProtocolVersion = 2
+
+// Error codes sent in responses of protocol version 2
+const (
+	ErrorCodeInternal              = "internal_error"
+	ErrorCodeInvalidRequest        = "invalid_request"
+	ErrorCodeNotFound              = "not_found"
+	ErrorCodeUnauthorized          = "unauthorized"
+	ErrorCodeForbidden             = "forbidden"
+	ErrorCodeRateLimited           = "rate_limited"
+	ErrorCodeRequestTooLarge       = "request_too_large"
+	ErrorCodeShuttingDown          = "shutting_down"
+	ErrorCodeTimeout               = "timeout"
+	ErrorCodeCancelled             = "cancelled"
+	ErrorCodeAcmeValidationFailed  = "acme_validation_failed"
+	ErrorCodeDeployFailed          = "deploy_failed"
+	ErrorCodeWebServerReloadFailed = "webserver_reload_failed"
+	ErrorCodeRollbackFailed        = "rollback_failed"
+)
+
+// Error is the error object of protocol version 2 responses
+type Error struct {
+	Code    string
+	Message string
+	Details interface{} `json:",omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// DefaultKeyId identifies the main token of the agent in signed requests
+const DefaultKeyId = "default"
+
+// signingKeyContext separates the signing key derived from a token from other uses of the token
+const signingKeyContext = "sslbot request signing\n"
+
+// SignedRequest contains the request fields covered by the signature
+type SignedRequest struct {
+	Version int
+	// KeyId identifies the token whose key signs the request: the token name or DefaultKeyId for the main token
+	KeyId     string
+	Command   string
+	KeepAlive bool
+	Async     bool
+	Events    bool
+	Timestamp int64
+	Nonce     string
+	// Data is the exact JSON text of the request data
+	Data []byte
+}
+
+// Message returns the signed text: the fields joined with "\n"
+func (r SignedRequest) Message() []byte {
+	lines := []string{
+		strconv.Itoa(r.Version),
+		r.KeyId,
+		r.Command,
+		strconv.FormatBool(r.KeepAlive),
+		strconv.FormatBool(r.Async),
+		strconv.FormatBool(r.Events),
+		strconv.FormatInt(r.Timestamp, 10),
+		r.Nonce,
+		string(r.Data),
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// SigningKey returns the Ed25519 key derived from the token secret. Signed requests do not contain the token,
+// so a captured request can not be used to sign other requests. Ed25519 is used instead of HMAC-SHA256, because
+// HMAC would require the agent to store the token or an equivalent key: the agent stores only the public key,
+// which can not sign requests if the config file leaks.
+func SigningKey(secret string) ed25519.PrivateKey {
+	seed := sha256.Sum256([]byte(signingKeyContext + secret))
+
+	return ed25519.NewKeyFromSeed(seed[:])
+}
+
+// SigningPublicKey returns the hex encoded public key of the token secret. The agent stores it to verify signed requests.
+func SigningPublicKey(secret string) string {
+	return hex.EncodeToString(SigningKey(secret).Public().(ed25519.PublicKey))
+}
+
+// SignRequest returns the hex encoded Ed25519 signature of the request made with the key derived from the token secret
+func SignRequest(secret string, request SignedRequest) string {
+	return hex.EncodeToString(ed25519.Sign(SigningKey(secret), request.Message()))
+}
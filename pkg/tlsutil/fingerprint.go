@@ -0,0 +1,51 @@
+// Package tlsutil contains TLS helpers shared by the agent and its clients
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// FormatFingerprint formats SHA-256 digest of DER encoded certificate as colon separated hex pairs
+func FormatFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// NormalizeFingerprint removes colons and surrounding whitespace and converts the fingerprint to upper case,
+// so fingerprints with and without colons can be compared
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
+
+// GetFingerprintTlsConfig returns the TLS configuration trusting only the certificate with the SHA-256 fingerprint.
+// peerName names the remote side in verification errors.
+func GetFingerprintTlsConfig(fingerprint, peerName string) *tls.Config {
+	expected := NormalizeFingerprint(fingerprint)
+
+	return &tls.Config{
+		// A self-signed certificate can not be verified by a CA, it is verified by its fingerprint instead
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("%s did not present a certificate", peerName)
+			}
+
+			if NormalizeFingerprint(FormatFingerprint(rawCerts[0])) != expected {
+				return fmt.Errorf("%s certificate does not match the fingerprint", peerName)
+			}
+
+			return nil
+		},
+	}
+}
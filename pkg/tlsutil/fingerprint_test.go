@@ -0,0 +1,32 @@
+package tlsutil_test
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/r2dtools/sslbot/internal/pkg/certificate"
+	"github.com/r2dtools/sslbot/pkg/tlsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeFingerprint(t *testing.T) {
+	assert.Equal(t, "AB01CD", tlsutil.NormalizeFingerprint(" ab:01:cd\n"))
+}
+
+func TestGetFingerprintTlsConfig(t *testing.T) {
+	certPem, keyPem, err := certificate.GenerateSelfSignedCertificate("example.com", []string{"example.com"})
+	assert.Nil(t, err)
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.Nil(t, err)
+	fingerprint, err := certificate.GetFingerprint(certPem)
+	assert.Nil(t, err)
+
+	// Fingerprints are compared without colons and case
+	tlsConfig := tlsutil.GetFingerprintTlsConfig(strings.ToLower(strings.ReplaceAll(fingerprint, ":", "")), "agent")
+	assert.Nil(t, tlsConfig.VerifyPeerCertificate(cert.Certificate, nil))
+	assert.EqualError(t, tlsConfig.VerifyPeerCertificate(nil, nil), "agent did not present a certificate")
+
+	tlsConfig = tlsutil.GetFingerprintTlsConfig("00:11", "control plane")
+	assert.EqualError(t, tlsConfig.VerifyPeerCertificate(cert.Certificate, nil), "control plane certificate does not match the fingerprint")
+}